@@ -0,0 +1,319 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package aggregate aggregates distribution metrics in the process, so that
+// each observation recorded with IntDistribution.Record or
+// DurationDistribution.Record only updates a histogram or summary instead of
+// reaching a slow exporter.
+//
+// A Handler keeps the state of each distribution for each set of labels it
+// is recorded with, and readers, such as a periodic exporter, take
+// snapshots of it.
+package aggregate
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/exp/event"
+)
+
+// Options configures a Handler.
+type Options struct {
+	// Aggregation returns how to aggregate a metric. If it's nil, or returns
+	// nil, the metric is aggregated with Exponential(160).
+	Aggregation func(event.Metric) Aggregation
+}
+
+// Handler is an event.Handler that aggregates IntDistribution and
+// DurationDistribution metric events. It passes all other events on to the
+// next handler, if there is one.
+//
+// Values of IntDistributions are aggregated as they are, and those of
+// DurationDistributions in seconds.
+type Handler struct {
+	next event.Handler
+	opts Options
+
+	mu     sync.Mutex
+	series map[seriesKey]*series
+}
+
+var _ event.Handler = (*Handler)(nil)
+
+// seriesKey identifies a metric with a set of labels.
+type seriesKey struct {
+	metric event.Metric
+	labels string // the labels, formatted with labelKey
+}
+
+// series is the state of a metric with a set of labels.
+type series struct {
+	metric event.Metric
+	labels []event.Label
+	agg    aggregator
+}
+
+// NewHandler returns a Handler that passes the events it doesn't aggregate
+// to next, which may be nil.
+func NewHandler(next event.Handler, opts *Options) *Handler {
+	h := &Handler{
+		next:   next,
+		series: make(map[seriesKey]*series),
+	}
+	if opts != nil {
+		h.opts = *opts
+	}
+	return h
+}
+
+func (h *Handler) Event(ctx context.Context, ev *event.Event) context.Context {
+	if ev.Kind != event.MetricKind {
+		return h.forward(ctx, ev)
+	}
+	mi, ok := event.MetricKey.Find(ev)
+	if !ok {
+		panic(errors.New("no metric key for metric event"))
+	}
+	em := mi.(event.Metric)
+	var v float64
+	switch em.(type) {
+	case *event.IntDistribution:
+		v = float64(ev.Find(event.MetricVal).Int64())
+	case *event.DurationDistribution:
+		v = ev.Find(event.MetricVal).Duration().Seconds()
+	default:
+		return h.forward(ctx, ev)
+	}
+
+	labels := metricLabels(ev.Labels)
+	k := seriesKey{em, labelKey(labels)}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.series[k]
+	if !ok {
+		var a Aggregation
+		if h.opts.Aggregation != nil {
+			a = h.opts.Aggregation(em)
+		}
+		if a == nil {
+			a = Exponential(160)
+		}
+		s = &series{metric: em, labels: labels, agg: a.newAggregator()}
+		h.series[k] = s
+	}
+	s.agg.observe(v)
+	return ctx
+}
+
+func (h *Handler) forward(ctx context.Context, ev *event.Event) context.Context {
+	if h.next == nil {
+		return ctx
+	}
+	return h.next.Event(ctx, ev)
+}
+
+// Snapshot returns the current state of each distribution and set of
+// labels, sorted by metric name and then labels. The state is cumulative
+// since the handler was created.
+func (h *Handler) Snapshot() []Snapshot {
+	h.mu.Lock()
+	snaps := make([]Snapshot, 0, len(h.series))
+	keys := make([]string, 0, len(h.series))
+	for k, s := range h.series {
+		snap := Snapshot{Metric: s.metric, Labels: s.labels}
+		s.agg.snapshot(&snap)
+		snaps = append(snaps, snap)
+		keys = append(keys, k.labels)
+	}
+	h.mu.Unlock()
+
+	sort.Sort(byMetric{snaps, keys})
+	return snaps
+}
+
+type byMetric struct {
+	snaps []Snapshot
+	keys  []string
+}
+
+func (b byMetric) Len() int { return len(b.snaps) }
+
+func (b byMetric) Less(i, j int) bool {
+	mi, mj := b.snaps[i].Metric, b.snaps[j].Metric
+	if mi.Name() != mj.Name() {
+		return mi.Name() < mj.Name()
+	}
+	if ni, nj := mi.Options().Namespace, mj.Options().Namespace; ni != nj {
+		return ni < nj
+	}
+	return b.keys[i] < b.keys[j]
+}
+
+func (b byMetric) Swap(i, j int) {
+	b.snaps[i], b.snaps[j] = b.snaps[j], b.snaps[i]
+	b.keys[i], b.keys[j] = b.keys[j], b.keys[i]
+}
+
+// Snapshot is the state of a distribution with a set of labels.
+type Snapshot struct {
+	Metric event.Metric
+
+	// Labels are the labels of the metric events, other than the metric
+	// and its value, sorted by name.
+	Labels []event.Label
+
+	// Count, Sum, Min, and Max summarize the values observed.
+	Count    uint64
+	Sum      float64
+	Min, Max float64
+
+	// Exactly one of the following is set, according to the metric's
+	// Aggregation.
+	Buckets     []Bucket
+	Exponential *ExponentialHistogram
+	Quantiles   []Quantile
+}
+
+// A Bucket is a bucket of a histogram with explicit bounds. It counts the
+// values greater than the previous bucket's upper bound, and at most its
+// own. The last bucket's upper bound is +Inf.
+type Bucket struct {
+	UpperBound float64
+	Count      uint64
+}
+
+// A Quantile is an estimate of a quantile of a distribution.
+type Quantile struct {
+	Quantile float64 // between 0 and 1
+	Value    float64
+}
+
+// metricLabels returns a sorted copy of the labels in ls other than the
+// metric and its value.
+func metricLabels(ls []event.Label) []event.Label {
+	var out []event.Label
+	for _, l := range ls {
+		if l.Name == "" || l.Name == string(event.MetricKey) || l.Name == event.MetricVal {
+			continue
+		}
+		out = append(out, l)
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// labelKey formats labels as a map key, distinguishing values of different
+// types with the same string form.
+func labelKey(labels []event.Label) string {
+	var b strings.Builder
+	for _, l := range labels {
+		b.WriteString(l.Name)
+		b.WriteByte(0)
+		switch {
+		case l.IsString():
+			b.WriteByte('s')
+		case l.IsInt64():
+			b.WriteByte('i')
+		case l.IsUint64():
+			b.WriteByte('u')
+		case l.IsFloat64():
+			b.WriteByte('f')
+		case l.IsBool():
+			b.WriteByte('b')
+		case l.IsDuration():
+			b.WriteByte('d')
+		default:
+			b.WriteByte('v')
+		}
+		b.WriteString(l.String())
+		b.WriteByte(0)
+	}
+	return b.String()
+}
+
+// An Aggregation is a way to aggregate a distribution.
+type Aggregation interface {
+	newAggregator() aggregator
+}
+
+type aggregator interface {
+	observe(v float64)
+	snapshot(*Snapshot)
+}
+
+// stats are the statistics every aggregation keeps.
+type stats struct {
+	count    uint64
+	sum      float64
+	min, max float64
+}
+
+func (s *stats) observe(v float64) {
+	if s.count == 0 || v < s.min {
+		s.min = v
+	}
+	if s.count == 0 || v > s.max {
+		s.max = v
+	}
+	s.count++
+	s.sum += v
+}
+
+func (s *stats) snapshot(snap *Snapshot) {
+	snap.Count, snap.Sum, snap.Min, snap.Max = s.count, s.sum, s.min, s.max
+}
+
+// Buckets returns an Aggregation into a histogram with explicit bucket
+// bounds, which must be in increasing order. A final bucket for values
+// greater than the last bound is added.
+func Buckets(bounds ...float64) Aggregation {
+	if !sort.Float64sAreSorted(bounds) {
+		panic("aggregate: bucket bounds are not in increasing order")
+	}
+	return bucketsAggregation(bounds)
+}
+
+// DurationBuckets is like Buckets, with bounds that are durations.
+func DurationBuckets(bounds ...time.Duration) Aggregation {
+	fs := make([]float64, len(bounds))
+	for i, d := range bounds {
+		fs[i] = d.Seconds()
+	}
+	return Buckets(fs...)
+}
+
+type bucketsAggregation []float64
+
+func (b bucketsAggregation) newAggregator() aggregator {
+	return &bucketsAggregator{bounds: b, counts: make([]uint64, len(b)+1)}
+}
+
+type bucketsAggregator struct {
+	stats
+	bounds []float64
+	counts []uint64
+}
+
+func (a *bucketsAggregator) observe(v float64) {
+	a.stats.observe(v)
+	a.counts[sort.SearchFloat64s(a.bounds, v)]++
+}
+
+func (a *bucketsAggregator) snapshot(snap *Snapshot) {
+	a.stats.snapshot(snap)
+	snap.Buckets = make([]Bucket, len(a.counts))
+	for i, n := range a.counts {
+		ub := math.Inf(1)
+		if i < len(a.bounds) {
+			ub = a.bounds[i]
+		}
+		snap.Buckets[i] = Bucket{ub, n}
+	}
+}
@@ -0,0 +1,181 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !disable_events
+
+package aggregate_test
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/exp/event"
+	"golang.org/x/exp/event/aggregate"
+)
+
+type countingHandler struct{ n int }
+
+func (h *countingHandler) Event(ctx context.Context, ev *event.Event) context.Context {
+	h.n++
+	return ctx
+}
+
+func TestHandler(t *testing.T) {
+	latency := event.NewDuration("latency", nil)
+	size := event.NewIntDistribution("size", nil)
+	depth := event.NewIntDistribution("depth", nil)
+	hits := event.NewCounter("hits", nil)
+
+	next := &countingHandler{}
+	h := aggregate.NewHandler(next, &aggregate.Options{
+		Aggregation: func(m event.Metric) aggregate.Aggregation {
+			switch m {
+			case latency:
+				return aggregate.DurationBuckets(10*time.Millisecond, 100*time.Millisecond)
+			case size:
+				return aggregate.Summary(0, 0.5, 1)
+			}
+			return nil
+		},
+	})
+	ctx := event.WithExporter(context.Background(), event.NewExporter(h, nil))
+
+	latency.Record(ctx, 5*time.Millisecond, event.String("route", "/a"))
+	latency.Record(ctx, 10*time.Millisecond, event.String("route", "/a"))
+	latency.Record(ctx, 50*time.Millisecond, event.String("route", "/a"))
+	latency.Record(ctx, time.Second, event.String("route", "/a"))
+	latency.Record(ctx, time.Second, event.String("route", "/b"))
+	for i := int64(1); i <= 100; i++ {
+		size.Record(ctx, i)
+	}
+	depth.Record(ctx, 1)
+	depth.Record(ctx, 4)
+	hits.Record(ctx, 1)
+	event.Log(ctx, "hello")
+
+	if next.n != 2 {
+		t.Errorf("next handler got %d events, want the counter and the log", next.n)
+	}
+
+	snaps := h.Snapshot()
+	type summary struct {
+		Name          string
+		Labels        string
+		Count         uint64
+		Sum, Min, Max float64
+		Buckets       []aggregate.Bucket
+		Quantiles     []aggregate.Quantile
+		Exponential   bool
+	}
+	var got []summary
+	for _, s := range snaps {
+		var labels string
+		for _, l := range s.Labels {
+			labels += l.Name + "=" + l.String()
+		}
+		got = append(got, summary{
+			Name:        s.Metric.Name(),
+			Labels:      labels,
+			Count:       s.Count,
+			Sum:         s.Sum,
+			Min:         s.Min,
+			Max:         s.Max,
+			Buckets:     s.Buckets,
+			Quantiles:   s.Quantiles,
+			Exponential: s.Exponential != nil,
+		})
+	}
+	inf := math.Inf(1)
+	want := []summary{
+		{Name: "depth", Count: 2, Sum: 5, Min: 1, Max: 4, Exponential: true},
+		{
+			Name: "latency", Labels: "route=/a", Count: 4, Sum: 1.065, Min: 0.005, Max: 1,
+			Buckets: []aggregate.Bucket{{0.01, 2}, {0.1, 1}, {inf, 1}},
+		},
+		{
+			Name: "latency", Labels: "route=/b", Count: 1, Sum: 1, Min: 1, Max: 1,
+			Buckets: []aggregate.Bucket{{0.01, 0}, {0.1, 0}, {inf, 1}},
+		},
+		{
+			Name: "size", Count: 100, Sum: 5050, Min: 1, Max: 100,
+			Quantiles: []aggregate.Quantile{{0, 1}, {0.5, 50}, {1, 100}},
+		},
+	}
+	approx := cmp.Comparer(func(x, y float64) bool {
+		return x == y || math.Abs(x-y) <= 0.01*math.Abs(y)
+	})
+	if diff := cmp.Diff(want, got, approx); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+	// Quantiles 0 and 1 are exact.
+	if qs := got[3].Quantiles; len(qs) == 3 && (qs[0].Value != 1 || qs[2].Value != 100) {
+		t.Errorf("got extreme quantiles %v and %v, want exactly 1 and 100", qs[0], qs[2])
+	}
+}
+
+func TestSummaryExtremes(t *testing.T) {
+	size := event.NewIntDistribution("size", nil)
+	h := aggregate.NewHandler(nil, &aggregate.Options{
+		Aggregation: func(event.Metric) aggregate.Aggregation { return aggregate.Summary(0, 1) },
+	})
+	ctx := event.WithExporter(context.Background(), event.NewExporter(h, nil))
+	for _, v := range []int64{1001, 3, 7, 999999} {
+		size.Record(ctx, v)
+	}
+	snaps := h.Snapshot()
+	if len(snaps) != 1 {
+		t.Fatalf("got %d snapshots, want 1", len(snaps))
+	}
+	// The extremes are exact, not estimates.
+	want := []aggregate.Quantile{{0, 3}, {1, 999999}}
+	if diff := cmp.Diff(want, snaps[0].Quantiles); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+}
+
+func TestExponential(t *testing.T) {
+	size := event.NewIntDistribution("size", nil)
+	h := aggregate.NewHandler(nil, &aggregate.Options{
+		Aggregation: func(event.Metric) aggregate.Aggregation { return aggregate.Exponential(4) },
+	})
+	ctx := event.WithExporter(context.Background(), event.NewExporter(h, nil))
+	for _, v := range []int64{0, 1, 2, 3, 4, 8, 16, -3} {
+		size.Record(ctx, v)
+	}
+	snaps := h.Snapshot()
+	if len(snaps) != 1 {
+		t.Fatalf("got %d snapshots, want 1", len(snaps))
+	}
+	e := snaps[0].Exponential
+	if e == nil {
+		t.Fatal("no exponential histogram")
+	}
+	// The values from 1 to 16 need 5 buckets at scale 0, so with at most 4,
+	// the histogram is at scale -1, where each bucket spans 2 powers of 2.
+	want := &aggregate.ExponentialHistogram{
+		Scale:     -1,
+		ZeroCount: 1,
+		Positive:  aggregate.ExponentialBuckets{Offset: -1, Counts: []uint64{1, 3, 2}},
+		Negative:  aggregate.ExponentialBuckets{Offset: 0, Counts: []uint64{1}},
+	}
+	if diff := cmp.Diff(want, e); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+	// Check that every value is within its bucket's bounds.
+	for _, v := range []float64{1, 2, 3, 4, 8, 16} {
+		i := -1
+		for j := range e.Positive.Counts {
+			b := e.Positive.Offset + int32(j)
+			if aggregate.LowerBound(e.Scale, b) < v && v <= aggregate.LowerBound(e.Scale, b+1) {
+				i = j
+			}
+		}
+		if i < 0 || e.Positive.Counts[i] == 0 {
+			t.Errorf("%v isn't in a nonempty bucket", v)
+		}
+	}
+}
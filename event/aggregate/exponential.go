@@ -0,0 +1,277 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package aggregate
+
+import "math"
+
+// maxScale is the scale exponential histograms start at.
+const maxScale = 20
+
+// ExponentialHistogram is a histogram whose bucket bounds grow exponentially,
+// as in OpenTelemetry's base-2 exponential histograms. At scale s, bucket i
+// counts the values v with base^i < |v| <= base^(i+1), where
+// base = 2^(2^-s).
+type ExponentialHistogram struct {
+	Scale int32
+
+	// ZeroCount is the number of values equal to zero.
+	ZeroCount uint64
+
+	// Positive and Negative are the buckets for positive values and the
+	// magnitudes of negative values.
+	Positive, Negative ExponentialBuckets
+}
+
+// ExponentialBuckets are a contiguous range of buckets of an exponential
+// histogram. Counts[i] is the count of bucket Offset+i.
+type ExponentialBuckets struct {
+	Offset int32
+	Counts []uint64
+}
+
+// LowerBound returns the lower bound of the magnitudes of the values in
+// bucket i of a histogram with the given scale. The upper bound is the lower
+// bound of bucket i+1.
+func LowerBound(scale, i int32) float64 {
+	return math.Exp2(math.Ldexp(float64(i), -int(scale)))
+}
+
+// Exponential returns an Aggregation into an exponential histogram with at
+// most maxSize buckets for each sign. The histogram starts at the highest
+// resolution, and halves it whenever the range of values needs more
+// buckets than that.
+func Exponential(maxSize int) Aggregation {
+	if maxSize < 2 {
+		panic("aggregate: exponential histograms need at least 2 buckets")
+	}
+	return exponentialAggregation(maxSize)
+}
+
+type exponentialAggregation int
+
+func (e exponentialAggregation) newAggregator() aggregator {
+	return &exponentialAggregator{h: newExpHistogram(int(e))}
+}
+
+type exponentialAggregator struct {
+	stats
+	h *expHistogram
+}
+
+func (a *exponentialAggregator) observe(v float64) {
+	a.stats.observe(v)
+	a.h.observe(v)
+}
+
+func (a *exponentialAggregator) snapshot(snap *Snapshot) {
+	a.stats.snapshot(snap)
+	snap.Exponential = &ExponentialHistogram{
+		Scale:     a.h.scale,
+		ZeroCount: a.h.zero,
+		Positive:  a.h.pos.export(),
+		Negative:  a.h.neg.export(),
+	}
+}
+
+// Summary returns an Aggregation that estimates the given quantiles, which
+// must be between 0 and 1. The estimates are kept in an exponential
+// histogram with 2048 buckets, so their relative error depends on the
+// range of the values: it's under 1% while the largest magnitude is less
+// than 2^20 times the smallest nonzero one, and doubles every time that
+// ratio is squared. The estimates of quantiles 0 and 1 are the exact
+// minimum and maximum.
+func Summary(quantiles ...float64) Aggregation {
+	for _, q := range quantiles {
+		if q < 0 || q > 1 {
+			panic("aggregate: quantiles must be between 0 and 1")
+		}
+	}
+	return summaryAggregation(quantiles)
+}
+
+type summaryAggregation []float64
+
+func (q summaryAggregation) newAggregator() aggregator {
+	return &summaryAggregator{quantiles: q, h: newExpHistogram(2048)}
+}
+
+type summaryAggregator struct {
+	stats
+	quantiles []float64
+	h         *expHistogram
+}
+
+func (a *summaryAggregator) observe(v float64) {
+	a.stats.observe(v)
+	a.h.observe(v)
+}
+
+func (a *summaryAggregator) snapshot(snap *Snapshot) {
+	a.stats.snapshot(snap)
+	snap.Quantiles = make([]Quantile, len(a.quantiles))
+	for i, q := range a.quantiles {
+		v := math.NaN()
+		switch {
+		case a.count == 0:
+		case q == 0:
+			v = a.min
+		case q == 1:
+			v = a.max
+		default:
+			v = a.h.quantile(q)
+			v = math.Max(a.min, math.Min(a.max, v))
+		}
+		snap.Quantiles[i] = Quantile{q, v}
+	}
+}
+
+// expHistogram is an exponential histogram that lowers its scale as needed
+// to keep each sign within maxSize buckets.
+type expHistogram struct {
+	maxSize  int
+	scale    int32
+	zero     uint64
+	pos, neg expBuckets
+}
+
+func newExpHistogram(maxSize int) *expHistogram {
+	return &expHistogram{maxSize: maxSize, scale: maxScale}
+}
+
+func (h *expHistogram) observe(v float64) {
+	switch {
+	case v == 0:
+		h.zero++
+		return
+	case v > 0:
+		h.add(&h.pos, v)
+	default:
+		h.add(&h.neg, -v)
+	}
+}
+
+// add adds the magnitude v to b, first lowering the scale if b would need
+// too many buckets.
+func (h *expHistogram) add(b *expBuckets, v float64) {
+	i := index(h.scale, v)
+	if len(b.counts) > 0 {
+		lo, hi := b.offset, b.offset+int32(len(b.counts))-1
+		if i < lo {
+			lo = i
+		}
+		if i > hi {
+			hi = i
+		}
+		shift := int32(0)
+		for int(hi>>shift-lo>>shift) >= h.maxSize {
+			shift++
+		}
+		if shift > 0 {
+			h.downscale(shift)
+			i = index(h.scale, v)
+		}
+	}
+	b.increment(i)
+}
+
+// downscale lowers the scale by shift, merging buckets.
+func (h *expHistogram) downscale(shift int32) {
+	h.scale -= shift
+	h.pos.downscale(shift)
+	h.neg.downscale(shift)
+}
+
+// index returns the index of the bucket for the magnitude v at scale.
+func index(scale int32, v float64) int32 {
+	if scale <= 0 {
+		// Use the exact exponent, so that powers of two are in the
+		// right bucket.
+		frac, exp := math.Frexp(v)
+		i := int32(exp - 1)
+		if frac == 0.5 {
+			i--
+		}
+		return i >> -scale
+	}
+	return int32(math.Ceil(math.Ldexp(math.Log2(v), int(scale)))) - 1
+}
+
+// quantile estimates quantile q of the values, from the geometric middle
+// of the bucket it falls in.
+func (h *expHistogram) quantile(q float64) float64 {
+	total := h.zero + h.pos.total() + h.neg.total()
+	rank := uint64(math.Ceil(q * float64(total)))
+	if rank == 0 {
+		rank = 1
+	}
+	mid := func(i int32) float64 {
+		return math.Exp2(math.Ldexp(float64(i)+0.5, -int(h.scale)))
+	}
+	// Negative values come first, from the largest magnitude.
+	var seen uint64
+	for j := len(h.neg.counts) - 1; j >= 0; j-- {
+		seen += h.neg.counts[j]
+		if seen >= rank {
+			return -mid(h.neg.offset + int32(j))
+		}
+	}
+	seen += h.zero
+	if seen >= rank {
+		return 0
+	}
+	for j, n := range h.pos.counts {
+		seen += n
+		if seen >= rank {
+			return mid(h.pos.offset + int32(j))
+		}
+	}
+	return math.NaN()
+}
+
+// expBuckets is a contiguous range of buckets.
+type expBuckets struct {
+	offset int32
+	counts []uint64
+}
+
+func (b *expBuckets) increment(i int32) {
+	switch {
+	case len(b.counts) == 0:
+		b.offset = i
+		b.counts = []uint64{0}
+	case i < b.offset:
+		grown := make([]uint64, int(b.offset-i)+len(b.counts))
+		copy(grown[b.offset-i:], b.counts)
+		b.counts, b.offset = grown, i
+	case int(i-b.offset) >= len(b.counts):
+		b.counts = append(b.counts, make([]uint64, int(i-b.offset)+1-len(b.counts))...)
+	}
+	b.counts[i-b.offset]++
+}
+
+func (b *expBuckets) downscale(shift int32) {
+	if len(b.counts) == 0 {
+		return
+	}
+	lo := b.offset >> shift
+	hi := (b.offset + int32(len(b.counts)) - 1) >> shift
+	merged := make([]uint64, hi-lo+1)
+	for j, n := range b.counts {
+		merged[(b.offset+int32(j))>>shift-lo] += n
+	}
+	b.offset, b.counts = lo, merged
+}
+
+func (b *expBuckets) total() uint64 {
+	var n uint64
+	for _, c := range b.counts {
+		n += c
+	}
+	return n
+}
+
+func (b *expBuckets) export() ExponentialBuckets {
+	return ExponentialBuckets{Offset: b.offset, Counts: append([]uint64(nil), b.counts...)}
+}
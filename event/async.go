@@ -0,0 +1,287 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !disable_events
+
+package event
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// AsyncOptions configures asynchronous delivery of events by an Exporter.
+//
+// An asynchronous exporter queues a clone of each event and returns to the
+// caller, and a separate goroutine delivers the queued events to the
+// handler. If the handler is a BatchHandler, they are delivered in batches.
+// The context that the handler returns is discarded, so handlers that rely
+// on it, such as those that add spans to the context, need a synchronous
+// exporter.
+type AsyncOptions struct {
+	// QueueSize is the number of events that can wait to be delivered.
+	// The default is 1024.
+	QueueSize int
+
+	// MaxBatch is the largest number of events delivered to a BatchHandler
+	// at once. The default is 64.
+	MaxBatch int
+
+	// Policy is what to do with events when the queue is full.
+	Policy QueuePolicy
+
+	// SampleEvery is the rate at which the QueueSample policy keeps
+	// events. The default is 10.
+	SampleEvery int
+}
+
+// QueuePolicy is what an asynchronous Exporter does with an event when its
+// queue is full.
+type QueuePolicy int
+
+const (
+	// QueueBlock waits for room in the queue.
+	QueueBlock QueuePolicy = iota
+
+	// QueueDropNewest drops the event.
+	QueueDropNewest
+
+	// QueueDropOldest drops the oldest event in the queue to make room
+	// for the event.
+	QueueDropOldest
+
+	// QueueSample keeps one in every SampleEvery events that arrive while
+	// the queue is full, dropping the oldest event in the queue to make
+	// room for it, and drops the rest.
+	QueueSample
+)
+
+// A BatchHandler is a Handler that can handle several events at once.
+// An asynchronous Exporter delivers events to a BatchHandler with
+// EventBatch instead of Event.
+type BatchHandler interface {
+	Handler
+
+	// EventBatch is called with events in the order they occurred. The
+	// events are only valid until it returns.
+	EventBatch(evs []*Event)
+}
+
+// ExporterStats are counts of the events an asynchronous Exporter has
+// handled.
+type ExporterStats struct {
+	// Delivered is the number of events delivered to the handler.
+	Delivered uint64
+
+	// Dropped is the number of events dropped because the queue was full
+	// or the exporter was closed.
+	Dropped uint64
+
+	// Queued is the number of events waiting to be delivered.
+	Queued int
+}
+
+// asyncQueue is the queue of an asynchronous Exporter.
+type asyncQueue struct {
+	delivered uint64 // accessed using atomic, must be 64 bit aligned
+	dropped   uint64 // accessed using atomic
+	full      uint64 // accessed using atomic; events that arrived while the queue was full
+
+	opts    AsyncOptions
+	ch      chan *Event
+	done    chan struct{} // closed to stop the worker
+	stopped chan struct{} // closed when the worker has stopped
+
+	mu      sync.Mutex
+	cond    sync.Cond
+	pending int // events queued or being delivered
+	closed  bool
+}
+
+func newAsyncQueue(opts AsyncOptions, h Handler) *asyncQueue {
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = 1024
+	}
+	if opts.MaxBatch <= 0 {
+		opts.MaxBatch = 64
+	}
+	if opts.SampleEvery <= 0 {
+		opts.SampleEvery = 10
+	}
+	q := &asyncQueue{
+		opts:    opts,
+		ch:      make(chan *Event, opts.QueueSize),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	q.cond.L = &q.mu
+	go q.run(h)
+	return q
+}
+
+// enqueue queues ev, which must not be used by anything else, according to
+// the queue's policy.
+func (q *asyncQueue) enqueue(ev *Event) {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		q.drop(ev)
+		return
+	}
+	q.pending++
+	q.mu.Unlock()
+
+	select {
+	case q.ch <- ev:
+		return
+	default:
+	}
+	switch q.opts.Policy {
+	case QueueBlock:
+		q.ch <- ev
+	case QueueDropNewest:
+		q.discard(ev)
+	case QueueSample:
+		if n := atomic.AddUint64(&q.full, 1); (n-1)%uint64(q.opts.SampleEvery) != 0 {
+			q.discard(ev)
+			return
+		}
+		q.replaceOldest(ev)
+	default:
+		q.replaceOldest(ev)
+	}
+}
+
+// replaceOldest drops events from the front of the queue until there's
+// room for ev.
+func (q *asyncQueue) replaceOldest(ev *Event) {
+	for {
+		select {
+		case q.ch <- ev:
+			return
+		default:
+		}
+		select {
+		case old := <-q.ch:
+			q.discard(old)
+		default:
+		}
+	}
+}
+
+// drop drops an event that was never counted as pending.
+func (q *asyncQueue) drop(ev *Event) {
+	atomic.AddUint64(&q.dropped, 1)
+	eventPool.Put(ev)
+}
+
+// discard drops a pending event.
+func (q *asyncQueue) discard(ev *Event) {
+	q.drop(ev)
+	q.finish(1)
+}
+
+// finish records that n pending events are done with.
+func (q *asyncQueue) finish(n int) {
+	q.mu.Lock()
+	q.pending -= n
+	if q.pending == 0 {
+		q.cond.Broadcast()
+	}
+	q.mu.Unlock()
+}
+
+func (q *asyncQueue) run(h Handler) {
+	defer close(q.stopped)
+	bh, _ := h.(BatchHandler)
+	batch := make([]*Event, 0, q.opts.MaxBatch)
+	for {
+		select {
+		case ev := <-q.ch:
+			batch = append(batch[:0], ev)
+		case <-q.done:
+			return
+		}
+	fill:
+		for len(batch) < cap(batch) {
+			select {
+			case ev := <-q.ch:
+				batch = append(batch, ev)
+			default:
+				break fill
+			}
+		}
+		if bh != nil {
+			bh.EventBatch(batch)
+		} else {
+			for _, ev := range batch {
+				h.Event(ev.ctx, ev)
+			}
+		}
+		atomic.AddUint64(&q.delivered, uint64(len(batch)))
+		for i, ev := range batch {
+			eventPool.Put(ev)
+			batch[i] = nil
+		}
+		q.finish(len(batch))
+	}
+}
+
+// flush waits until every event queued so far has been delivered or
+// dropped.
+func (q *asyncQueue) flush() {
+	q.mu.Lock()
+	for q.pending > 0 {
+		q.cond.Wait()
+	}
+	q.mu.Unlock()
+}
+
+func (q *asyncQueue) close() {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return
+	}
+	q.closed = true
+	q.mu.Unlock()
+	q.flush()
+	close(q.done)
+	<-q.stopped
+}
+
+func (q *asyncQueue) stats() ExporterStats {
+	return ExporterStats{
+		Delivered: atomic.LoadUint64(&q.delivered),
+		Dropped:   atomic.LoadUint64(&q.dropped),
+		Queued:    len(q.ch),
+	}
+}
+
+// Flush waits until the events delivered to an asynchronous exporter so far
+// have been delivered to its handler or dropped. It does nothing for a
+// synchronous exporter.
+func (e *Exporter) Flush() {
+	if e.async != nil {
+		e.async.flush()
+	}
+}
+
+// Close flushes an asynchronous exporter and stops its goroutine. Events
+// delivered to it afterwards are dropped. It does nothing for a synchronous
+// exporter.
+func (e *Exporter) Close() {
+	if e.async != nil {
+		e.async.close()
+	}
+}
+
+// Stats returns counts of the events an asynchronous exporter has handled.
+// They're zero for a synchronous exporter.
+func (e *Exporter) Stats() ExporterStats {
+	if e.async == nil {
+		return ExporterStats{}
+	}
+	return e.async.stats()
+}
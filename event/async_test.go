@@ -0,0 +1,132 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !disable_events
+
+package event_test
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/exp/event"
+)
+
+// gatedHandler records the messages of the events it handles, and blocks
+// in the first one until release is closed.
+type gatedHandler struct {
+	started chan struct{}
+	release chan struct{}
+	once    sync.Once
+
+	mu   sync.Mutex
+	msgs []string
+}
+
+func newGatedHandler() *gatedHandler {
+	return &gatedHandler{started: make(chan struct{}), release: make(chan struct{})}
+}
+
+func (h *gatedHandler) Event(ctx context.Context, ev *event.Event) context.Context {
+	h.once.Do(func() {
+		close(h.started)
+		<-h.release
+	})
+	h.mu.Lock()
+	h.msgs = append(h.msgs, ev.Find("msg").String())
+	h.mu.Unlock()
+	return ctx
+}
+
+func TestAsyncPolicies(t *testing.T) {
+	for _, test := range []struct {
+		name        string
+		policy      event.QueuePolicy
+		sampleEvery int
+		want        []string
+	}{
+		{"DropNewest", event.QueueDropNewest, 0, []string{"0", "1", "2"}},
+		{"DropOldest", event.QueueDropOldest, 0, []string{"0", "5", "6"}},
+		// Of 3, 4, 5, and 6, which arrive while the queue is full, 3 and 5
+		// are kept.
+		{"Sample", event.QueueSample, 2, []string{"0", "3", "5"}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			h := newGatedHandler()
+			e := event.NewExporter(h, &event.ExporterOptions{
+				Async: &event.AsyncOptions{QueueSize: 2, Policy: test.policy, SampleEvery: test.sampleEvery},
+			})
+			defer e.Close()
+			ctx := event.WithExporter(context.Background(), e)
+
+			event.Log(ctx, "0")
+			<-h.started
+			for i := 1; i < 7; i++ {
+				event.Log(ctx, strconv.Itoa(i))
+			}
+			if got := e.Stats(); got.Queued != 2 || got.Dropped != 4 {
+				t.Errorf("got %+v while blocked, want 2 queued and 4 dropped", got)
+			}
+			close(h.release)
+			e.Flush()
+
+			if diff := cmp.Diff(test.want, h.msgs); diff != "" {
+				t.Errorf("mismatch (-want, +got):\n%s", diff)
+			}
+			want := event.ExporterStats{Delivered: 3, Dropped: 4}
+			if got := e.Stats(); got != want {
+				t.Errorf("got %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+type batchHandler struct {
+	msgs    []string
+	batches int
+	max     int
+}
+
+func (h *batchHandler) Event(ctx context.Context, ev *event.Event) context.Context {
+	panic("Event called on a BatchHandler")
+}
+
+func (h *batchHandler) EventBatch(evs []*event.Event) {
+	h.batches++
+	if len(evs) > h.max {
+		h.max = len(evs)
+	}
+	for _, ev := range evs {
+		h.msgs = append(h.msgs, ev.Find("msg").String())
+	}
+}
+
+func TestAsyncBatch(t *testing.T) {
+	h := &batchHandler{}
+	e := event.NewExporter(h, &event.ExporterOptions{
+		Async: &event.AsyncOptions{QueueSize: 4, MaxBatch: 3},
+	})
+	ctx := event.WithExporter(context.Background(), e)
+	var want []string
+	for i := 0; i < 100; i++ {
+		msg := strconv.Itoa(i)
+		event.Log(ctx, msg, event.Int64("i", int64(i)))
+		want = append(want, msg)
+	}
+	e.Close()
+	event.Log(ctx, "after close")
+
+	if diff := cmp.Diff(want, h.msgs); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+	if h.max > 3 {
+		t.Errorf("got a batch of %d events, want at most 3", h.max)
+	}
+	if want := (event.ExporterStats{Delivered: 100, Dropped: 1}); e.Stats() != want {
+		t.Errorf("got %+v, want %+v", e.Stats(), want)
+	}
+}
@@ -106,7 +106,7 @@ func (ev *Event) Clone() *Event {
 
 func (ev *Event) Trace() {
 	ev.prepare()
-	ev.ctx = newContext(ev.ctx, ev.target.exporter, ev.ID, ev.At)
+	ev.ctx = ev.target.child(ev.ctx, ev.ID, ev.At)
 }
 
 // Deliver the event to the exporter that was found in New.
@@ -121,13 +121,35 @@ func (ev *Event) Deliver() context.Context {
 }
 
 func (ev *Event) deliver() context.Context {
-	// hold the lock while we deliver the event
 	e := ev.target.exporter
+	if !ev.sampled() {
+		return ev.ctx
+	}
+	if e.async != nil {
+		// the event goes back to the pool after delivery, so queue a clone
+		e.async.enqueue(ev.Clone())
+		return ev.ctx
+	}
+	// hold the lock while we deliver the event
 	e.mu.Lock()
 	defer e.mu.Unlock()
 	return e.handler.Event(ev.ctx, ev)
 }
 
+// sampled reports whether head sampling kept the span of a StartKind or
+// EndKind event. Other events are always delivered.
+func (ev *Event) sampled() bool {
+	switch ev.Kind {
+	case StartKind:
+		// Trace has put the new span in the context.
+		t, _ := ev.ctx.Value(contextKey).(*target)
+		return t == nil || !t.unsampled
+	case EndKind:
+		return !ev.target.unsampled
+	}
+	return true
+}
+
 func (ev *Event) Find(name string) Label {
 	for _, l := range ev.Labels {
 		if l.Name == name {
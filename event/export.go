@@ -8,6 +8,7 @@ package event
 
 import (
 	"context"
+	"math/rand"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -19,9 +20,15 @@ type Exporter struct {
 	lastEvent uint64 // accessed using atomic, must be 64 bit aligned
 	opts      ExporterOptions
 
+	// tracePrefix and spanKey make the trace and span IDs of spans
+	// distinct from those of other processes.
+	tracePrefix uint64
+	spanKey     uint64
+
 	mu      sync.Mutex
 	handler Handler
 	sources sources
+	async   *asyncQueue
 }
 
 // target is a bound exporter.
@@ -30,6 +37,14 @@ type target struct {
 	exporter  *Exporter
 	parent    uint64
 	startTime time.Time // for trace latency
+
+	// The trace of the span is either that of a remote span, or the local
+	// one of the root span with ID root.
+	remote *SpanContext
+	root   uint64
+
+	// unsampled is set for the spans of traces that head sampling dropped.
+	unsampled bool
 }
 
 type ExporterOptions struct {
@@ -42,9 +57,20 @@ type ExporterOptions struct {
 	DisableAnnotations bool
 	DisableMetrics     bool
 
+	// If between 0 and 1, exclusive, the probability that a trace is
+	// sampled; otherwise every trace is. Start decides it for a span with
+	// no parent in the process, and its descendants inherit the decision.
+	// A span whose parent is remote follows the parent's sampled flag.
+	// The StartKind and EndKind events of spans that aren't sampled are
+	// not delivered, but End still records their DurationMetric.
+	TraceSampleRate float64
+
 	// Enable automatically setting the event Namespace to the calling package's
 	// import path.
 	EnableNamespaces bool
+
+	// If non-nil, deliver events to the handler asynchronously.
+	Async *AsyncOptions
 }
 
 // contextKeyType is used as the key for storing a contextValue on the context.
@@ -58,13 +84,16 @@ var (
 
 // NewExporter creates an Exporter using the supplied handler and options.
 // Event delivery is serialized to enable safe atomic handling.
+// An asynchronous exporter should be closed when it's no longer needed.
 func NewExporter(handler Handler, opts *ExporterOptions) *Exporter {
 	if handler == nil {
 		panic("handler must not be nil")
 	}
 	e := &Exporter{
-		handler: handler,
-		sources: newCallers(),
+		handler:     handler,
+		sources:     newCallers(),
+		tracePrefix: randomUint64(),
+		spanKey:     randomUint64(),
 	}
 	if opts != nil {
 		e.opts = *opts
@@ -72,6 +101,9 @@ func NewExporter(handler Handler, opts *ExporterOptions) *Exporter {
 	if e.opts.Now == nil {
 		e.opts.Now = time.Now
 	}
+	if e.opts.Async != nil {
+		e.async = newAsyncQueue(*e.opts.Async, handler)
+	}
 	return e
 }
 
@@ -91,6 +123,19 @@ func newContext(ctx context.Context, exporter *Exporter, parent uint64, start ti
 	return context.WithValue(ctx, contextKey, t)
 }
 
+// child returns a context for the span started by event id at start.
+func (t *target) child(ctx context.Context, id uint64, start time.Time) context.Context {
+	c := &target{exporter: t.exporter, parent: id, startTime: start, remote: t.remote, root: t.root, unsampled: t.unsampled}
+	switch {
+	case c.remote == nil && c.root == 0:
+		c.root = id
+		c.unsampled = !t.exporter.sampleTrace()
+	case c.remote != nil && t.parent == 0 && t.exporter.headSampling():
+		c.unsampled = c.remote.Flags&FlagSampled == 0
+	}
+	return context.WithValue(ctx, contextKey, c)
+}
+
 // prepare events before delivering to the underlying handler.
 // it is safe to call this more than once (trace events have to call it early)
 // If the event does not have a timestamp, and the exporter has a Now function
@@ -110,6 +155,16 @@ func (ev *Event) prepare() {
 	}
 }
 
+// headSampling reports whether only some traces are sampled.
+func (e *Exporter) headSampling() bool {
+	return e.opts.TraceSampleRate > 0 && e.opts.TraceSampleRate < 1
+}
+
+// sampleTrace decides whether to sample a new trace.
+func (e *Exporter) sampleTrace() bool {
+	return !e.headSampling() || rand.Float64() < e.opts.TraceSampleRate
+}
+
 func (e *Exporter) loggingEnabled() bool     { return !e.opts.DisableLogging }
 func (e *Exporter) annotationsEnabled() bool { return !e.opts.DisableAnnotations }
 func (e *Exporter) tracingEnabled() bool     { return !e.opts.DisableTracing }
@@ -0,0 +1,335 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package prometheus aggregates metric events in memory and serves them in
+// the Prometheus text exposition format, for services that are scraped by
+// Prometheus rather than exporting to an OpenTelemetry collector.
+package prometheus
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/exp/event"
+)
+
+// DefaultBuckets are the default histogram bucket upper bounds for
+// IntDistribution metrics.
+var DefaultBuckets = []float64{1, 2, 5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000}
+
+// DefaultDurationBuckets are the default histogram bucket upper bounds, in
+// seconds, for DurationDistribution metrics.
+var DefaultDurationBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Options configures a MetricHandler.
+type Options struct {
+	// Buckets maps metric names to the upper bounds of their histogram
+	// buckets, in increasing order. Durations are in seconds. Distributions
+	// that aren't in the map use DefaultBuckets or DefaultDurationBuckets.
+	Buckets map[string][]float64
+}
+
+// MetricHandler is an event.Handler that aggregates metrics, and an
+// http.Handler that serves them in the Prometheus text exposition format.
+// Its Event method handles Metric events and ignores all others.
+//
+// Counters become Prometheus counters, FloatGauges become gauges that hold
+// their last value, and IntDistributions and DurationDistributions become
+// histograms. Durations are reported in seconds. Each metric's series are
+// keyed by its event labels. Metric names are the names of event metrics,
+// with characters that Prometheus doesn't allow replaced by underscores, so
+// they should be unique across namespaces: a metric with the same name as
+// one seen before, once replaced, is ignored. Label names are sanitized the
+// same way, and labels whose names Prometheus reserves, or that are the
+// same as others once sanitized, are renamed.
+type MetricHandler struct {
+	opts Options
+
+	mu      sync.Mutex
+	metrics map[event.Metric]*family // nil for the metrics that are ignored
+	names   map[string]*family
+}
+
+var (
+	_ event.Handler = (*MetricHandler)(nil)
+	_ http.Handler  = (*MetricHandler)(nil)
+)
+
+// family is a metric and its series.
+type family struct {
+	name   string
+	help   string
+	typ    string // "counter", "gauge", or "histogram"
+	bounds []float64
+	series map[string]*series // keyed by the formatted label set
+}
+
+// series is the aggregated value of a metric with one set of labels.
+type series struct {
+	labels string // formatted label pairs, without braces
+	value  float64
+	counts []uint64 // for histograms, the count in each bucket, and then +Inf
+	sum    float64
+}
+
+// NewMetricHandler creates a new MetricHandler.
+func NewMetricHandler(opts *Options) *MetricHandler {
+	h := &MetricHandler{
+		metrics: make(map[event.Metric]*family),
+		names:   make(map[string]*family),
+	}
+	if opts != nil {
+		h.opts = *opts
+	}
+	return h
+}
+
+func (h *MetricHandler) Event(ctx context.Context, ev *event.Event) context.Context {
+	if ev.Kind != event.MetricKind {
+		return ctx
+	}
+	mi, ok := event.MetricKey.Find(ev)
+	if !ok {
+		panic(errors.New("no metric key for metric event"))
+	}
+	em := mi.(event.Metric)
+	lval := ev.Find(event.MetricVal)
+	if !lval.HasValue() {
+		panic(errors.New("no metric value for metric event"))
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	f := h.family(em)
+	if f == nil {
+		return ctx
+	}
+	labels := formatLabels(ev.Labels)
+	s, ok := f.series[labels]
+	if !ok {
+		s = &series{labels: labels}
+		if f.typ == "histogram" {
+			s.counts = make([]uint64, len(f.bounds)+1)
+		}
+		f.series[labels] = s
+	}
+	switch em.(type) {
+	case *event.Counter:
+		s.value += float64(lval.Int64())
+	case *event.FloatGauge:
+		s.value = lval.Float64()
+	case *event.IntDistribution:
+		s.observe(f.bounds, float64(lval.Int64()))
+	case *event.DurationDistribution:
+		s.observe(f.bounds, lval.Duration().Seconds())
+	}
+	return ctx
+}
+
+// family returns the family for em, creating it if needed, or nil if em
+// isn't a kind of metric the handler supports or its name is taken by
+// another metric.
+func (h *MetricHandler) family(em event.Metric) *family {
+	if f, ok := h.metrics[em]; ok {
+		return f
+	}
+	name := sanitizeName(em.Name())
+	if _, ok := h.names[name]; ok {
+		h.metrics[em] = nil
+		return nil
+	}
+	f := &family{
+		name:   name,
+		help:   em.Options().Description,
+		series: make(map[string]*series),
+	}
+	switch em.(type) {
+	case *event.Counter:
+		f.typ = "counter"
+	case *event.FloatGauge:
+		f.typ = "gauge"
+	case *event.IntDistribution:
+		f.typ = "histogram"
+		f.bounds = DefaultBuckets
+	case *event.DurationDistribution:
+		f.typ = "histogram"
+		f.bounds = DefaultDurationBuckets
+	default:
+		h.metrics[em] = nil
+		return nil
+	}
+	if b, ok := h.opts.Buckets[em.Name()]; ok {
+		f.bounds = b
+	}
+	h.metrics[em] = f
+	h.names[name] = f
+	return f
+}
+
+// observe adds v to a histogram with the given bucket bounds.
+func (s *series) observe(bounds []float64, v float64) {
+	i := sort.SearchFloat64s(bounds, v)
+	s.counts[i]++
+	s.sum += v
+}
+
+// ServeHTTP serves the metrics in the Prometheus text exposition format.
+func (h *MetricHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if err := h.WriteText(w); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// WriteText writes the metrics to w in the Prometheus text exposition
+// format, sorted by name and then by labels.
+func (h *MetricHandler) WriteText(w io.Writer) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	bw := bufio.NewWriter(w)
+	names := make([]string, 0, len(h.names))
+	for name := range h.names {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		f := h.names[name]
+		if f.help != "" {
+			fmt.Fprintf(bw, "# HELP %s %s\n", name, escapeHelp(f.help))
+		}
+		fmt.Fprintf(bw, "# TYPE %s %s\n", name, f.typ)
+		keys := make([]string, 0, len(f.series))
+		for k := range f.series {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			f.series[k].write(bw, f)
+		}
+	}
+	return bw.Flush()
+}
+
+func (s *series) write(w *bufio.Writer, f *family) {
+	if f.typ != "histogram" {
+		writeSample(w, f.name, s.labels, "", s.value)
+		return
+	}
+	var cum uint64
+	for i, n := range s.counts {
+		cum += n
+		le := "+Inf"
+		if i < len(f.bounds) {
+			le = formatFloat(f.bounds[i])
+		}
+		writeSample(w, f.name+"_bucket", s.labels, `le="`+le+`"`, float64(cum))
+	}
+	writeSample(w, f.name+"_sum", s.labels, "", s.sum)
+	writeSample(w, f.name+"_count", s.labels, "", float64(cum))
+}
+
+func writeSample(w *bufio.Writer, name, labels, extra string, v float64) {
+	w.WriteString(name)
+	if labels != "" || extra != "" {
+		w.WriteByte('{')
+		w.WriteString(labels)
+		if labels != "" && extra != "" {
+			w.WriteByte(',')
+		}
+		w.WriteString(extra)
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(v))
+	w.WriteByte('\n')
+}
+
+// formatLabels formats the labels of a metric event, other than the metric
+// and its value, as Prometheus label pairs, sorted by name.
+//
+// Label names are sanitized like metric names, and "le", which histograms
+// use for their buckets, becomes "_le", while names starting with "__",
+// which Prometheus reserves, start with a single underscore instead. Names
+// that are taken by then get a suffix of "_2", "_3", and so on, in the
+// order of the labels' original names.
+func formatLabels(ls []event.Label) string {
+	type label struct{ name, value string }
+	var labels []label
+	for _, l := range ls {
+		if l.Name == "" || l.Name == string(event.MetricKey) || l.Name == event.MetricVal {
+			continue
+		}
+		labels = append(labels, label{l.Name, l.String()})
+	}
+	sort.SliceStable(labels, func(i, j int) bool { return labels[i].name < labels[j].name })
+	used := make(map[string]bool)
+	pairs := make([]string, len(labels))
+	for i, l := range labels {
+		base := labelName(l.name)
+		name := base
+		for n := 2; used[name]; n++ {
+			name = base + "_" + strconv.Itoa(n)
+		}
+		used[name] = true
+		pairs[i] = name + `="` + escapeLabelValue(l.value) + `"`
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// labelName returns the sanitized Prometheus label name for an event label
+// called name, before any suffix to make it unique.
+func labelName(name string) string {
+	name = sanitizeName(name)
+	switch {
+	case name == "le":
+		return "_le"
+	case strings.HasPrefix(name, "__"):
+		return "_" + strings.TrimLeft(name, "_")
+	}
+	return name
+}
+
+// sanitizeName replaces the characters in name that aren't allowed in
+// Prometheus metric and label names with underscores.
+func sanitizeName(name string) string {
+	b := []byte(name)
+	for i, c := range b {
+		ok := c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || i > 0 && '0' <= c && c <= '9'
+		if !ok {
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
+
+var (
+	helpEscaper       = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelValueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string       { return helpEscaper.Replace(s) }
+func escapeLabelValue(s string) string { return labelValueEscaper.Replace(s) }
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	case math.IsNaN(f):
+		return "NaN"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,151 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !disable_events
+
+package prometheus_test
+
+import (
+	"context"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/exp/event"
+	"golang.org/x/exp/event/prometheus"
+)
+
+func TestMetricHandler(t *testing.T) {
+	h := prometheus.NewMetricHandler(&prometheus.Options{
+		Buckets: map[string][]float64{"payload.size": {10, 100}},
+	})
+	ctx := event.WithExporter(context.Background(), event.NewExporter(h, nil))
+
+	hits := event.NewCounter("hits", &event.MetricOptions{Description: "Earth meteorite hits"})
+	temp := event.NewFloatGauge("temp", &event.MetricOptions{Description: "moon surface temperature\nin Kelvin"})
+	latency := event.NewDuration("latency", nil)
+	size := event.NewIntDistribution("payload.size", nil)
+
+	hits.Record(ctx, 8)
+	hits.Record(ctx, 2)
+	hits.Record(ctx, 1, event.String("region", "north"), event.Int64("crater", 7))
+	temp.Record(ctx, -100, event.String("location", `Mare "Imbrium"`))
+	temp.Record(ctx, 120.5, event.String("location", `Mare "Imbrium"`))
+	latency.Record(ctx, 20*time.Millisecond)
+	latency.Record(ctx, 2*time.Second)
+	latency.Record(ctx, time.Minute)
+	size.Record(ctx, 10)
+	size.Record(ctx, 50)
+	size.Record(ctx, 1000)
+	event.Log(ctx, "not a metric")
+
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+	resp, err := srv.Client().Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("got Content-Type %q, want the text exposition format", ct)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `# HELP hits Earth meteorite hits
+# TYPE hits counter
+hits 10
+hits{crater="7",region="north"} 1
+# TYPE latency histogram
+latency_bucket{le="0.005"} 0
+latency_bucket{le="0.01"} 0
+latency_bucket{le="0.025"} 1
+latency_bucket{le="0.05"} 1
+latency_bucket{le="0.1"} 1
+latency_bucket{le="0.25"} 1
+latency_bucket{le="0.5"} 1
+latency_bucket{le="1"} 1
+latency_bucket{le="2.5"} 2
+latency_bucket{le="5"} 2
+latency_bucket{le="10"} 2
+latency_bucket{le="+Inf"} 3
+latency_sum 62.02
+latency_count 3
+# TYPE payload_size histogram
+payload_size_bucket{le="10"} 1
+payload_size_bucket{le="100"} 2
+payload_size_bucket{le="+Inf"} 3
+payload_size_sum 1060
+payload_size_count 3
+# HELP temp moon surface temperature\nin Kelvin
+# TYPE temp gauge
+temp{location="Mare \"Imbrium\""} 120.5
+`
+	if got := string(body); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestMetricHandlerNameConflict(t *testing.T) {
+	h := prometheus.NewMetricHandler(nil)
+	ctx := event.WithExporter(context.Background(), event.NewExporter(h, nil))
+
+	// The names are the same once sanitized, and so are those of metrics
+	// with the same name in different namespaces. The first one wins.
+	count := event.NewCounter("req.count", nil)
+	dist := event.NewIntDistribution("req_count", nil)
+	other := event.NewCounter("req.count", &event.MetricOptions{Namespace: "other"})
+	count.Record(ctx, 1)
+	dist.Record(ctx, 5)
+	other.Record(ctx, 2)
+	count.Record(ctx, 3)
+
+	var b strings.Builder
+	if err := h.WriteText(&b); err != nil {
+		t.Fatal(err)
+	}
+	want := `# TYPE req_count counter
+req_count 4
+`
+	if got := b.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestMetricHandlerLabelNames(t *testing.T) {
+	h := prometheus.NewMetricHandler(&prometheus.Options{
+		Buckets: map[string][]float64{"size": {10}},
+	})
+	ctx := event.WithExporter(context.Background(), event.NewExporter(h, nil))
+
+	// "le" would clash with the bucket label, and names starting with "__"
+	// are reserved.
+	size := event.NewIntDistribution("size", nil)
+	size.Record(ctx, 5, event.String("le", "x"), event.String("__name__", "y"))
+
+	// Names that are the same once sanitized are told apart by suffixes,
+	// in the order of the original names.
+	hits := event.NewCounter("hits", nil)
+	hits.Record(ctx, 1, event.String("a_b", "1"), event.String("a.b", "2"), event.String("a-b", "3"))
+
+	var b strings.Builder
+	if err := h.WriteText(&b); err != nil {
+		t.Fatal(err)
+	}
+	want := `# TYPE hits counter
+hits{a_b="3",a_b_2="2",a_b_3="1"} 1
+# TYPE size histogram
+size_bucket{_le="x",_name__="y",le="10"} 1
+size_bucket{_le="x",_name__="y",le="+Inf"} 1
+size_sum{_le="x",_name__="y"} 5
+size_count{_le="x",_name__="y"} 1
+`
+	if got := b.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
@@ -0,0 +1,66 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !disable_events
+
+package event_test
+
+import (
+	"context"
+	"testing"
+
+	"golang.org/x/exp/event"
+	"golang.org/x/exp/event/eventtest"
+)
+
+func TestTraceSampleRate(t *testing.T) {
+	h := &eventtest.CaptureHandler{}
+	ctx := event.WithExporter(context.Background(), event.NewExporter(h, &event.ExporterOptions{TraceSampleRate: 0.5}))
+
+	const n = 1000
+	sampled := 0
+	for i := 0; i < n; i++ {
+		root := event.Start(ctx, "root")
+		child := event.Start(root, "child")
+		event.Log(child, "always delivered")
+		latency.Record(child, 1)
+		event.End(child, event.DurationMetric.Of(latency))
+		event.End(root)
+
+		rootSampled := event.SpanContextOf(root).Flags&event.FlagSampled != 0
+		if childSampled := event.SpanContextOf(child).Flags&event.FlagSampled != 0; childSampled != rootSampled {
+			t.Fatalf("child sampled = %v, root sampled = %v", childSampled, rootSampled)
+		}
+		// A log and two metrics, and the spans if they're sampled.
+		want := 3
+		if rootSampled {
+			want += 4
+			sampled++
+		}
+		if len(h.Got) != want {
+			t.Fatalf("got %d events, want %d", len(h.Got), want)
+		}
+		h.Reset()
+	}
+	if sampled < n/2-100 || sampled > n/2+100 {
+		t.Errorf("sampled %d of %d traces, want about half", sampled, n)
+	}
+
+	// A remote parent's decision is followed.
+	for _, flags := range []event.TraceFlags{0, event.FlagSampled} {
+		remote := event.SpanContext{TraceID: event.TraceID{1}, SpanID: event.SpanID{1}, Flags: flags}
+		for i := 0; i < 10; i++ {
+			span := event.Start(event.WithRemoteSpanContext(ctx, remote), "span")
+			event.End(span)
+		}
+		want := 0
+		if flags != 0 {
+			want = 20
+		}
+		if len(h.Got) != want {
+			t.Errorf("remote flags %x: got %d events, want %d", flags, len(h.Got), want)
+		}
+		h.Reset()
+	}
+}
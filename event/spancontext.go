@@ -0,0 +1,146 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !disable_events
+
+package event
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"time"
+)
+
+// TraceID identifies a distributed trace, as in the W3C Trace Context
+// specification.
+type TraceID [16]byte
+
+// IsValid reports whether t is not all zeros.
+func (t TraceID) IsValid() bool { return t != TraceID{} }
+
+func (t TraceID) String() string { return hex.EncodeToString(t[:]) }
+
+// SpanID identifies a span within a distributed trace.
+type SpanID [8]byte
+
+// IsValid reports whether s is not all zeros.
+func (s SpanID) IsValid() bool { return s != SpanID{} }
+
+func (s SpanID) String() string { return hex.EncodeToString(s[:]) }
+
+// TraceFlags are the flags of a W3C trace context.
+type TraceFlags byte
+
+// FlagSampled is set if the caller may have recorded the trace.
+const FlagSampled TraceFlags = 0x01
+
+// SpanContext identifies a span to other processes.
+//
+// The spans started by Start get a SpanContext that continues the trace of
+// the remote span added to the context with WithRemoteSpanContext, if any.
+// Otherwise each local root span starts a new trace.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Flags   TraceFlags
+
+	// State is the vendor specific trace state, in the format of the W3C
+	// tracestate header. It's passed on unchanged.
+	State string
+
+	// Remote is true if the span is in another process.
+	Remote bool
+}
+
+// IsValid reports whether sc has a trace and a span.
+func (sc SpanContext) IsValid() bool { return sc.TraceID.IsValid() && sc.SpanID.IsValid() }
+
+// SpanContextOf returns the SpanContext of the innermost span of ctx, which
+// is either the last span started with Start or the remote span added with
+// WithRemoteSpanContext. If there's no span, it returns the zero
+// SpanContext.
+func SpanContextOf(ctx context.Context) SpanContext {
+	t, _ := ctx.Value(contextKey).(*target)
+	return t.spanContext()
+}
+
+// SpanContext returns the SpanContext of the span the event occurred in.
+// For a StartKind event, that's the parent of the span it starts; the
+// context its handler is passed has the new span.
+func (ev *Event) SpanContext() SpanContext {
+	return ev.target.spanContext()
+}
+
+// WithRemoteSpanContext returns a context in which the spans started with
+// Start are children of the remote span sc. The context must already have
+// an exporter, or there must be a default one. If sc isn't valid, or there
+// is no exporter, it returns ctx unchanged.
+func WithRemoteSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	if !sc.IsValid() {
+		return ctx
+	}
+	t, ok := ctx.Value(contextKey).(*target)
+	if !ok {
+		t = getDefaultTarget()
+	}
+	if t == nil {
+		return ctx
+	}
+	sc.Remote = true
+	return context.WithValue(ctx, contextKey, &target{exporter: t.exporter, remote: &sc})
+}
+
+// spanContext returns the SpanContext of the span of t.
+func (t *target) spanContext() SpanContext {
+	if t == nil {
+		return SpanContext{}
+	}
+	var sc SpanContext
+	switch {
+	case t.remote != nil:
+		sc = *t.remote
+	case t.root != 0:
+		sc.TraceID = t.exporter.traceID(t.root)
+	default:
+		return SpanContext{}
+	}
+	if t.parent != 0 {
+		sc.SpanID = t.exporter.spanID(t.parent)
+		sc.Remote = false
+		if t.unsampled {
+			sc.Flags &^= FlagSampled
+		} else {
+			sc.Flags |= FlagSampled
+		}
+	}
+	return sc
+}
+
+// traceID returns the ID of the trace whose local root span is the event
+// root. The exporter's random trace prefix keeps it distinct from the
+// traces of other processes.
+func (e *Exporter) traceID(root uint64) TraceID {
+	var id TraceID
+	binary.BigEndian.PutUint64(id[:8], e.tracePrefix)
+	binary.BigEndian.PutUint64(id[8:], root)
+	return id
+}
+
+// spanID returns the span ID of the span started by event id. Event IDs
+// are only unique within the exporter, so they're mixed with a random key.
+func (e *Exporter) spanID(id uint64) SpanID {
+	var s SpanID
+	binary.BigEndian.PutUint64(s[:], id^e.spanKey)
+	return s
+}
+
+func randomUint64() uint64 {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return uint64(time.Now().UnixNano())
+	}
+	return binary.BigEndian.Uint64(b[:])
+}
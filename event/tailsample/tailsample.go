@@ -0,0 +1,227 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !disable_events
+
+// Package tailsample decides which traces to keep after they finish, so
+// that the slow traces and those with errors are kept even when most are
+// dropped.
+//
+// A Handler holds back the span events and logs of each tree of spans
+// until its root span ends, and then passes them on to the next handler
+// only if the tree was slow or logged an error. Trees too big to hold back
+// are kept. Metrics, and events outside of spans, are passed on at once.
+package tailsample
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"golang.org/x/exp/event"
+)
+
+// Options configures a Handler.
+type Options struct {
+	// Slow is the duration of a root span from which its tree is kept. If
+	// it's zero, trees are only kept for their errors.
+	Slow time.Duration
+
+	// MaxEvents is the most events held back for a tree. A tree with more
+	// is kept, since it may yet turn out to be slow or to have an error.
+	// The default is 1000.
+	MaxEvents int
+
+	// MaxAge is the longest time a tree is held back, from the start of its
+	// root. An older tree is decided early, as if its root ended. Trees are
+	// only decided when events are passed to the Handler, so a tree that's
+	// too old is decided at the next event, and is held back for as long
+	// as no events arrive. The default is one minute.
+	MaxAge time.Duration
+}
+
+// Handler is an event.Handler that samples trees of spans after their root
+// ends.
+//
+// A tree is kept if its root span lasted at least Options.Slow, or if any of
+// its spans logged an error, with event.Error or otherwise with a label
+// named "error". The events of a tree are held back until its root ends, so
+// the contexts that the next handler returns for them are discarded. A tree
+// with more than Options.MaxEvents events is kept, and a tree that's older
+// than Options.MaxAge is kept if it's slow or has an error so far.
+//
+// Once a tree is decided, the Handler forgets its spans, so the events of
+// its spans that are still running are passed on as if they weren't in a
+// span, even if the tree was dropped.
+type Handler struct {
+	next event.Handler
+	opts Options
+
+	mu      sync.Mutex
+	spans   map[uint64]*tree // undecided trees, by the IDs of their spans' StartKind events
+	pending []*tree          // trees that may be undecided, in the order their roots started
+}
+
+var _ event.Handler = (*Handler)(nil)
+
+// tree is a root span and its descendants.
+type tree struct {
+	root    uint64   // ID of the root's StartKind event
+	spans   []uint64 // IDs of the StartKind events of the spans in the tree
+	start   time.Time
+	events  []buffered // held back until the tree is decided
+	err     bool
+	decided bool
+}
+
+// buffered is an event held back until its tree is decided.
+type buffered struct {
+	ctx context.Context
+	ev  *event.Event
+}
+
+// NewHandler returns a Handler that passes the events it keeps on to next,
+// which may be nil.
+func NewHandler(next event.Handler, opts *Options) *Handler {
+	h := &Handler{
+		next:  next,
+		spans: make(map[uint64]*tree),
+	}
+	if opts != nil {
+		h.opts = *opts
+	}
+	if h.opts.MaxEvents <= 0 {
+		h.opts.MaxEvents = 1000
+	}
+	if h.opts.MaxAge <= 0 {
+		h.opts.MaxAge = time.Minute
+	}
+	return h
+}
+
+func (h *Handler) Event(ctx context.Context, ev *event.Event) context.Context {
+	switch ev.Kind {
+	case event.StartKind, event.EndKind, event.LogKind:
+	default:
+		return h.forward(ctx, ev)
+	}
+
+	h.mu.Lock()
+	if kept := h.sweep(ev.At); len(kept) != 0 {
+		h.mu.Unlock()
+		h.release(kept)
+		h.mu.Lock()
+	}
+	t := h.spans[ev.Parent]
+	switch {
+	case ev.Kind == event.StartKind && ev.Parent == 0:
+		t = &tree{root: ev.ID, spans: []uint64{ev.ID}, start: ev.At}
+		h.spans[ev.ID] = t
+		h.pending = append(h.pending, t)
+	case t == nil:
+		// The event isn't in a span, or its tree has been decided.
+		h.mu.Unlock()
+		return h.forward(ctx, ev)
+	case ev.Kind == event.StartKind:
+		h.spans[ev.ID] = t
+		t.spans = append(t.spans, ev.ID)
+	case ev.Kind == event.EndKind:
+		delete(h.spans, ev.Parent)
+	case hasError(ev):
+		t.err = true
+	}
+
+	var keep bool
+	switch {
+	case ev.Kind == event.EndKind && ev.Parent == t.root:
+		keep = h.worthKeeping(t, ev.At)
+	case len(t.events) >= h.opts.MaxEvents:
+		// The tree is too big to hold back any more of it. Keep it, since
+		// big trees are the likeliest to turn out slow.
+		keep = true
+	default:
+		t.events = append(t.events, buffered{ctx, clone(ev)})
+		h.mu.Unlock()
+		return ctx
+	}
+	events := h.decide(t, keep)
+	h.mu.Unlock()
+	if !keep {
+		return ctx
+	}
+	h.release(events)
+	return h.forward(ctx, ev)
+}
+
+// worthKeeping reports whether t is slow or has an error at time now.
+func (h *Handler) worthKeeping(t *tree, now time.Time) bool {
+	return t.err || (h.opts.Slow > 0 && now.Sub(t.start) >= h.opts.Slow)
+}
+
+// decide records whether to keep t, forgets its spans, and returns its held
+// back events if it's kept. h.mu must be held.
+func (h *Handler) decide(t *tree, keep bool) []buffered {
+	t.decided = true
+	for _, id := range t.spans {
+		if h.spans[id] == t {
+			delete(h.spans, id)
+		}
+	}
+	events := t.events
+	t.spans, t.events = nil, nil
+	if !keep {
+		return nil
+	}
+	return events
+}
+
+// sweep decides the pending trees that are older than MaxAge at time now,
+// and returns the events of those that are kept. h.mu must be held.
+func (h *Handler) sweep(now time.Time) []buffered {
+	var kept []buffered
+	for len(h.pending) > 0 {
+		t := h.pending[0]
+		if !t.decided {
+			if now.Sub(t.start) < h.opts.MaxAge {
+				break
+			}
+			kept = append(kept, h.decide(t, h.worthKeeping(t, now))...)
+		}
+		h.pending[0] = nil
+		h.pending = h.pending[1:]
+	}
+	return kept
+}
+
+// release passes on held back events.
+func (h *Handler) release(events []buffered) {
+	for _, b := range events {
+		h.forward(b.ctx, b.ev)
+	}
+}
+
+func (h *Handler) forward(ctx context.Context, ev *event.Event) context.Context {
+	if h.next == nil {
+		return ctx
+	}
+	return h.next.Event(ctx, ev)
+}
+
+// clone copies ev to hold it back. Unlike ev.Clone, the copy isn't taken
+// from the event pool, since it's never delivered to return it there.
+func clone(ev *event.Event) *event.Event {
+	c := *ev
+	c.Labels = append([]event.Label(nil), ev.Labels...)
+	return &c
+}
+
+// hasError reports whether ev has a label named "error".
+func hasError(ev *event.Event) bool {
+	for _, l := range ev.Labels {
+		if l.Name == "error" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,172 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !disable_events
+
+package tailsample_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/exp/event"
+	"golang.org/x/exp/event/eventtest"
+	"golang.org/x/exp/event/tailsample"
+)
+
+func TestHandler(t *testing.T) {
+	capture := &eventtest.CaptureHandler{}
+	h := tailsample.NewHandler(capture, &tailsample.Options{Slow: 10 * time.Second})
+	// Each event advances the clock by a second.
+	ctx := event.WithExporter(context.Background(), event.NewExporter(h, eventtest.ExporterOptions()))
+	hits := event.NewCounter("hits", nil)
+
+	// A fast tree is dropped, but not its metrics.
+	fast := event.Start(ctx, "fast")
+	child := event.Start(fast, "fast child")
+	event.Log(child, "working")
+	hits.Record(child, 1)
+	event.End(child)
+	event.End(fast)
+
+	// A tree with an error is kept.
+	failed := event.Start(ctx, "failed")
+	child = event.Start(failed, "failed child")
+	event.Error(child, "oops", errors.New("oops"))
+	event.End(child)
+	event.End(failed)
+
+	// A slow tree is kept, but the events outside it aren't held back.
+	slow := event.Start(ctx, "slow")
+	for i := 0; i < 10; i++ {
+		event.Log(ctx, "outside")
+	}
+	// A span that outlives its root is passed on after the root ends.
+	child = event.Start(slow, "slow child")
+	event.End(slow)
+	event.Log(child, "late")
+	event.End(child)
+
+	got := describe(capture.Got, true)
+	want := []string{
+		"2: metric hits",
+		"0: start failed",
+		"7: start failed child",
+		"8: log oops",
+		"8: end",
+		"7: end",
+		"0: log outside", "0: log outside", "0: log outside", "0: log outside", "0: log outside",
+		"0: log outside", "0: log outside", "0: log outside", "0: log outside", "0: log outside",
+		"0: start slow",
+		"12: start slow child",
+		"12: end",
+		"23: log late",
+		"23: end",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+}
+
+func TestHandlerLimits(t *testing.T) {
+	capture := &eventtest.CaptureHandler{}
+	h := tailsample.NewHandler(capture, &tailsample.Options{Slow: time.Hour, MaxEvents: 3, MaxAge: 20 * time.Second})
+	ctx := event.WithExporter(context.Background(), event.NewExporter(h, eventtest.ExporterOptions()))
+
+	// A tree with too many events is kept, and the rest of its events are
+	// passed on at once, including the error that would have kept it
+	// anyway.
+	busy := event.Start(ctx, "busy")
+	for i := 0; i < 4; i++ {
+		event.Log(busy, "busy")
+	}
+	event.Error(busy, "oops", errors.New("oops"))
+	event.End(busy)
+
+	// A dropped tree's spans are forgotten, so the events of a span that
+	// outlives its root are passed on.
+	fast := event.Start(ctx, "fast")
+	child := event.Start(fast, "fast child")
+	event.End(fast)
+	event.Log(child, "orphan")
+	event.End(child)
+
+	// A tree that never ends is decided once it's too old.
+	stuck := event.Start(ctx, "stuck")
+	event.Error(stuck, "stuck", errors.New("stuck"))
+	for i := 0; i < 20; i++ {
+		event.Log(ctx, "outside")
+	}
+
+	got := describe(capture.Got, false)
+	want := []string{
+		"start busy", "log busy", "log busy", "log busy", "log busy", "log oops", "end",
+		"log orphan", "end",
+		"log outside", "log outside", "log outside", "log outside", "log outside",
+		"log outside", "log outside", "log outside", "log outside", "log outside",
+		"log outside", "log outside", "log outside", "log outside", "log outside",
+		"log outside", "log outside", "log outside",
+		"start stuck", "log stuck",
+		"log outside", "log outside",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+}
+
+func TestHandlerSlowAfterOverflow(t *testing.T) {
+	capture := &eventtest.CaptureHandler{}
+	h := tailsample.NewHandler(capture, &tailsample.Options{Slow: 10 * time.Second, MaxEvents: 3})
+	// Each event advances the clock by a second.
+	ctx := event.WithExporter(context.Background(), event.NewExporter(h, eventtest.ExporterOptions()))
+
+	// The tree overflows while it's still fast. It turns out to be slow,
+	// so none of it may be dropped.
+	big := event.Start(ctx, "big")
+	for i := 0; i < 12; i++ {
+		event.Log(big, "work")
+	}
+	event.End(big)
+
+	got := describe(capture.Got, false)
+	if len(got) != 14 || got[0] != "start big" || got[13] != "end" {
+		t.Errorf("got %q, want the whole tree", got)
+	}
+}
+
+func TestHandlerNoNext(t *testing.T) {
+	h := tailsample.NewHandler(nil, nil)
+	ctx := event.WithExporter(context.Background(), event.NewExporter(h, eventtest.ExporterOptions()))
+	ctx = event.Start(ctx, "span")
+	event.Error(ctx, "oops", errors.New("oops"))
+	event.NewCounter("hits", nil).Record(ctx, 1)
+	event.End(ctx)
+}
+
+// describe describes events by their kind, name, message or metric, and
+// optionally their parent.
+func describe(evs []event.Event, parents bool) []string {
+	var got []string
+	for _, ev := range evs {
+		s := ev.Kind.String()
+		if l := ev.Find("name"); l.HasValue() {
+			s += " " + l.String()
+		}
+		if l := ev.Find("msg"); l.HasValue() {
+			s += " " + l.String()
+		}
+		if m, ok := event.MetricKey.Find(&ev); ok {
+			s += " " + m.(event.Metric).Name()
+		}
+		if parents {
+			s = fmt.Sprintf("%d: %s", ev.Parent, s)
+		}
+		got = append(got, s)
+	}
+	return got
+}
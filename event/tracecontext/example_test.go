@@ -0,0 +1,46 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !disable_events
+
+package tracecontext_test
+
+import (
+	"context"
+	"fmt"
+
+	"golang.org/x/exp/event"
+	"golang.org/x/exp/event/tracecontext"
+)
+
+// metaPropagator carries the trace context in string metadata, such as the
+// Meta of a jsonrpc2 Request. It implements jsonrpc2.Propagator.
+type metaPropagator struct{}
+
+func (metaPropagator) Inject(ctx context.Context, meta map[string]string) {
+	tracecontext.Inject(ctx, func(key, value string) { meta[key] = value })
+}
+
+func (metaPropagator) Extract(ctx context.Context, meta map[string]string) context.Context {
+	return tracecontext.Extract(ctx, func(key string) string { return meta[key] })
+}
+
+func Example_meta() {
+	var p metaPropagator
+
+	// The caller injects its span into the metadata of the request.
+	ctx := event.WithExporter(context.Background(), event.NewExporter(&spanHandler{}, nil))
+	ctx = event.Start(ctx, "call")
+	defer event.End(ctx)
+	meta := map[string]string{}
+	p.Inject(ctx, meta)
+
+	// The handler extracts it before starting its own span.
+	hctx := event.WithExporter(context.Background(), event.NewExporter(&spanHandler{}, nil))
+	hctx = event.Start(p.Extract(hctx, meta), "handle")
+	defer event.End(hctx)
+
+	fmt.Println(event.SpanContextOf(hctx).TraceID == event.SpanContextOf(ctx).TraceID)
+	// Output: true
+}
@@ -0,0 +1,133 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !disable_events
+
+// Package tracecontext propagates the spans of event.Start between
+// processes in the W3C Trace Context format
+// (https://www.w3.org/TR/trace-context/).
+//
+// A client injects the span context of its current span into the headers
+// of a request, and the server extracts it, so that the spans the server
+// starts are in the same trace as the client's. Inject and Extract work
+// with any string metadata, such as the Meta of a jsonrpc2 Request.
+package tracecontext
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"golang.org/x/exp/event"
+)
+
+// The names of the headers.
+const (
+	TraceparentHeader = "traceparent"
+	TracestateHeader  = "tracestate"
+)
+
+// Inject calls set with the headers for the current span of ctx. It does
+// nothing if there's no span.
+func Inject(ctx context.Context, set func(key, value string)) {
+	sc := event.SpanContextOf(ctx)
+	if !sc.IsValid() {
+		return
+	}
+	traceparent, tracestate := Format(sc)
+	set(TraceparentHeader, traceparent)
+	if tracestate != "" {
+		set(TracestateHeader, tracestate)
+	}
+}
+
+// Extract returns a context in which the spans started with event.Start
+// continue the trace of the headers returned by get. If there are no valid
+// headers, it returns ctx. As with event.WithRemoteSpanContext, the
+// context must already have an exporter.
+func Extract(ctx context.Context, get func(key string) string) context.Context {
+	sc, err := Parse(get(TraceparentHeader), get(TracestateHeader))
+	if err != nil {
+		return ctx
+	}
+	return event.WithRemoteSpanContext(ctx, sc)
+}
+
+// InjectHTTP sets the headers for the current span of ctx in h.
+func InjectHTTP(ctx context.Context, h http.Header) {
+	Inject(ctx, h.Set)
+}
+
+// ExtractHTTP is like Extract, with the headers in h. A tracestate header
+// that is split over several lines is joined.
+func ExtractHTTP(ctx context.Context, h http.Header) context.Context {
+	return Extract(ctx, func(key string) string {
+		return strings.Join(h.Values(key), ",")
+	})
+}
+
+// Format returns the values of the traceparent and tracestate headers for
+// sc.
+func Format(sc event.SpanContext) (traceparent, tracestate string) {
+	return fmt.Sprintf("00-%s-%s-%02x", sc.TraceID, sc.SpanID, byte(sc.Flags)), sc.State
+}
+
+// Parse parses the values of the traceparent and tracestate headers into a
+// remote SpanContext. It accepts versions of traceparent later than 00 as
+// the specification requires, by ignoring any fields after the flags.
+func Parse(traceparent, tracestate string) (event.SpanContext, error) {
+	s := strings.TrimSpace(traceparent)
+	if len(s) < 55 || s[2] != '-' || s[35] != '-' || s[52] != '-' {
+		return event.SpanContext{}, errors.New("tracecontext: malformed traceparent")
+	}
+	var version [1]byte
+	if err := decodeHex(version[:], s[:2]); err != nil {
+		return event.SpanContext{}, err
+	}
+	switch {
+	case version[0] == 0xff:
+		return event.SpanContext{}, errors.New("tracecontext: invalid traceparent version ff")
+	case version[0] == 0 && len(s) != 55:
+		return event.SpanContext{}, errors.New("tracecontext: malformed traceparent")
+	case len(s) > 55 && s[55] != '-':
+		return event.SpanContext{}, errors.New("tracecontext: malformed traceparent")
+	}
+	var sc event.SpanContext
+	var flags [1]byte
+	if err := decodeHex(sc.TraceID[:], s[3:35]); err != nil {
+		return event.SpanContext{}, err
+	}
+	if err := decodeHex(sc.SpanID[:], s[36:52]); err != nil {
+		return event.SpanContext{}, err
+	}
+	if err := decodeHex(flags[:], s[53:55]); err != nil {
+		return event.SpanContext{}, err
+	}
+	if !sc.TraceID.IsValid() || !sc.SpanID.IsValid() {
+		return event.SpanContext{}, errors.New("tracecontext: traceparent has a zero ID")
+	}
+	sc.Flags = event.TraceFlags(flags[0])
+	if version[0] != 0 {
+		// Only the meaning of the flags of version 00 is known.
+		sc.Flags &= event.FlagSampled
+	}
+	sc.State = strings.TrimSpace(tracestate)
+	sc.Remote = true
+	return sc, nil
+}
+
+// decodeHex decodes the lowercase hex s into dst, which must have the right
+// length.
+func decodeHex(dst []byte, s string) error {
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+			return fmt.Errorf("tracecontext: invalid character %q in traceparent", c)
+		}
+	}
+	_, err := hex.Decode(dst, []byte(s))
+	return err
+}
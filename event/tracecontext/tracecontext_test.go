@@ -0,0 +1,151 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !disable_events
+
+package tracecontext_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/exp/event"
+	"golang.org/x/exp/event/tracecontext"
+)
+
+func TestParse(t *testing.T) {
+	for _, test := range []struct {
+		traceparent string
+		want        string // formatted again, or "" if invalid
+	}{
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"},
+		{" 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00 ", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00"},
+		// Later versions may have more fields, and only the sampled flag is kept.
+		{"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-03-extra", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"},
+		{"", ""},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", ""},
+		{"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01extra", ""},
+		{"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", ""},
+		{"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", ""},
+		{"00-00000000000000000000000000000000-00f067aa0ba902b7-01", ""},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", ""},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736_00f067aa0ba902b7-01", ""},
+	} {
+		sc, err := tracecontext.Parse(test.traceparent, "")
+		var got string
+		if err == nil {
+			if !sc.Remote {
+				t.Errorf("Parse(%q) is not remote", test.traceparent)
+			}
+			got, _ = tracecontext.Format(sc)
+		}
+		if got != test.want {
+			t.Errorf("Parse(%q) formatted as %q, want %q", test.traceparent, got, test.want)
+		}
+	}
+}
+
+// spanHandler records the span contexts of the spans that are started.
+type spanHandler struct {
+	parents, spans []event.SpanContext
+}
+
+func (h *spanHandler) Event(ctx context.Context, ev *event.Event) context.Context {
+	if ev.Kind == event.StartKind {
+		h.parents = append(h.parents, ev.SpanContext())
+		h.spans = append(h.spans, event.SpanContextOf(ctx))
+	}
+	return ctx
+}
+
+func TestPropagation(t *testing.T) {
+	client := &spanHandler{}
+	ctx := event.WithExporter(context.Background(), event.NewExporter(client, nil))
+	if sc := event.SpanContextOf(ctx); sc.IsValid() {
+		t.Fatalf("got span %v before any span was started", sc)
+	}
+	h := http.Header{}
+	tracecontext.InjectHTTP(ctx, h)
+	if len(h) != 0 {
+		t.Fatalf("injected %v without a span", h)
+	}
+
+	ctx = event.Start(ctx, "call")
+	ctx = event.Start(ctx, "send")
+	tracecontext.InjectHTTP(ctx, h)
+	event.End(ctx)
+
+	server := &spanHandler{}
+	sctx := event.WithExporter(context.Background(), event.NewExporter(server, nil))
+	h.Add(tracecontext.TracestateHeader, "a=1")
+	h.Add(tracecontext.TracestateHeader, "b=2")
+	sctx = tracecontext.ExtractHTTP(sctx, h)
+	sctx = event.Start(sctx, "handle")
+	sctx = event.Start(sctx, "work")
+
+	call, send := client.spans[0], client.spans[1]
+	if call.TraceID != send.TraceID || call.SpanID == send.SpanID {
+		t.Errorf("client spans %v and %v aren't distinct spans in one trace", call, send)
+	}
+	if diff := cmp.Diff(event.SpanContext{}, client.parents[0]); diff != "" {
+		t.Errorf("client root span has a parent (-want, +got):\n%s", diff)
+	}
+	wantParent := send
+	wantParent.Remote = true
+	wantParent.State = "a=1,b=2"
+	if diff := cmp.Diff(wantParent, server.parents[0]); diff != "" {
+		t.Errorf("server span parent mismatch (-want, +got):\n%s", diff)
+	}
+	handle, work := server.spans[0], server.spans[1]
+	for _, sc := range []event.SpanContext{handle, work} {
+		if sc.TraceID != send.TraceID || sc.Remote || sc.State != "a=1,b=2" {
+			t.Errorf("server span %+v doesn't continue trace %v", sc, send.TraceID)
+		}
+	}
+	if server.parents[1] != handle {
+		t.Errorf("got parent %+v, want %+v", server.parents[1], handle)
+	}
+	if got := event.SpanContextOf(sctx); got != work {
+		t.Errorf("SpanContextOf = %+v, want %+v", got, work)
+	}
+}
+
+func TestMetaPropagation(t *testing.T) {
+	var p metaPropagator
+	caller := &spanHandler{}
+	ctx := event.WithExporter(context.Background(), event.NewExporter(caller, nil))
+	meta := map[string]string{}
+	p.Inject(ctx, meta)
+	if len(meta) != 0 {
+		t.Fatalf("injected %v without a span", meta)
+	}
+	ctx = event.Start(ctx, "call")
+	p.Inject(ctx, meta)
+	event.End(ctx)
+
+	// The metadata goes over the wire as a map of strings, like the Meta
+	// of a jsonrpc2 Request.
+	handler := &spanHandler{}
+	hctx := event.WithExporter(context.Background(), event.NewExporter(handler, nil))
+	hctx = event.Start(p.Extract(hctx, meta), "handle")
+	event.End(hctx)
+
+	call, handle := caller.spans[0], handler.spans[0]
+	if handle.TraceID != call.TraceID || handle.SpanID == call.SpanID {
+		t.Errorf("handler span %+v doesn't continue the trace of caller span %+v", handle, call)
+	}
+	if parent := handler.parents[0]; parent.SpanID != call.SpanID || !parent.Remote {
+		t.Errorf("handler span parent %+v, want the remote caller span %+v", parent, call)
+	}
+
+	// Without metadata, the handler starts a trace of its own.
+	hctx = event.WithExporter(context.Background(), event.NewExporter(handler, nil))
+	hctx = event.Start(p.Extract(hctx, nil), "handle")
+	event.End(hctx)
+	if sc := handler.spans[1]; sc.TraceID == call.TraceID {
+		t.Errorf("handler span %+v continues the trace without metadata", sc)
+	}
+}
@@ -36,6 +36,10 @@ type ConnectionOptions struct {
 	// Handler is used as the queued message handler for inbound messages.
 	// If nil, all responses will be ErrNotHandled.
 	Handler Handler
+	// Propagator carries values from the context of outgoing requests to
+	// the context of the handlers for them, using the Meta of the requests.
+	// If nil, no values are carried.
+	Propagator Propagator
 }
 
 // Connection manages the jsonrpc2 protocol, connecting responses back to their
@@ -48,6 +52,7 @@ type Connection struct {
 	writerBox   chan Writer
 	outgoingBox chan map[ID]chan<- *Response
 	incomingBox chan map[ID]*incoming
+	propagator  Propagator
 	async       async
 }
 
@@ -100,6 +105,10 @@ func newConnection(ctx context.Context, rwc io.ReadWriteCloser, binder Binder) (
 	if options.Handler == nil {
 		options.Handler = defaultHandler{}
 	}
+	if options.Propagator == nil {
+		options.Propagator = defaultHandler{}
+	}
+	c.propagator = options.Propagator
 	c.outgoingBox <- make(map[ID]chan<- *Response)
 	c.incomingBox <- make(map[ID]*incoming)
 	// the goroutines started here will continue until the underlying stream is closed
@@ -298,7 +307,7 @@ func (c *Connection) readIncoming(ctx context.Context, reader Reader, toQueue ch
 			if msg.IsCall() {
 				idLabel = RPCID(fmt.Sprintf("%q", msg.ID))
 			}
-			entry.baseCtx = event.Start(ctx, msg.Method,
+			entry.baseCtx = event.Start(c.propagator.Extract(ctx, msg.Meta), msg.Method,
 				Method(msg.Method), RPCDirection(Inbound), idLabel)
 			Started.Record(entry.baseCtx, 1, Method(msg.Method))
 			ReceivedBytes.Record(entry.baseCtx, n, Method(msg.Method))
@@ -469,6 +478,17 @@ func (c *Connection) respond(entry *incoming, result interface{}, rerr error) er
 func (c *Connection) write(ctx context.Context, msg Message) error {
 	writer := <-c.writerBox
 	defer func() { c.writerBox <- writer }()
+	if req, ok := msg.(*Request); ok {
+		// The propagator is only set once the connection is ready, which
+		// it is once we have the writer.
+		if req.Meta == nil {
+			req.Meta = make(map[string]string)
+		}
+		c.propagator.Inject(ctx, req.Meta)
+		if len(req.Meta) == 0 {
+			req.Meta = nil
+		}
+	}
 	n, err := writer.Write(ctx, msg)
 	// TODO: get a method label in here somehow.
 	SentBytes.Record(ctx, n)
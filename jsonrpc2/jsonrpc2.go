@@ -41,12 +41,30 @@ type Handler interface {
 	Handle(ctx context.Context, req *Request) (interface{}, error)
 }
 
+// Propagator carries values from the context of outgoing requests to the
+// context their handlers run in, as metadata in the Meta of the requests.
+// golang.org/x/exp/event/tracecontext can be used to implement it, so that
+// the spans the handlers start continue the trace of the caller; its
+// package example shows how.
+type Propagator interface {
+	// Inject adds the metadata to send for ctx to meta.
+	Inject(ctx context.Context, meta map[string]string)
+	// Extract returns ctx with the values carried in meta.
+	Extract(ctx context.Context, meta map[string]string) context.Context
+}
+
 type defaultHandler struct{}
 
 func (defaultHandler) Preempt(context.Context, *Request) (interface{}, error) {
 	return nil, ErrNotHandled
 }
 
+func (defaultHandler) Inject(context.Context, map[string]string) {}
+
+func (defaultHandler) Extract(ctx context.Context, _ map[string]string) context.Context {
+	return ctx
+}
+
 func (defaultHandler) Handle(context.Context, *Request) (interface{}, error) {
 	return nil, ErrNotHandled
 }
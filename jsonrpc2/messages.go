@@ -35,6 +35,11 @@ type Request struct {
 	Method string
 	// Params is either a struct or an array with the parameters of the method.
 	Params json.RawMessage
+	// Meta is optional metadata about the request, such as the trace context
+	// of the caller, which the Propagator of the connection fills in and
+	// reads. It is an extension to the specification, sent as the "meta"
+	// member of the request object.
+	Meta map[string]string
 }
 
 // Response is a Message used as a reply to a call Request.
@@ -81,6 +86,7 @@ func (msg *Request) marshal(to *wireCombined) {
 	to.ID = msg.ID.value
 	to.Method = msg.Method
 	to.Params = msg.Params
+	to.Meta = msg.Meta
 }
 
 // NewResponse constructs a new Response message that is a reply to the
@@ -152,6 +158,7 @@ func DecodeMessage(data []byte) (Message, error) {
 			Method: msg.Method,
 			ID:     id,
 			Params: msg.Params,
+			Meta:   msg.Meta,
 		}, nil
 	}
 	// no method, should be a response
@@ -142,3 +142,67 @@ func newFake(t *testing.T, ctx context.Context, l jsonrpc2.Listener) (*jsonrpc2.
 		server.Wait()
 	}, nil
 }
+
+type callerKey struct{}
+
+// callerPropagator carries the caller's name, stored under callerKey.
+type callerPropagator struct{}
+
+func (callerPropagator) Inject(ctx context.Context, meta map[string]string) {
+	if v, ok := ctx.Value(callerKey{}).(string); ok {
+		meta["caller"] = v
+	}
+}
+
+func (callerPropagator) Extract(ctx context.Context, meta map[string]string) context.Context {
+	if v, ok := meta["caller"]; ok {
+		ctx = context.WithValue(ctx, callerKey{}, v)
+	}
+	return ctx
+}
+
+func TestPropagator(t *testing.T) {
+	stacktest.NoLeak(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	listener, err := jsonrpc2.NetPipe(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := jsonrpc2.Serve(ctx, listener, jsonrpc2.ConnectionOptions{
+		Handler: jsonrpc2.HandlerFunc(func(ctx context.Context, req *jsonrpc2.Request) (interface{}, error) {
+			caller, _ := ctx.Value(callerKey{}).(string)
+			return &msg{caller}, nil
+		}),
+		Propagator: callerPropagator{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := jsonrpc2.Dial(ctx, listener.Dialer(), jsonrpc2.ConnectionOptions{
+		Propagator: callerPropagator{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		listener.Close()
+		client.Close()
+		server.Wait()
+	}()
+
+	for _, want := range []string{"fish", ""} {
+		ctx := ctx
+		if want != "" {
+			ctx = context.WithValue(ctx, callerKey{}, want)
+		}
+		var got msg
+		if err := client.Call(ctx, "who", nil).Await(ctx, &got); err != nil {
+			t.Fatal(err)
+		}
+		if got.Msg != want {
+			t.Errorf("handler saw caller %q, want %q", got.Msg, want)
+		}
+	}
+}
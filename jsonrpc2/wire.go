@@ -40,12 +40,13 @@ const wireVersion = "2.0"
 // wireCombined has all the fields of both Request and Response.
 // We can decode this and then work out which it is.
 type wireCombined struct {
-	VersionTag string          `json:"jsonrpc"`
-	ID         interface{}     `json:"id,omitempty"`
-	Method     string          `json:"method,omitempty"`
-	Params     json.RawMessage `json:"params,omitempty"`
-	Result     json.RawMessage `json:"result,omitempty"`
-	Error      *wireError      `json:"error,omitempty"`
+	VersionTag string            `json:"jsonrpc"`
+	ID         interface{}       `json:"id,omitempty"`
+	Method     string            `json:"method,omitempty"`
+	Params     json.RawMessage   `json:"params,omitempty"`
+	Meta       map[string]string `json:"meta,omitempty"`
+	Result     json.RawMessage   `json:"result,omitempty"`
+	Error      *wireError        `json:"error,omitempty"`
 }
 
 // wireError represents a structured error in a Response.
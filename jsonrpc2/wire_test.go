@@ -34,6 +34,14 @@ func TestWireMessage(t *testing.T) {
 		name:    "numerical id",
 		msg:     newCall(1, "poke", nil),
 		encoded: []byte(`{"jsonrpc":"2.0","id":1,"method":"poke"}`),
+	}, {
+		name: "metadata",
+		msg: &jsonrpc2.Request{
+			ID:     jsonrpc2.StringID("msg3"),
+			Method: "ping",
+			Meta:   map[string]string{"traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"},
+		},
+		encoded: []byte(`{"jsonrpc":"2.0","id":"msg3","method":"ping","meta":{"traceparent":"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"}}`),
 	}, {
 		// originally reported in #39719, this checks that result is not present if
 		// it is an error response
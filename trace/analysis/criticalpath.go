@@ -0,0 +1,320 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.21
+
+package analysis
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"time"
+
+	"golang.org/x/exp/trace"
+)
+
+// CriticalPath explains the latency of some interval on a goroutine, like
+// a task or a region, by following the chain of goroutines that it waited on.
+//
+// The path is computed backwards from the end of the interval. Whenever the
+// goroutine on the path was blocked and then unblocked by another goroutine,
+// the other goroutine becomes the cause, and the path continues on it from
+// the point where it did the unblocking. Likewise, the path continues on the
+// creator of a goroutine from the point where it was created. Blocked time
+// without a known cause, like time spent waiting on the network or a timer,
+// stays on the path as is.
+type CriticalPath struct {
+	// Goroutine is the goroutine the interval ended on.
+	Goroutine trace.GoID
+
+	// Start and End are the bounds of the interval.
+	Start, End trace.Time
+
+	// Segments is the path itself, in time order. Segments don't overlap,
+	// and together they cover the interval, unless the path leads to a
+	// point before the trace started.
+	Segments []Segment
+
+	// Wakeups are the edges between goroutines along the path, in time order.
+	Wakeups []Wakeup
+}
+
+// Segment is part of a critical path, spent by one goroutine in one state.
+type Segment struct {
+	Goroutine  trace.GoID
+	Start, End trace.Time
+	State      trace.GoState
+
+	// Reason is why the goroutine was blocked, if State is GoWaiting.
+	Reason string
+
+	// Stack is the goroutine's stack when it entered the state, if known.
+	Stack trace.Stack
+}
+
+// Wakeup is an edge in a critical path where one goroutine allowed
+// another to make progress.
+type Wakeup struct {
+	// From unblocked or created To at Time, with stack Stack.
+	From, To trace.GoID
+	Time     trace.Time
+	Stack    trace.Stack
+
+	// Created is true if From created To, rather than unblocking it.
+	Created bool
+
+	// Reason is why To was blocked, and BlockTime and BlockStack are
+	// when and where it blocked. They're unset if Created is true.
+	Reason     string
+	BlockTime  trace.Time
+	BlockStack trace.Stack
+}
+
+// Breakdown returns the time spent along the path in each state.
+func (p *CriticalPath) Breakdown() Breakdown {
+	var b Breakdown
+	for _, s := range p.Segments {
+		b.add(s.State, s.Reason, s.End.Sub(s.Start))
+	}
+	return b
+}
+
+// Goroutines returns the time each goroutine spent on the path.
+func (p *CriticalPath) Goroutines() map[trace.GoID]time.Duration {
+	m := make(map[trace.GoID]time.Duration)
+	for _, s := range p.Segments {
+		m[s.Goroutine] += s.End.Sub(s.Start)
+	}
+	return m
+}
+
+// History records the state of every goroutine over the course of a trace,
+// which is needed to compute critical paths.
+type History struct {
+	gs         map[trace.GoID]*goHistory
+	tasks      map[trace.TaskID]*taskHistory
+	start, end trace.Time
+}
+
+// goHistory is the history of a single goroutine.
+type goHistory struct {
+	intervals []interval
+
+	// The goroutine that created this one, if it was created during the trace.
+	creator     trace.GoID
+	createStack trace.Stack
+}
+
+// interval is a period of time a goroutine spent in one state.
+// It ends at the start of the next interval.
+type interval struct {
+	start  trace.Time
+	state  trace.GoState
+	reason string
+	stack  trace.Stack
+
+	// If the interval is a wait that ended because of another goroutine,
+	// the goroutine that ended it, and where.
+	waker     trace.GoID
+	wakeStack trace.Stack
+}
+
+type taskHistory struct {
+	begin, end *trace.Event
+}
+
+// NewHistory creates a new, empty History.
+func NewHistory() *History {
+	return &History{
+		gs:    make(map[trace.GoID]*goHistory),
+		tasks: make(map[trace.TaskID]*taskHistory),
+	}
+}
+
+// ReadHistory returns the History of the rest of the trace read by r.
+func ReadHistory(r *trace.Reader) (*History, error) {
+	h := NewHistory()
+	for {
+		ev, err := r.ReadEvent()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		h.Event(&ev)
+	}
+	return h, nil
+}
+
+// Event feeds the next event in the trace to the history.
+func (h *History) Event(ev *trace.Event) {
+	t := ev.Time()
+	if ev.Kind() != trace.EventSync {
+		if h.start == 0 {
+			h.start = t
+		}
+		h.end = t
+	}
+
+	switch ev.Kind() {
+	case trace.EventStateTransition:
+		st := ev.StateTransition()
+		if st.Resource.Kind != trace.ResourceGoroutine {
+			break
+		}
+		id := st.Resource.Goroutine()
+		from, to := st.Goroutine()
+		gh, ok := h.gs[id]
+		if !ok {
+			gh = &goHistory{creator: trace.NoGoroutine}
+			h.gs[id] = gh
+		}
+		if from == to && len(gh.intervals) != 0 {
+			// A status event for a goroutine we already know about.
+			break
+		}
+		if cause := ev.Goroutine(); cause != id && cause != trace.NoGoroutine {
+			switch {
+			case from == trace.GoNotExist && len(gh.intervals) == 0:
+				gh.creator = cause
+				gh.createStack = ev.Stack()
+			case from == trace.GoWaiting && len(gh.intervals) != 0:
+				last := &gh.intervals[len(gh.intervals)-1]
+				last.waker = cause
+				last.wakeStack = ev.Stack()
+			}
+		}
+		gh.intervals = append(gh.intervals, interval{
+			start:  t,
+			state:  to,
+			reason: st.Reason,
+			stack:  st.Stack,
+			waker:  trace.NoGoroutine,
+		})
+	case trace.EventTaskBegin:
+		evc := *ev
+		h.task(ev.Task().ID).begin = &evc
+	case trace.EventTaskEnd:
+		evc := *ev
+		h.task(ev.Task().ID).end = &evc
+	}
+}
+
+func (h *History) task(id trace.TaskID) *taskHistory {
+	th, ok := h.tasks[id]
+	if !ok {
+		th = new(taskHistory)
+		h.tasks[id] = th
+	}
+	return th
+}
+
+// TaskCriticalPath returns the critical path of the task with the given ID,
+// which ends on the goroutine that ended the task. If the task began before
+// the trace started, the path begins at the start of the trace.
+func (h *History) TaskCriticalPath(id trace.TaskID) (*CriticalPath, error) {
+	th, ok := h.tasks[id]
+	if !ok {
+		return nil, fmt.Errorf("task %d not found", id)
+	}
+	if th.end == nil {
+		return nil, fmt.Errorf("task %d did not end during the trace", id)
+	}
+	start := h.start
+	if th.begin != nil {
+		start = th.begin.Time()
+	}
+	return h.CriticalPath(th.end.Goroutine(), start, th.end.Time()), nil
+}
+
+// RegionCriticalPath returns the critical path of a region. Regions that are
+// missing their start or end are clamped to the bounds of the trace.
+func (h *History) RegionCriticalPath(r *Region) *CriticalPath {
+	start, end := h.start, h.end
+	if r.Start != nil {
+		start = r.Start.Time()
+	}
+	if r.End != nil {
+		end = r.End.Time()
+	} else if gh, ok := h.gs[r.Goroutine]; ok {
+		// The region might have ended because the goroutine exited.
+		if n := len(gh.intervals); n != 0 && gh.intervals[n-1].state == trace.GoNotExist {
+			end = gh.intervals[n-1].start
+		}
+	}
+	return h.CriticalPath(r.Goroutine, start, end)
+}
+
+// CriticalPath returns the critical path of the interval [start, end]
+// ending on goroutine g.
+func (h *History) CriticalPath(g trace.GoID, start, end trace.Time) *CriticalPath {
+	p := &CriticalPath{Goroutine: g, Start: start, End: end}
+	t := end
+	visited := make(map[trace.GoID]bool) // goroutines we've jumped to at time t.
+	for t > start {
+		gh, ok := h.gs[g]
+		if !ok {
+			break
+		}
+		ivs := gh.intervals
+		i := sort.Search(len(ivs), func(i int) bool { return ivs[i].start >= t }) - 1
+		if i < 0 {
+			// We've reached the beginning of the goroutine's history.
+			if gh.creator == trace.NoGoroutine || len(ivs) == 0 || ivs[0].start != t || visited[gh.creator] {
+				break
+			}
+			p.Wakeups = append(p.Wakeups, Wakeup{
+				From:    gh.creator,
+				To:      g,
+				Time:    t,
+				Stack:   gh.createStack,
+				Created: true,
+			})
+			visited[g] = true
+			g = gh.creator
+			continue
+		}
+		iv := &ivs[i]
+		if iv.state == trace.GoNotExist || iv.state == trace.GoUndetermined {
+			break
+		}
+		endsAtT := i+1 < len(ivs) && ivs[i+1].start == t
+		if iv.state == trace.GoWaiting && endsAtT && iv.waker != trace.NoGoroutine && !visited[iv.waker] {
+			p.Wakeups = append(p.Wakeups, Wakeup{
+				From:       iv.waker,
+				To:         g,
+				Time:       t,
+				Stack:      iv.wakeStack,
+				Reason:     iv.reason,
+				BlockTime:  iv.start,
+				BlockStack: iv.stack,
+			})
+			visited[g] = true
+			g = iv.waker
+			continue
+		}
+		s := Segment{
+			Goroutine: g,
+			Start:     iv.start,
+			End:       t,
+			State:     iv.state,
+			Stack:     iv.stack,
+		}
+		if s.Start < start {
+			s.Start = start
+		}
+		if s.State == trace.GoWaiting {
+			s.Reason = iv.reason
+		}
+		p.Segments = append(p.Segments, s)
+		t = s.Start
+		clear(visited)
+	}
+	slices.Reverse(p.Segments)
+	slices.Reverse(p.Wakeups)
+	return p
+}
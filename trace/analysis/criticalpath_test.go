@@ -0,0 +1,155 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.21
+
+package analysis_test
+
+import (
+	"bytes"
+	"io"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/exp/trace"
+	"golang.org/x/exp/trace/analysis"
+	"golang.org/x/exp/trace/internal/event/go122"
+	testgen "golang.org/x/exp/trace/internal/testgen/go122"
+	"golang.org/x/exp/trace/internal/testtrace"
+)
+
+func TestCriticalPath(t *testing.T) {
+	tt := testgen.NewTrace()
+	tt.ExpectSuccess()
+	g1 := tt.Generation(1)
+	b := g1.Batch(trace.ThreadID(0), 0)
+	b.Event("ProcStatus", trace.ProcID(0), go122.ProcRunning)
+	b.Event("GoStatus", trace.GoID(1), trace.ThreadID(0), go122.GoRunning)
+	b.Event("GoStatus", trace.GoID(2), trace.NoThread, go122.GoRunnable)
+	b.Event("UserRegionBegin", trace.TaskID(0), "work", testgen.NoStack)
+	b.Event("GoBlock", "chan receive", testgen.NoStack)
+	b.Event("GoStart", trace.GoID(2), testgen.Seq(1))
+	b.Event("GoUnblock", trace.GoID(1), testgen.Seq(1), testgen.NoStack)
+	b.Event("GoStop", "preempted", testgen.NoStack)
+	b.Event("GoStart", trace.GoID(1), testgen.Seq(2))
+	b.Event("UserRegionEnd", trace.TaskID(0), "work", testgen.NoStack)
+
+	var evs []trace.Event
+	r := generatedReader(t, tt)
+	h := analysis.NewHistory()
+	s := analysis.NewSummarizer()
+	for {
+		ev, err := r.ReadEvent()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		h.Event(&ev)
+		s.Event(&ev)
+		evs = append(evs, ev)
+	}
+	regions := s.Finalize().Goroutines[1].Regions
+	if len(regions) != 1 {
+		t.Fatalf("got %d regions, want 1", len(regions))
+	}
+	p := h.RegionCriticalPath(regions[0])
+
+	// Goroutine 1 waited on goroutine 2, which was waiting to run, so
+	// the path starts on goroutine 2.
+	tick := func(n int) trace.Time { return evs[0].Time() + trace.Time(64*n) }
+	want := []analysis.Segment{
+		{Goroutine: 2, Start: tick(3), End: tick(5), State: trace.GoRunnable},
+		{Goroutine: 2, Start: tick(5), End: tick(6), State: trace.GoRunning},
+		{Goroutine: 1, Start: tick(6), End: tick(8), State: trace.GoRunnable},
+		{Goroutine: 1, Start: tick(8), End: tick(9), State: trace.GoRunning},
+	}
+	if len(p.Segments) != len(want) {
+		t.Fatalf("got %d segments, want %d: %+v", len(p.Segments), len(want), p.Segments)
+	}
+	for i, s := range p.Segments {
+		w := want[i]
+		if s.Goroutine != w.Goroutine || s.Start != w.Start || s.End != w.End || s.State != w.State {
+			t.Errorf("segment %d: got %+v, want %+v", i, s, w)
+		}
+	}
+	if len(p.Wakeups) != 1 {
+		t.Fatalf("got %d wakeups, want 1", len(p.Wakeups))
+	}
+	if w := p.Wakeups[0]; w.From != 2 || w.To != 1 || w.Time != tick(6) || w.Reason != "chan receive" || w.BlockTime != tick(4) {
+		t.Errorf("unexpected wakeup %+v", w)
+	}
+	if got := p.Goroutines(); got[1] != 3*64 || got[2] != 3*64 {
+		t.Errorf("unexpected time per goroutine %v", got)
+	}
+}
+
+func TestCriticalPathGolden(t *testing.T) {
+	matches, err := filepath.Glob("../testdata/tests/*.test")
+	if err != nil {
+		t.Fatalf("failed to glob for tests: %v", err)
+	}
+	for _, testPath := range matches {
+		testPath := testPath
+		t.Run(filepath.Base(testPath), func(t *testing.T) {
+			tr, exp, err := testtrace.ParseFile(testPath)
+			if err != nil {
+				t.Fatalf("failed to parse test file at %s: %v", testPath, err)
+			}
+			if exp.Check(nil) != nil {
+				t.Skip("trace is expected to fail to parse")
+			}
+			b, err := io.ReadAll(tr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r, err := trace.NewReader(bytes.NewReader(b))
+			if err != nil {
+				t.Fatal(err)
+			}
+			s, err := analysis.Summarize(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r, err = trace.NewReader(bytes.NewReader(b))
+			if err != nil {
+				t.Fatal(err)
+			}
+			h, err := analysis.ReadHistory(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, g := range s.Goroutines {
+				for _, r := range g.Regions {
+					checkCriticalPath(t, h.RegionCriticalPath(r))
+				}
+			}
+		})
+	}
+}
+
+func checkCriticalPath(t *testing.T, p *analysis.CriticalPath) {
+	t.Helper()
+
+	if len(p.Segments) == 0 {
+		return
+	}
+	if last := p.Segments[len(p.Segments)-1]; last.End != p.End || last.Goroutine != p.Goroutine {
+		t.Errorf("path for goroutine %d ending at %d ends on goroutine %d at %d", p.Goroutine, p.End, last.Goroutine, last.End)
+	}
+	for i, s := range p.Segments {
+		if s.Start < p.Start || s.End <= s.Start {
+			t.Errorf("bad segment %+v in path [%d, %d]", s, p.Start, p.End)
+		}
+		if i > 0 && p.Segments[i-1].End != s.Start {
+			t.Errorf("segments %+v and %+v aren't contiguous", p.Segments[i-1], s)
+		}
+	}
+	for i, w := range p.Wakeups {
+		if w.Time < p.Start || w.Time > p.End || (i > 0 && p.Wakeups[i-1].Time > w.Time) {
+			t.Errorf("bad wakeup %+v in path [%d, %d]", w, p.Start, p.End)
+		}
+	}
+}
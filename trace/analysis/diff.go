@@ -0,0 +1,328 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.21
+
+package analysis
+
+import (
+	"cmp"
+	"io"
+	"math"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/trace"
+)
+
+// Stats are the latency distributions and counts from a trace that Diff
+// compares.
+type Stats struct {
+	// Regions and Tasks are the durations of user regions and tasks that
+	// began and ended in the trace, by type.
+	Regions map[string][]time.Duration
+	Tasks   map[string][]time.Duration
+
+	// Blocking is the duration of every time a goroutine blocked, by the
+	// reason it blocked and its stack. The key is the reason, then ": "
+	// and the functions on the stack, innermost first, separated by " < ",
+	// so that it's stable across builds of a program.
+	Blocking map[string][]time.Duration
+
+	// GCs are the durations of GC cycles, and Pauses are the durations
+	// of stop-the-world phases, including those of GC, by the name of
+	// the range, like "stop-the-world (GC mark termination)".
+	GCs    []time.Duration
+	Pauses map[string][]time.Duration
+
+	// Goroutines is the number of goroutines that appear in the trace.
+	Goroutines int
+}
+
+// ComputeStats computes Stats for the rest of the trace read by r.
+func ComputeStats(r *trace.Reader) (*Stats, error) {
+	s := &Stats{
+		Regions:  make(map[string][]time.Duration),
+		Tasks:    make(map[string][]time.Duration),
+		Blocking: make(map[string][]time.Duration),
+		Pauses:   make(map[string][]time.Duration),
+	}
+	type blocked struct {
+		since trace.Time
+		key   string
+	}
+	type regionBegin struct {
+		typ  string
+		time trace.Time
+	}
+	goroutines := make(map[trace.GoID]bool)
+	waits := make(map[trace.GoID]blocked)
+	regions := make(map[trace.GoID][]regionBegin)
+	tasks := make(map[trace.TaskID]regionBegin)
+	ranges := make(gcRanges)
+	for {
+		ev, err := r.ReadEvent()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		t := ev.Time()
+		switch ev.Kind() {
+		case trace.EventStateTransition:
+			st := ev.StateTransition()
+			if st.Resource.Kind != trace.ResourceGoroutine {
+				break
+			}
+			id := st.Resource.Goroutine()
+			goroutines[id] = true
+			from, to := st.Goroutine()
+			if from == trace.GoWaiting && to != trace.GoWaiting {
+				if w, ok := waits[id]; ok {
+					s.Blocking[w.key] = append(s.Blocking[w.key], t.Sub(w.since))
+				}
+				delete(waits, id)
+			}
+			if to == trace.GoWaiting && from != trace.GoWaiting && from != trace.GoUndetermined {
+				waits[id] = blocked{t, blockingKey(st.Reason, st.Stack)}
+			}
+			if to == trace.GoNotExist {
+				delete(regions, id)
+			}
+		case trace.EventRegionBegin:
+			id := ev.Goroutine()
+			regions[id] = append(regions[id], regionBegin{ev.Region().Type, t})
+		case trace.EventRegionEnd:
+			id := ev.Goroutine()
+			typ := ev.Region().Type
+			if n := len(regions[id]); n != 0 && regions[id][n-1].typ == typ {
+				s.Regions[typ] = append(s.Regions[typ], t.Sub(regions[id][n-1].time))
+				regions[id] = regions[id][:n-1]
+			}
+		case trace.EventTaskBegin:
+			task := ev.Task()
+			tasks[task.ID] = regionBegin{task.Type, t}
+		case trace.EventTaskEnd:
+			id := ev.Task().ID
+			if b, ok := tasks[id]; ok {
+				s.Tasks[b.typ] = append(s.Tasks[b.typ], t.Sub(b.time))
+				delete(tasks, id)
+			}
+		case trace.EventRangeBegin:
+			ranges.begin(ev.Range(), t)
+		case trace.EventRangeEnd:
+			rg := ev.Range()
+			if !isGCRange(rg.Name) {
+				break
+			}
+			start, ok := ranges.end(rg)
+			if !ok {
+				// The range began before the trace did.
+				break
+			}
+			if rg.Name == gcMarkRange {
+				s.GCs = append(s.GCs, t.Sub(start))
+			} else {
+				s.Pauses[rg.Name] = append(s.Pauses[rg.Name], t.Sub(start))
+			}
+		}
+	}
+	s.Goroutines = len(goroutines)
+	return s, nil
+}
+
+// AllPauses returns the durations of all the stop-the-world phases, for
+// any reason.
+func (s *Stats) AllPauses() []time.Duration {
+	var all []time.Duration
+	for _, ds := range s.Pauses {
+		all = append(all, ds...)
+	}
+	return all
+}
+
+func blockingKey(reason string, stk trace.Stack) string {
+	var b strings.Builder
+	b.WriteString(reason)
+	sep := ": "
+	stk.Frames(func(f trace.StackFrame) bool {
+		b.WriteString(sep)
+		b.WriteString(f.Func)
+		sep = " < "
+		return true
+	})
+	return b.String()
+}
+
+// Delta is the difference in one distribution or count between two traces.
+type Delta struct {
+	// Kind is what's being compared: "region", "task", "blocking", "gc",
+	// "pause", "gc count", or "goroutines".
+	Kind string
+
+	// Name identifies what's being compared within Kind, like a region
+	// type or a blocking stack.
+	Name string
+
+	// Old and New summarize the distribution in each trace. For counts,
+	// only N is set.
+	Old, New Distribution
+
+	// Change is the relative change from the old median, or, for counts,
+	// from the old count. It's NaN if the old value was zero.
+	Change float64
+
+	// P is the p-value of the difference in distributions according to
+	// the Mann-Whitney U-test, or NaN for counts and distributions that
+	// are missing from one of the traces.
+	P float64
+}
+
+// Distribution summarizes a distribution of durations.
+type Distribution struct {
+	N      int
+	Median time.Duration
+	Total  time.Duration
+}
+
+// Significant reports whether the difference is statistically significant
+// at level alpha. Counts are always considered significant if they changed.
+func (d *Delta) Significant(alpha float64) bool {
+	if math.IsNaN(d.P) {
+		return d.Old.N != d.New.N && (d.Kind == "gc count" || d.Kind == "goroutines")
+	}
+	return d.P < alpha
+}
+
+// Diff compares the Stats from an old and a new trace, and returns the
+// differences, sorted by Kind and Name.
+func Diff(old, new *Stats) []Delta {
+	var deltas []Delta
+	diffMap := func(kind string, old, new map[string][]time.Duration) {
+		var names []string
+		for name := range old {
+			names = append(names, name)
+		}
+		for name := range new {
+			if _, ok := old[name]; !ok {
+				names = append(names, name)
+			}
+		}
+		slices.Sort(names)
+		for _, name := range names {
+			deltas = append(deltas, diffDistributions(kind, name, old[name], new[name]))
+		}
+	}
+	diffCount := func(kind string, old, new int) {
+		d := Delta{Kind: kind, Old: Distribution{N: old}, New: Distribution{N: new}, Change: math.NaN(), P: math.NaN()}
+		if old != 0 {
+			d.Change = float64(new)/float64(old) - 1
+		}
+		deltas = append(deltas, d)
+	}
+	diffCount("goroutines", old.Goroutines, new.Goroutines)
+	diffCount("gc count", len(old.GCs), len(new.GCs))
+	if len(old.GCs) != 0 || len(new.GCs) != 0 {
+		deltas = append(deltas, diffDistributions("gc", "", old.GCs, new.GCs))
+	}
+	if oldPauses, newPauses := old.AllPauses(), new.AllPauses(); len(oldPauses) != 0 || len(newPauses) != 0 {
+		deltas = append(deltas, diffDistributions("pause", "", oldPauses, newPauses))
+	}
+	diffMap("region", old.Regions, new.Regions)
+	diffMap("task", old.Tasks, new.Tasks)
+	diffMap("blocking", old.Blocking, new.Blocking)
+	slices.SortStableFunc(deltas, func(a, b Delta) int {
+		return cmp.Compare(kindOrder(a.Kind), kindOrder(b.Kind))
+	})
+	return deltas
+}
+
+func kindOrder(kind string) int {
+	return slices.Index([]string{"goroutines", "gc count", "gc", "pause", "region", "task", "blocking"}, kind)
+}
+
+func diffDistributions(kind, name string, old, new []time.Duration) Delta {
+	d := Delta{
+		Kind:   kind,
+		Name:   name,
+		Old:    distribution(old),
+		New:    distribution(new),
+		Change: math.NaN(),
+		P:      math.NaN(),
+	}
+	if d.Old.Median != 0 {
+		d.Change = float64(d.New.Median)/float64(d.Old.Median) - 1
+	}
+	if len(old) != 0 && len(new) != 0 {
+		d.P = mannWhitneyU(old, new)
+	}
+	return d
+}
+
+func distribution(ds []time.Duration) Distribution {
+	if len(ds) == 0 {
+		return Distribution{}
+	}
+	sorted := slices.Clone(ds)
+	slices.Sort(sorted)
+	d := Distribution{N: len(ds)}
+	if n := len(sorted); n%2 == 1 {
+		d.Median = sorted[n/2]
+	} else {
+		d.Median = (sorted[n/2-1] + sorted[n/2]) / 2
+	}
+	for _, v := range ds {
+		d.Total += v
+	}
+	return d
+}
+
+// mannWhitneyU returns the two-sided p-value of the Mann-Whitney U-test of
+// whether x and y come from the same distribution. It uses the normal
+// approximation, with corrections for ties and continuity.
+func mannWhitneyU(x, y []time.Duration) float64 {
+	type sample struct {
+		v   time.Duration
+		inX bool
+	}
+	all := make([]sample, 0, len(x)+len(y))
+	for _, v := range x {
+		all = append(all, sample{v, true})
+	}
+	for _, v := range y {
+		all = append(all, sample{v, false})
+	}
+	slices.SortFunc(all, func(a, b sample) int { return cmp.Compare(a.v, b.v) })
+
+	// Sum the ranks of x, giving tied values the average of their ranks.
+	var rx, ties float64
+	for i := 0; i < len(all); {
+		j := i
+		for j < len(all) && all[j].v == all[i].v {
+			j++
+		}
+		rank := float64(i+j+1) / 2 // The average of the ranks i+1 through j.
+		for k := i; k < j; k++ {
+			if all[k].inX {
+				rx += rank
+			}
+		}
+		t := float64(j - i)
+		ties += t*t*t - t
+		i = j
+	}
+
+	n1, n2 := float64(len(x)), float64(len(y))
+	n := n1 + n2
+	u := rx - n1*(n1+1)/2
+	mu := n1 * n2 / 2
+	sigma := math.Sqrt(n1 * n2 / 12 * (n + 1 - ties/(n*(n-1))))
+	if sigma == 0 {
+		return 1
+	}
+	z := math.Max(math.Abs(u-mu)-0.5, 0) / sigma
+	return math.Erfc(z / math.Sqrt2)
+}
@@ -0,0 +1,88 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.21
+
+package analysis_test
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"golang.org/x/exp/trace"
+	"golang.org/x/exp/trace/analysis"
+	"golang.org/x/exp/trace/internal/testtrace"
+)
+
+func TestDiff(t *testing.T) {
+	ms := func(vs ...int) []time.Duration {
+		var ds []time.Duration
+		for _, v := range vs {
+			ds = append(ds, time.Duration(v)*time.Millisecond)
+		}
+		return ds
+	}
+	old := &analysis.Stats{
+		Regions:    map[string][]time.Duration{"same": ms(1, 2, 3), "slower": ms(1, 2, 3, 4, 5), "gone": ms(1)},
+		Goroutines: 10,
+	}
+	new := &analysis.Stats{
+		Regions:    map[string][]time.Duration{"same": ms(3, 2, 1), "slower": ms(6, 7, 8, 9, 10), "added": ms(1)},
+		Goroutines: 15,
+	}
+	deltas := analysis.Diff(old, new)
+	want := []struct {
+		kind, name  string
+		change, p   float64
+		significant bool
+	}{
+		{"goroutines", "", 0.5, math.NaN(), true},
+		{"gc count", "", math.NaN(), math.NaN(), false},
+		{"region", "added", math.NaN(), math.NaN(), false},
+		{"region", "gone", -1, math.NaN(), false},
+		{"region", "same", 0, 1, false},
+		// U = 0, so z = (12.5 - 0.5) / sqrt(25 / 12 * 11).
+		{"region", "slower", 8.0/3 - 1, 0.0122, true},
+	}
+	if len(deltas) != len(want) {
+		t.Fatalf("got %d deltas, want %d: %+v", len(deltas), len(want), deltas)
+	}
+	same := func(a, b float64) bool {
+		return math.IsNaN(a) && math.IsNaN(b) || math.Abs(a-b) < 1e-3
+	}
+	for i, w := range want {
+		d := deltas[i]
+		if d.Kind != w.kind || d.Name != w.name || !same(d.Change, w.change) || !same(d.P, w.p) || d.Significant(0.05) != w.significant {
+			t.Errorf("delta %d: got %+v (significant: %v), want %+v", i, d, d.Significant(0.05), w)
+		}
+	}
+}
+
+func TestDiffSelf(t *testing.T) {
+	stats := func() *analysis.Stats {
+		tr, _, err := testtrace.ParseFile("../testdata/tests/go122-annotations-stress.test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := trace.NewReader(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s, err := analysis.ComputeStats(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	old, new := stats(), stats()
+	if len(old.Regions) == 0 || len(old.Tasks) == 0 || len(old.Blocking) == 0 || len(old.Pauses) == 0 || old.Goroutines == 0 {
+		t.Fatalf("missing stats: %+v", old)
+	}
+	for _, d := range analysis.Diff(old, new) {
+		if d.Significant(0.05) || (!math.IsNaN(d.Change) && d.Change != 0) {
+			t.Errorf("trace differs from itself: %+v", d)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.21
+
+package analysis
+
+import (
+	"strings"
+
+	"golang.org/x/exp/trace"
+)
+
+// gcMarkRange is the name of the range of the GC's concurrent mark phase.
+const gcMarkRange = "GC concurrent mark phase"
+
+// isGCRange reports whether name is the name of the GC's mark phase or of
+// a stop-the-world phase.
+func isGCRange(name string) bool {
+	return name == gcMarkRange || isSTWRange(name)
+}
+
+// isSTWRange reports whether name is the name of a stop-the-world phase.
+func isSTWRange(name string) bool {
+	return strings.HasPrefix(name, "stop-the-world")
+}
+
+// rangeKey identifies a range in progress.
+type rangeKey struct {
+	scope trace.ResourceID
+	name  string
+}
+
+// gcRanges holds the times the GC and stop-the-world ranges in progress
+// began.
+type gcRanges map[rangeKey]trace.Time
+
+// begin records that rg began at time t, if it's a GC range, and reports
+// whether it is.
+func (m gcRanges) begin(rg trace.Range, t trace.Time) bool {
+	if !isGCRange(rg.Name) {
+		return false
+	}
+	m[rangeKey{rg.Scope, rg.Name}] = t
+	return true
+}
+
+// active records that rg was in progress at time t, if it's a GC range and
+// its beginning isn't already known, and reports whether it's a GC range. A
+// Reader reports the ranges in progress at the start of every generation,
+// so only the first report of a range says anything about when it began.
+func (m gcRanges) active(rg trace.Range, t trace.Time) bool {
+	if !isGCRange(rg.Name) {
+		return false
+	}
+	k := rangeKey{rg.Scope, rg.Name}
+	if _, ok := m[k]; !ok {
+		m[k] = t
+	}
+	return true
+}
+
+// end forgets rg, and returns the time it began, if it's known.
+func (m gcRanges) end(rg trace.Range) (trace.Time, bool) {
+	k := rangeKey{rg.Scope, rg.Name}
+	start, ok := m[k]
+	delete(m, k)
+	return start, ok
+}
@@ -0,0 +1,359 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.21
+
+// Package analysis computes higher-level information about the execution
+// of a program from the events in its execution trace.
+package analysis
+
+import (
+	"io"
+	"time"
+
+	"golang.org/x/exp/trace"
+)
+
+// Breakdown is a breakdown of the time a goroutine spent in each state.
+type Breakdown struct {
+	// Running is the time spent executing.
+	Running time.Duration
+
+	// Runnable is the time spent waiting to be scheduled,
+	// that is, scheduler latency.
+	Runnable time.Duration
+
+	// Syscall is the time spent in system calls.
+	Syscall time.Duration
+
+	// Blocked is the time spent blocked, by the reason the goroutine
+	// blocked for, like "chan receive", "select", "sync", or
+	// "GC mark assist wait for work".
+	Blocked map[string]time.Duration
+
+	// Ranges is the time spent in special ranges, like "GC mark assist",
+	// by the name of the range. This time overlaps with the time spent in
+	// the states above.
+	Ranges map[string]time.Duration
+}
+
+// Total returns the total time accounted for by the breakdown,
+// not including Ranges.
+func (b *Breakdown) Total() time.Duration {
+	return b.Running + b.Runnable + b.Syscall + b.TotalBlocked()
+}
+
+// TotalBlocked returns the total time spent blocked, for any reason.
+func (b *Breakdown) TotalBlocked() time.Duration {
+	var total time.Duration
+	for _, d := range b.Blocked {
+		total += d
+	}
+	return total
+}
+
+// add adds d to the time spent in state.
+func (b *Breakdown) add(state trace.GoState, reason string, d time.Duration) {
+	switch state {
+	case trace.GoRunning:
+		b.Running += d
+	case trace.GoRunnable:
+		b.Runnable += d
+	case trace.GoSyscall:
+		b.Syscall += d
+	case trace.GoWaiting:
+		if b.Blocked == nil {
+			b.Blocked = make(map[string]time.Duration)
+		}
+		b.Blocked[reason] += d
+	}
+}
+
+// addRange adds d to the time spent in the range called name.
+func (b *Breakdown) addRange(name string, d time.Duration) {
+	if b.Ranges == nil {
+		b.Ranges = make(map[string]time.Duration)
+	}
+	b.Ranges[name] += d
+}
+
+func (b *Breakdown) clone() Breakdown {
+	c := *b
+	if b.Blocked != nil {
+		c.Blocked = make(map[string]time.Duration, len(b.Blocked))
+		for r, d := range b.Blocked {
+			c.Blocked[r] = d
+		}
+	}
+	if b.Ranges != nil {
+		c.Ranges = make(map[string]time.Duration, len(b.Ranges))
+		for r, d := range b.Ranges {
+			c.Ranges[r] = d
+		}
+	}
+	return c
+}
+
+// Goroutine summarizes the execution of a single goroutine.
+type Goroutine struct {
+	ID trace.GoID
+
+	// Name is the name of the goroutine's entry function, if known.
+	Name string
+
+	// CreationTime, StartTime, and EndTime are the times at which the
+	// goroutine was created, first started running, and exited. Each is
+	// zero if it didn't happen during the trace.
+	CreationTime trace.Time
+	StartTime    trace.Time
+	EndTime      trace.Time
+
+	// Breakdown covers the part of the goroutine's lifetime that's
+	// visible in the trace.
+	Breakdown
+
+	// Regions are the goroutine's user regions, in the order they began.
+	// Regions that began before the trace started come at the point where
+	// they ended.
+	Regions []*Region
+}
+
+// Region summarizes a user region on a goroutine.
+type Region struct {
+	// Type is the region's type, as passed to runtime/trace.StartRegion.
+	Type string
+
+	// Task is the task the region belongs to.
+	Task trace.TaskID
+
+	// Goroutine is the goroutine the region executed on.
+	Goroutine trace.GoID
+
+	// Start and End are the events that began and ended the region.
+	// Start is nil if the region began before the trace started, and
+	// End is nil if the region never ended, either because the goroutine
+	// exited or because the trace ended first.
+	Start, End *trace.Event
+
+	// Breakdown covers the part of the region that's visible in the trace.
+	Breakdown
+}
+
+// Summary summarizes the execution of all goroutines in a trace.
+type Summary struct {
+	// Goroutines contains all the goroutines that appear in the trace.
+	Goroutines map[trace.GoID]*Goroutine
+
+	// Start and End are the times of the first and last events in the
+	// trace, not counting sync events.
+	Start, End trace.Time
+}
+
+// Summarize computes a summary of the rest of the trace read by r.
+func Summarize(r *trace.Reader) (*Summary, error) {
+	s := NewSummarizer()
+	for {
+		ev, err := r.ReadEvent()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		s.Event(&ev)
+	}
+	return s.Finalize(), nil
+}
+
+// Summarizer computes a Summary from a stream of events.
+type Summarizer struct {
+	sum *Summary
+	gs  map[trace.GoID]*goState
+}
+
+// goState is the state of a goroutine at the current point in the trace.
+type goState struct {
+	g        *Goroutine
+	state    trace.GoState
+	reason   string
+	lastTime trace.Time
+	regions  []*Region
+	ranges   map[string]trace.Time // in-flight ranges and when they began.
+}
+
+// NewSummarizer creates a new Summarizer.
+func NewSummarizer() *Summarizer {
+	return &Summarizer{
+		sum: &Summary{Goroutines: make(map[trace.GoID]*Goroutine)},
+		gs:  make(map[trace.GoID]*goState),
+	}
+}
+
+// Event feeds the next event in the trace to the summarizer.
+func (s *Summarizer) Event(ev *trace.Event) {
+	t := ev.Time()
+	if ev.Kind() != trace.EventSync {
+		// Sync events are synthesized by the reader, and the one at the
+		// end of the trace doesn't have a meaningful timestamp.
+		if s.sum.Start == 0 {
+			s.sum.Start = t
+		}
+		s.sum.End = t
+	}
+
+	switch ev.Kind() {
+	case trace.EventStateTransition:
+		st := ev.StateTransition()
+		if st.Resource.Kind != trace.ResourceGoroutine {
+			break
+		}
+		from, to := st.Goroutine()
+		gs := s.goroutine(st.Resource.Goroutine(), t)
+		if from != trace.GoUndetermined {
+			s.advance(gs, t)
+		}
+		g := gs.g
+		if from == trace.GoNotExist && to != trace.GoNotExist {
+			g.CreationTime = t
+		}
+		if to == trace.GoRunning && g.StartTime == 0 {
+			g.StartTime = t
+		}
+		if to == trace.GoNotExist {
+			// Any regions still active end with the goroutine.
+			g.EndTime = t
+			gs.regions = nil
+		}
+		if g.Name == "" {
+			// The outermost frame of the goroutine's stack on creation, or
+			// of its current stack, is the goroutine's entry function.
+			st.Stack.Frames(func(f trace.StackFrame) bool {
+				g.Name = f.Func
+				return true
+			})
+		}
+		gs.state = to
+		gs.reason = st.Reason
+	case trace.EventRegionBegin:
+		gs := s.goroutine(ev.Goroutine(), t)
+		s.advance(gs, t)
+		r := ev.Region()
+		evc := *ev
+		region := &Region{Type: r.Type, Task: r.Task, Goroutine: gs.g.ID, Start: &evc}
+		gs.regions = append(gs.regions, region)
+		gs.g.Regions = append(gs.g.Regions, region)
+	case trace.EventRegionEnd:
+		gs := s.goroutine(ev.Goroutine(), t)
+		s.advance(gs, t)
+		r := ev.Region()
+		evc := *ev
+		if n := len(gs.regions); n != 0 && gs.regions[n-1].Type == r.Type {
+			gs.regions[n-1].End = &evc
+			gs.regions = gs.regions[:n-1]
+			break
+		}
+		// The region began before the trace, so the goroutine's
+		// whole visible lifetime so far is part of it.
+		gs.g.Regions = append(gs.g.Regions, &Region{
+			Type:      r.Type,
+			Task:      r.Task,
+			Goroutine: gs.g.ID,
+			End:       &evc,
+			Breakdown: gs.g.Breakdown.clone(),
+		})
+	case trace.EventRangeBegin:
+		r := ev.Range()
+		if r.Scope.Kind != trace.ResourceGoroutine {
+			break
+		}
+		s.goroutine(r.Scope.Goroutine(), t).beginRange(r.Name, t)
+	case trace.EventRangeActive:
+		r := ev.Range()
+		if r.Scope.Kind != trace.ResourceGoroutine {
+			break
+		}
+		s.goroutine(r.Scope.Goroutine(), t).activeRange(r.Name, t)
+	case trace.EventRangeEnd:
+		r := ev.Range()
+		if r.Scope.Kind != trace.ResourceGoroutine {
+			break
+		}
+		s.endRange(s.goroutine(r.Scope.Goroutine(), t), r.Name, t)
+	}
+}
+
+// Finalize returns the summary of all the events seen so far.
+func (s *Summarizer) Finalize() *Summary {
+	for _, gs := range s.gs {
+		s.advance(gs, s.sum.End)
+		for name := range gs.ranges {
+			s.endRange(gs, name, s.sum.End)
+		}
+	}
+	return s.sum
+}
+
+// goroutine returns the state for goroutine id, which is first seen at t.
+func (s *Summarizer) goroutine(id trace.GoID, t trace.Time) *goState {
+	gs, ok := s.gs[id]
+	if !ok {
+		g := &Goroutine{ID: id}
+		gs = &goState{g: g, state: trace.GoUndetermined, lastTime: t}
+		s.gs[id] = gs
+		s.sum.Goroutines[id] = g
+	}
+	return gs
+}
+
+// advance accounts for the time the goroutine spent in its current state
+// up until t.
+func (s *Summarizer) advance(gs *goState, t trace.Time) {
+	d := t.Sub(gs.lastTime)
+	gs.lastTime = t
+	if d <= 0 {
+		return
+	}
+	gs.g.add(gs.state, gs.reason, d)
+	for _, r := range gs.regions {
+		r.add(gs.state, gs.reason, d)
+	}
+}
+
+// beginRange records that the range called name began on the goroutine
+// at t.
+func (gs *goState) beginRange(name string, t trace.Time) {
+	if gs.ranges == nil {
+		gs.ranges = make(map[string]trace.Time)
+	}
+	gs.ranges[name] = t
+}
+
+// activeRange records that the range called name was in progress on the
+// goroutine at t, if its beginning isn't already known. A Reader reports
+// the ranges in progress at the start of every generation, so only the
+// first report of a range says anything about when it began.
+func (gs *goState) activeRange(name string, t trace.Time) {
+	if _, ok := gs.ranges[name]; !ok {
+		gs.beginRange(name, t)
+	}
+}
+
+// endRange ends the range called name on the goroutine at t.
+func (s *Summarizer) endRange(gs *goState, name string, t trace.Time) {
+	start, ok := gs.ranges[name]
+	if !ok {
+		// The range began before the trace started, so we don't know
+		// how long it's been going on for.
+		return
+	}
+	delete(gs.ranges, name)
+	gs.g.addRange(name, t.Sub(start))
+	for _, r := range gs.regions {
+		rstart := start
+		if r.Start != nil && r.Start.Time() > rstart {
+			rstart = r.Start.Time()
+		}
+		r.addRange(name, t.Sub(rstart))
+	}
+}
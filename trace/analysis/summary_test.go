@@ -0,0 +1,187 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.21
+
+package analysis_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/exp/trace"
+	"golang.org/x/exp/trace/analysis"
+	"golang.org/x/exp/trace/internal/event/go122"
+	testgen "golang.org/x/exp/trace/internal/testgen/go122"
+	"golang.org/x/exp/trace/internal/testtrace"
+	"golang.org/x/exp/trace/tracetest"
+)
+
+func TestSummarizeGolden(t *testing.T) {
+	matches, err := filepath.Glob("../testdata/tests/*.test")
+	if err != nil {
+		t.Fatalf("failed to glob for tests: %v", err)
+	}
+	for _, testPath := range matches {
+		testPath := testPath
+		t.Run(filepath.Base(testPath), func(t *testing.T) {
+			tr, exp, err := testtrace.ParseFile(testPath)
+			if err != nil {
+				t.Fatalf("failed to parse test file at %s: %v", testPath, err)
+			}
+			if exp.Check(nil) != nil {
+				t.Skip("trace is expected to fail to parse")
+			}
+			r, err := trace.NewReader(tr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			s, err := analysis.Summarize(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for id, g := range s.Goroutines {
+				if g.ID != id {
+					t.Errorf("goroutine %d has ID %d", id, g.ID)
+				}
+				if total := g.Total(); total > s.End.Sub(s.Start) {
+					t.Errorf("goroutine %d: total time %v is longer than the trace", id, total)
+				}
+				if g.CreationTime != 0 && g.EndTime != 0 {
+					if total, want := g.Total(), g.EndTime.Sub(g.CreationTime); total != want {
+						t.Errorf("goroutine %d: total time %v, want lifetime %v", id, total, want)
+					}
+				}
+				for _, r := range g.Regions {
+					if r.Goroutine != id {
+						t.Errorf("region %q on goroutine %d has goroutine %d", r.Type, id, r.Goroutine)
+					}
+					start, end := s.Start, s.End
+					if r.Start != nil {
+						start = r.Start.Time()
+					}
+					if r.End != nil {
+						end = r.End.Time()
+					} else if g.EndTime != 0 {
+						end = g.EndTime
+					}
+					if r.Start != nil && r.Total() != end.Sub(start) {
+						t.Errorf("region %q on goroutine %d: total time %v, want %v", r.Type, id, r.Total(), end.Sub(start))
+					}
+					if r.Total() > g.Total() {
+						t.Errorf("region %q on goroutine %d: total time %v is longer than the goroutine's %v", r.Type, id, r.Total(), g.Total())
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	tt := testgen.NewTrace()
+	tt.ExpectSuccess()
+	g1 := tt.Generation(1)
+	b := g1.Batch(trace.ThreadID(0), 0)
+	b.Event("ProcStatus", trace.ProcID(0), go122.ProcRunning)
+	b.Event("GoStatus", trace.GoID(1), trace.ThreadID(0), go122.GoRunning)
+	b.Event("GoCreate", trace.GoID(2), testgen.NoStack, testgen.NoStack)
+	b.Event("GoBlock", "chan receive", testgen.NoStack)
+	b.Event("GoStart", trace.GoID(2), testgen.Seq(1))
+	b.Event("UserRegionBegin", trace.TaskID(0), "work", testgen.NoStack)
+	b.Event("GoUnblock", trace.GoID(1), testgen.Seq(1), testgen.NoStack)
+	b.Event("GoSyscallBegin", testgen.Seq(1), testgen.NoStack)
+	b.Event("GoSyscallEnd")
+	b.Event("UserRegionEnd", trace.TaskID(0), "work", testgen.NoStack)
+	b.Event("GoStop", "preempted", testgen.NoStack)
+	b.Event("GoStart", trace.GoID(1), testgen.Seq(2))
+	b.Event("GoDestroy")
+
+	s, err := analysis.Summarize(generatedReader(t, tt))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each event is one tick after the last, and a tick is 64ns.
+	const tick = 64 * time.Nanosecond
+	g := s.Goroutines[1]
+	if g.Running != 3*tick || g.Runnable != 5*tick || g.Blocked["chan receive"] != 3*tick || g.Syscall != 0 {
+		t.Errorf("unexpected breakdown for goroutine 1: %+v", g.Breakdown)
+	}
+	g = s.Goroutines[2]
+	if g.CreationTime != s.Start+2*trace.Time(tick) || g.StartTime != s.Start+4*trace.Time(tick) || g.EndTime != 0 {
+		t.Errorf("unexpected lifetime for goroutine 2: created %d, started %d, ended %d", g.CreationTime, g.StartTime, g.EndTime)
+	}
+	if g.Running != 5*tick || g.Runnable != 4*tick || g.Syscall != tick || len(g.Blocked) != 0 {
+		t.Errorf("unexpected breakdown for goroutine 2: %+v", g.Breakdown)
+	}
+	if len(g.Regions) != 1 {
+		t.Fatalf("got %d regions on goroutine 2, want 1", len(g.Regions))
+	}
+	if r := g.Regions[0]; r.Type != "work" || r.Running != 3*tick || r.Syscall != tick || r.Runnable != 0 {
+		t.Errorf("unexpected region: %q %+v", r.Type, r.Breakdown)
+	}
+}
+
+func TestSummarizeGenerations(t *testing.T) {
+	// A mark assist in a region, both in progress across a generation
+	// boundary.
+	tt := tracetest.NewTrace()
+	b := tt.Generation().Batch(1)
+	b.ProcStatus(1000, 0, trace.ProcRunning)
+	b.GoStatus(1000, 1, trace.GoRunning)
+	b.RegionBegin(2000, trace.BackgroundTask, "work", nil)
+	b.GCBegin(3000, nil)
+	b.GCMarkAssistBegin(4000, nil)
+	b = tt.Generation().Batch(1)
+	b.ProcStatus(10000, 0, trace.ProcRunning)
+	b.GoStatus(10000, 1, trace.GoRunning)
+	b.GCActive(10000)
+	b.GCMarkAssistActive(10000, 1)
+	b.GCMarkAssistEnd(11000)
+	b.GCEnd(12000)
+	b.RegionEnd(13000, trace.BackgroundTask, "work", nil)
+	b.GoDestroy(14000)
+
+	r, err := trace.NewReader(bytes.NewReader(tt.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := analysis.Summarize(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const assist = "GC mark assist"
+	g := s.Goroutines[1]
+	if got, want := g.Ranges[assist], 7000*time.Nanosecond; got != want {
+		t.Errorf("got %v in mark assists, want %v", got, want)
+	}
+	if len(g.Regions) != 1 {
+		t.Fatalf("got %d regions, want 1", len(g.Regions))
+	}
+	if got, want := g.Regions[0].Ranges[assist], 7000*time.Nanosecond; got != want {
+		t.Errorf("got %v in mark assists in the region, want %v", got, want)
+	}
+}
+
+// generatedReader returns a reader for a trace made with testgen.
+func generatedReader(t *testing.T, tt *testgen.Trace) *trace.Reader {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "generated.test")
+	if err := os.WriteFile(path, tt.Generate(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tr, _, err := testtrace.ParseFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := trace.NewReader(tr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
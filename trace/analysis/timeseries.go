@@ -0,0 +1,296 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.21
+
+package analysis
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/trace"
+)
+
+// TimeSeries is a set of metrics from a trace, aligned on the times that
+// any of them changed.
+//
+// Besides the runtime/metrics samples in Metric events, such as
+// "/memory/classes/heap/objects:bytes" and "/sched/gomaxprocs:threads", it
+// has the following series derived from other events:
+//
+//   - goroutines: the number of goroutines that exist, among those that
+//     have appeared in the trace
+//   - gc.active: 1 while the GC is in its concurrent mark phase, and 0
+//     otherwise
+//   - gc.cycles: the number of GC cycles that began in the trace
+//   - gc.seconds: the total time spent in the GC's mark phase
+//   - stw.seconds: the total time the world was stopped
+//
+// The values are step functions: each one holds until the next time the
+// series changes.
+type TimeSeries struct {
+	// Names are the names of the series, in the order of the columns
+	// of Values.
+	Names []string
+
+	// Times are the times of the rows of Values, relative to the first
+	// event in the trace, in increasing order.
+	Times []time.Duration
+
+	// Values are the values of each series at each time, or NaN before
+	// the first sample of a series.
+	Values [][]float64
+}
+
+// Names of the series ReadTimeSeries derives from events other than Metric
+// events.
+const (
+	seriesGoroutines = "goroutines"
+	seriesGCActive   = "gc.active"
+	seriesGCCycles   = "gc.cycles"
+	seriesGCSeconds  = "gc.seconds"
+	seriesSTWSeconds = "stw.seconds"
+)
+
+// ReadTimeSeries reads the metrics in the rest of the trace read by r.
+func ReadTimeSeries(r *trace.Reader) (*TimeSeries, error) {
+	b := &seriesBuilder{
+		index:  make(map[string]int),
+		ranges: make(gcRanges),
+	}
+	for {
+		ev, err := r.ReadEvent()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		b.event(&ev)
+	}
+	return b.finish(), nil
+}
+
+// seriesBuilder builds a TimeSeries from a stream of events.
+type seriesBuilder struct {
+	ts    TimeSeries
+	index map[string]int // column of each series, in the order they appeared
+	cur   []float64      // the current value of each series
+
+	start  trace.Time
+	ranges gcRanges
+}
+
+func (b *seriesBuilder) event(ev *trace.Event) {
+	if ev.Kind() == trace.EventSync {
+		return
+	}
+	t := ev.Time()
+	if b.start == 0 {
+		b.start = t
+		for _, name := range []string{seriesGoroutines, seriesGCActive, seriesGCCycles, seriesGCSeconds, seriesSTWSeconds} {
+			b.set(t, name, 0)
+		}
+	}
+	switch ev.Kind() {
+	case trace.EventMetric:
+		m := ev.Metric()
+		if m.Value.Kind() != trace.ValueUint64 {
+			break
+		}
+		b.set(t, m.Name, float64(m.Value.Uint64()))
+	case trace.EventStateTransition:
+		st := ev.StateTransition()
+		if st.Resource.Kind != trace.ResourceGoroutine {
+			break
+		}
+		from, to := st.Goroutine()
+		existed := from != trace.GoNotExist && from != trace.GoUndetermined
+		switch {
+		case !existed && to != trace.GoNotExist:
+			b.add(t, seriesGoroutines, 1)
+		case existed && to == trace.GoNotExist:
+			b.add(t, seriesGoroutines, -1)
+		}
+	case trace.EventRangeBegin:
+		rg := ev.Range()
+		if b.ranges.begin(rg, t) && rg.Name == gcMarkRange {
+			b.set(t, seriesGCActive, 1)
+			b.add(t, seriesGCCycles, 1)
+		}
+	case trace.EventRangeActive:
+		rg := ev.Range()
+		if b.ranges.active(rg, t) && rg.Name == gcMarkRange {
+			b.set(t, seriesGCActive, 1)
+		}
+	case trace.EventRangeEnd:
+		rg := ev.Range()
+		if !isGCRange(rg.Name) {
+			break
+		}
+		start, ok := b.ranges.end(rg)
+		if !ok {
+			// The range began before the trace did, so count from the
+			// start of the trace.
+			start = b.start
+		}
+		d := t.Sub(start).Seconds()
+		if rg.Name == gcMarkRange {
+			b.set(t, seriesGCActive, 0)
+			b.add(t, seriesGCSeconds, d)
+		} else {
+			b.add(t, seriesSTWSeconds, d)
+		}
+	}
+}
+
+// set sets series name to v at time t.
+func (b *seriesBuilder) set(t trace.Time, name string, v float64) {
+	i, ok := b.index[name]
+	if !ok {
+		i = len(b.ts.Names)
+		b.index[name] = i
+		b.ts.Names = append(b.ts.Names, name)
+		b.cur = append(b.cur, math.NaN())
+	}
+	b.cur[i] = v
+	d := t.Sub(b.start)
+	if n := len(b.ts.Times); n > 0 && b.ts.Times[n-1] == d {
+		b.ts.Values[n-1] = append(b.ts.Values[n-1][:0], b.cur...)
+		return
+	}
+	b.ts.Times = append(b.ts.Times, d)
+	b.ts.Values = append(b.ts.Values, slices.Clone(b.cur))
+}
+
+// add adds delta to series name at time t.
+func (b *seriesBuilder) add(t trace.Time, name string, delta float64) {
+	v := 0.0
+	if i, ok := b.index[name]; ok {
+		v = b.cur[i]
+	}
+	b.set(t, name, v+delta)
+}
+
+// finish pads the rows to the same length, and sorts the series by name.
+func (b *seriesBuilder) finish() *TimeSeries {
+	ts := &b.ts
+	order := make([]int, len(ts.Names))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortFunc(order, func(i, j int) int {
+		return strings.Compare(ts.Names[i], ts.Names[j])
+	})
+	names := make([]string, len(order))
+	for i, j := range order {
+		names[i] = ts.Names[j]
+	}
+	ts.Names = names
+	for r, row := range ts.Values {
+		sorted := make([]float64, len(order))
+		for i, j := range order {
+			sorted[i] = math.NaN()
+			if j < len(row) {
+				sorted[i] = row[j]
+			}
+		}
+		ts.Values[r] = sorted
+	}
+	return ts
+}
+
+// Resample returns the values of the series at every multiple of interval,
+// from the start of the trace until the last time in ts. Each value is the
+// one that held at that time.
+func (ts *TimeSeries) Resample(interval time.Duration) *TimeSeries {
+	if interval <= 0 {
+		panic("analysis: Resample interval must be positive")
+	}
+	out := &TimeSeries{Names: ts.Names}
+	if len(ts.Times) == 0 {
+		return out
+	}
+	last := ts.Times[len(ts.Times)-1]
+	r := 0
+	for t := time.Duration(0); t <= last; t += interval {
+		for r+1 < len(ts.Times) && ts.Times[r+1] <= t {
+			r++
+		}
+		row := make([]float64, len(ts.Names))
+		for i := range row {
+			row[i] = math.NaN()
+		}
+		if ts.Times[r] <= t {
+			copy(row, ts.Values[r])
+		}
+		out.Times = append(out.Times, t)
+		out.Values = append(out.Values, row)
+	}
+	return out
+}
+
+// WriteCSV writes ts to w as CSV, with a header row. The first column is
+// the time in seconds, and unknown values are empty.
+func (ts *TimeSeries) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write(append([]string{"time"}, ts.Names...))
+	record := make([]string, len(ts.Names)+1)
+	for r, t := range ts.Times {
+		record[0] = formatFloat(t.Seconds())
+		for i, v := range ts.Values[r] {
+			record[i+1] = ""
+			if !math.IsNaN(v) {
+				record[i+1] = formatFloat(v)
+			}
+		}
+		cw.Write(record)
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteJSON writes ts to w as a JSON array with an object for each row,
+// mapping "time" to the time in seconds and the name of each series to its
+// value, or null if it's unknown.
+func (ts *TimeSeries) WriteJSON(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString("[")
+	for r, t := range ts.Times {
+		if r > 0 {
+			bw.WriteString(",")
+		}
+		bw.WriteString("\n\t{\"time\": ")
+		bw.WriteString(formatFloat(t.Seconds()))
+		for i, v := range ts.Values[r] {
+			name, _ := json.Marshal(ts.Names[i])
+			bw.WriteString(", ")
+			bw.Write(name)
+			bw.WriteString(": ")
+			if math.IsNaN(v) {
+				bw.WriteString("null")
+			} else {
+				bw.WriteString(formatFloat(v))
+			}
+		}
+		bw.WriteString("}")
+	}
+	if len(ts.Times) > 0 {
+		bw.WriteString("\n")
+	}
+	bw.WriteString("]\n")
+	return bw.Flush()
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
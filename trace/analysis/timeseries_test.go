@@ -0,0 +1,175 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.21
+
+package analysis_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+
+	"golang.org/x/exp/trace"
+	"golang.org/x/exp/trace/analysis"
+	"golang.org/x/exp/trace/internal/event/go122"
+	testgen "golang.org/x/exp/trace/internal/testgen/go122"
+	"golang.org/x/exp/trace/tracetest"
+)
+
+func TestReadTimeSeries(t *testing.T) {
+	tt := testgen.NewTrace()
+	tt.ExpectSuccess()
+	g1 := tt.Generation(1)
+	b := g1.Batch(trace.ThreadID(0), 0)
+	b.Event("ProcStatus", trace.ProcID(0), go122.ProcRunning)
+	b.Event("GoStatus", trace.GoID(1), trace.ThreadID(0), go122.GoRunning)
+	b.Event("HeapAlloc", uint64(1000))
+	b.Event("GCBegin", testgen.Seq(1), testgen.NoStack)
+	b.Event("STWBegin", "GC mark termination", testgen.NoStack)
+	b.Event("STWEnd")
+	b.Event("HeapAlloc", uint64(500))
+	b.Event("GCEnd", testgen.Seq(2))
+	b.Event("GoCreate", trace.GoID(2), testgen.NoStack, testgen.NoStack)
+	b.Event("GoDestroy")
+
+	ts, err := analysis.ReadTimeSeries(generatedReader(t, tt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	const heap = "/memory/classes/heap/objects:bytes"
+	wantNames := []string{heap, "gc.active", "gc.cycles", "gc.seconds", "goroutines", "stw.seconds"}
+	if !reflect.DeepEqual(ts.Names, wantNames) {
+		t.Fatalf("got series %q, want %q", ts.Names, wantNames)
+	}
+
+	// Each event is one tick after the last, and a tick is 64ns. The
+	// values change at every event, apart from the STW beginning.
+	const tick = 64 * time.Nanosecond
+	nan := math.NaN()
+	s := tick.Seconds()
+	want := [][]float64{
+		{nan, 0, 0, 0, 0, 0},
+		{nan, 0, 0, 0, 1, 0},
+		{1000, 0, 0, 0, 1, 0},
+		{1000, 1, 1, 0, 1, 0},
+		{1000, 1, 1, 0, 1, s},
+		{500, 1, 1, 0, 1, s},
+		{500, 0, 1, 4 * s, 1, s},
+		{500, 0, 1, 4 * s, 2, s},
+		{500, 0, 1, 4 * s, 1, s},
+	}
+	wantTimes := []time.Duration{0, tick, 2 * tick, 3 * tick, 5 * tick, 6 * tick, 7 * tick, 8 * tick, 9 * tick}
+	if !slices.Equal(ts.Times, wantTimes) {
+		t.Fatalf("got times %v, want %v", ts.Times, wantTimes)
+	}
+	for i, row := range ts.Values {
+		if !equalRows(row, want[i]) {
+			t.Errorf("at %v: got %v, want %v", ts.Times[i], row, want[i])
+		}
+	}
+}
+
+func TestReadTimeSeriesGenerations(t *testing.T) {
+	// A GC cycle in progress across a generation boundary.
+	tt := tracetest.NewTrace()
+	b := tt.Generation().Batch(1)
+	b.ProcStatus(1000, 0, trace.ProcRunning)
+	b.GoStatus(1000, 1, trace.GoRunning)
+	b.GCBegin(2000, nil)
+	b = tt.Generation().Batch(1)
+	b.ProcStatus(10000, 0, trace.ProcRunning)
+	b.GoStatus(10000, 1, trace.GoRunning)
+	b.GCActive(10000)
+	b.GCEnd(11000)
+
+	r, err := trace.NewReader(bytes.NewReader(tt.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts, err := analysis.ReadTimeSeries(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The mark phase is timed from GCBegin in the first generation, not
+	// from where the second generation finds it in progress, and it's
+	// counted once.
+	last := ts.Values[len(ts.Values)-1]
+	col := func(name string) float64 { return last[slices.Index(ts.Names, name)] }
+	if got, want := col("gc.seconds"), (9000 * time.Nanosecond).Seconds(); got != want {
+		t.Errorf("got gc.seconds %v, want %v", got, want)
+	}
+	if got := col("gc.cycles"); got != 1 {
+		t.Errorf("got gc.cycles %v, want 1", got)
+	}
+}
+
+func equalRows(x, y []float64) bool {
+	return slices.EqualFunc(x, y, func(a, b float64) bool {
+		return a == b || math.IsNaN(a) && math.IsNaN(b)
+	})
+}
+
+func testTimeSeries() *analysis.TimeSeries {
+	nan := math.NaN()
+	return &analysis.TimeSeries{
+		Names:  []string{"a", "b"},
+		Times:  []time.Duration{0, 1500 * time.Millisecond, 2 * time.Second, 4100 * time.Millisecond},
+		Values: [][]float64{{nan, 1}, {10, 1}, {20, 2}, {30, 3}},
+	}
+}
+
+func TestResample(t *testing.T) {
+	ts := testTimeSeries().Resample(time.Second)
+	nan := math.NaN()
+	want := [][]float64{{nan, 1}, {nan, 1}, {20, 2}, {20, 2}, {20, 2}}
+	if wantTimes := []time.Duration{0, time.Second, 2 * time.Second, 3 * time.Second, 4 * time.Second}; !slices.Equal(ts.Times, wantTimes) {
+		t.Fatalf("got times %v, want %v", ts.Times, wantTimes)
+	}
+	for i, row := range ts.Values {
+		if !equalRows(row, want[i]) {
+			t.Errorf("at %v: got %v, want %v", ts.Times[i], row, want[i])
+		}
+	}
+}
+
+func TestTimeSeriesCSV(t *testing.T) {
+	var buf bytes.Buffer
+	if err := testTimeSeries().WriteCSV(&buf); err != nil {
+		t.Fatal(err)
+	}
+	want := "time,a,b\n0,,1\n1.5,10,1\n2,20,2\n4.1,30,3\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got CSV:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestTimeSeriesJSON(t *testing.T) {
+	var buf bytes.Buffer
+	if err := testTimeSeries().WriteJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var got []map[string]*float64
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, buf.Bytes())
+	}
+	if len(got) != 4 {
+		t.Fatalf("got %d rows, want 4:\n%s", len(got), buf.Bytes())
+	}
+	if got[0]["a"] != nil || *got[0]["b"] != 1 || *got[3]["time"] != 4.1 || *got[3]["a"] != 30 {
+		t.Errorf("unexpected JSON:\n%s", buf.Bytes())
+	}
+
+	buf.Reset()
+	if err := (&analysis.TimeSeries{}).WriteJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); got != "[]\n" {
+		t.Errorf("got %q for an empty time series, want []", got)
+	}
+}
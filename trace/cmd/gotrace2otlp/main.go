@@ -0,0 +1,82 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.21
+
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"golang.org/x/exp/trace"
+	"golang.org/x/exp/trace/otlp"
+)
+
+func init() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] [trace]\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "Converts the tasks, regions, and log messages in a trace to OpenTelemetry\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "spans, and writes them to stdout in the OTLP JSON format. Reads the trace\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "from stdin if no file is given.\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "Traces don't record wall-clock time, so by default the last annotation\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "is placed at the trace file's modification time, or at the current time\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "for stdin.\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "\n")
+		flag.PrintDefaults()
+	}
+	log.SetFlags(0)
+}
+
+var (
+	service = flag.String("service", "", "set the service.name of the spans to `name`")
+	start   = flag.String("start", "", "place the first annotation at `time`, in RFC 3339 format")
+)
+
+func main() {
+	flag.Parse()
+	if flag.NArg() > 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	opts := otlp.Options{ServiceName: *service}
+	if *start != "" {
+		t, err := time.Parse(time.RFC3339Nano, *start)
+		if err != nil {
+			log.Fatalf("bad -start: %v", err)
+		}
+		opts.Start = t
+	}
+
+	var in io.Reader = os.Stdin
+	opts.End = time.Now()
+	if flag.NArg() == 1 {
+		f, err := os.Open(flag.Arg(0))
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		fi, err := f.Stat()
+		if err != nil {
+			log.Fatal(err)
+		}
+		in = f
+		opts.End = fi.ModTime()
+	}
+
+	r, err := trace.NewReader(bufio.NewReader(in))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := otlp.Convert(os.Stdout, r, opts); err != nil {
+		log.Fatal(err)
+	}
+}
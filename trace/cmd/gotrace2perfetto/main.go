@@ -0,0 +1,45 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.21
+
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"golang.org/x/exp/trace"
+	"golang.org/x/exp/trace/perfetto"
+)
+
+func init() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "Accepts a trace at stdin, and writes it to stdout in the Chrome\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "Trace Event JSON format, which may be loaded into the Perfetto UI\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "at https://ui.perfetto.dev.\n")
+	}
+	log.SetFlags(0)
+}
+
+func main() {
+	flag.Parse()
+	if flag.NArg() != 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	r, err := trace.NewReader(bufio.NewReader(os.Stdin))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := perfetto.Convert(os.Stdout, r); err != nil {
+		log.Fatal(err)
+	}
+}
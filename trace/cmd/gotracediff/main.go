@@ -0,0 +1,154 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.21
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"text/tabwriter"
+
+	"golang.org/x/exp/trace"
+	"golang.org/x/exp/trace/analysis"
+)
+
+func init() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] old.trace new.trace\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "Compares the region and task latencies, blocking, GC, and goroutine\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "counts of two traces. Differences in medians that aren't statistically\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "significant according to the Mann-Whitney U-test are shown as \"~\".\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "\n")
+		flag.PrintDefaults()
+	}
+	log.SetFlags(0)
+}
+
+var (
+	alpha      = flag.Float64("alpha", 0.05, "consider differences significant if p < `α`")
+	all        = flag.Bool("all", false, "show differences that aren't significant")
+	jsonOutput = flag.Bool("json", false, "write the differences as JSON")
+)
+
+func main() {
+	flag.Parse()
+	if flag.NArg() != 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	old, err := readStats(flag.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	new, err := readStats(flag.Arg(1))
+	if err != nil {
+		log.Fatal(err)
+	}
+	var deltas []analysis.Delta
+	for _, d := range analysis.Diff(old, new) {
+		if *all || d.Significant(*alpha) {
+			deltas = append(deltas, d)
+		}
+	}
+	if *jsonOutput {
+		writeJSON(deltas)
+		return
+	}
+	if len(deltas) == 0 {
+		fmt.Println("no significant differences")
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 3, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "Kind\tName\tOld\tNew\tDelta\n")
+	fmt.Fprintf(w, "-\t-\t-\t-\t-\n")
+	for _, d := range deltas {
+		delta := "~"
+		if d.Significant(*alpha) {
+			if math.IsNaN(d.Change) {
+				delta = "?"
+			} else {
+				delta = fmt.Sprintf("%+.2f%%", d.Change*100)
+			}
+		}
+		if !math.IsNaN(d.P) {
+			delta += fmt.Sprintf(" (p=%.3f n=%d+%d)", d.P, d.Old.N, d.New.N)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", d.Kind, d.Name, distribution(d.Kind, d.Old), distribution(d.Kind, d.New), delta)
+	}
+	if err := w.Flush(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func readStats(name string) (*analysis.Stats, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r, err := trace.NewReader(bufio.NewReader(f))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	s, err := analysis.ComputeStats(r)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return s, nil
+}
+
+func distribution(kind string, d analysis.Distribution) string {
+	switch {
+	case kind == "goroutines" || kind == "gc count":
+		return fmt.Sprint(d.N)
+	case d.N == 0:
+		return "-"
+	}
+	return fmt.Sprintf("%v (n=%d)", d.Median, d.N)
+}
+
+func writeJSON(deltas []analysis.Delta) {
+	type delta struct {
+		Kind   string                `json:"kind"`
+		Name   string                `json:"name,omitempty"`
+		Old    analysis.Distribution `json:"old"`
+		New    analysis.Distribution `json:"new"`
+		Change *float64              `json:"change,omitempty"`
+		P      *float64              `json:"p,omitempty"`
+
+		Significant bool `json:"significant"`
+	}
+	// JSON can't represent NaN, so leave out missing values.
+	orNil := func(f float64) *float64 {
+		if math.IsNaN(f) {
+			return nil
+		}
+		return &f
+	}
+	out := []delta{}
+	for _, d := range deltas {
+		out = append(out, delta{
+			Kind:        d.Kind,
+			Name:        d.Name,
+			Old:         d.Old,
+			New:         d.New,
+			Change:      orNil(d.Change),
+			P:           orNil(d.P),
+			Significant: d.Significant(*alpha),
+		})
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "\t")
+	if err := enc.Encode(out); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,75 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.21
+
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"golang.org/x/exp/trace"
+	"golang.org/x/exp/trace/analysis"
+)
+
+func init() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] [trace]\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "Writes the runtime metrics in a trace, like the heap size and GOMAXPROCS,\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "along with goroutine counts and GC activity, as time series with a row for\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "each time any of them changed. Times are in seconds since the start of the\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "trace. Reads the trace from stdin if no file is given.\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "\n")
+		flag.PrintDefaults()
+	}
+	log.SetFlags(0)
+}
+
+var (
+	format   = flag.String("format", "csv", "write the time series as `csv` or json")
+	interval = flag.Duration("interval", 0, "resample the time series every `d` instead of writing every change")
+)
+
+func main() {
+	flag.Parse()
+	if flag.NArg() > 1 || (*format != "csv" && *format != "json") || *interval < 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var in io.Reader = os.Stdin
+	if flag.NArg() == 1 {
+		f, err := os.Open(flag.Arg(0))
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		in = f
+	}
+	r, err := trace.NewReader(bufio.NewReader(in))
+	if err != nil {
+		log.Fatal(err)
+	}
+	ts, err := analysis.ReadTimeSeries(r)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *interval > 0 {
+		ts = ts.Resample(*interval)
+	}
+	if *format == "json" {
+		err = ts.WriteJSON(os.Stdout)
+	} else {
+		err = ts.WriteCSV(os.Stdout)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,185 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.21
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"golang.org/x/exp/trace"
+	"golang.org/x/exp/trace/query"
+)
+
+func init() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] query [trace]\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "Prints the tasks, regions, or log messages in a trace that match a query,\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "such as\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "\ttask type=http with (region type=db.query duration>50ms)\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "See golang.org/x/exp/trace/query for the syntax. Reads the trace from\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "stdin if no file is given.\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "\n")
+		flag.PrintDefaults()
+	}
+	log.SetFlags(0)
+}
+
+var (
+	stacks     = flag.Bool("stacks", false, "print the stacks at the beginning and end of each span")
+	jsonOutput = flag.Bool("json", false, "write the matching spans as JSON")
+)
+
+func main() {
+	flag.Parse()
+	if flag.NArg() != 1 && flag.NArg() != 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	q, err := query.Parse(flag.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	var in io.Reader = os.Stdin
+	if flag.NArg() == 2 {
+		f, err := os.Open(flag.Arg(1))
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		in = f
+	}
+	r, err := trace.NewReader(bufio.NewReader(in))
+	if err != nil {
+		log.Fatal(err)
+	}
+	spans, err := query.ReadSpans(r)
+	if err != nil {
+		log.Fatal(err)
+	}
+	matches := q.Select(spans)
+	if *jsonOutput {
+		writeJSON(matches)
+		return
+	}
+	if *stacks {
+		for _, s := range matches {
+			fmt.Printf("%s %s task=%d G%d start=%d duration=%s\n", s.Kind, name(s), s.Task, s.Goroutine, s.Start, duration(s))
+			printStack("begin", s.StartStack)
+			printStack("end", s.EndStack)
+		}
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 3, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "Kind\tName\tTask\tGoroutine\tStart\tDuration\n")
+	fmt.Fprintf(w, "-\t-\t-\t-\t-\t-\n")
+	for _, s := range matches {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\t%s\n", s.Kind, name(s), s.Task, s.Goroutine, s.Start, duration(s))
+	}
+	if err := w.Flush(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// name returns the type of a task or region, or the category and message
+// of a log message.
+func name(s *query.Span) string {
+	if s.Kind == query.KindLog {
+		return fmt.Sprintf("%s: %q", s.Type, s.Message)
+	}
+	return s.Type
+}
+
+func duration(s *query.Span) string {
+	if s.Kind == query.KindLog {
+		return "-"
+	}
+	d, ok := s.Duration()
+	if !ok {
+		return "?"
+	}
+	return d.String()
+}
+
+func printStack(which string, stk trace.Stack) {
+	first := true
+	stk.Frames(func(f trace.StackFrame) bool {
+		if first {
+			fmt.Printf("  %s:\n", which)
+			first = false
+		}
+		fmt.Printf("    %s\n      %s:%d\n", f.Func, f.File, f.Line)
+		return true
+	})
+}
+
+func writeJSON(spans []*query.Span) {
+	type frame struct {
+		Func string `json:"func"`
+		File string `json:"file"`
+		Line uint64 `json:"line"`
+	}
+	type span struct {
+		Kind       string         `json:"kind"`
+		Type       string         `json:"type"`
+		Message    string         `json:"message,omitempty"`
+		Task       trace.TaskID   `json:"task"`
+		Goroutine  trace.GoID     `json:"goroutine"`
+		Start      *trace.Time    `json:"start,omitempty"`
+		End        *trace.Time    `json:"end,omitempty"`
+		Duration   *time.Duration `json:"duration,omitempty"`
+		StartStack []frame        `json:"startStack,omitempty"`
+		EndStack   []frame        `json:"endStack,omitempty"`
+	}
+	frames := func(stk trace.Stack) []frame {
+		var fs []frame
+		stk.Frames(func(f trace.StackFrame) bool {
+			fs = append(fs, frame{f.Func, f.File, f.Line})
+			return true
+		})
+		return fs
+	}
+	// Leave out times outside the trace.
+	orNil := func(t trace.Time) *trace.Time {
+		if t == 0 {
+			return nil
+		}
+		return &t
+	}
+	out := []span{}
+	for _, s := range spans {
+		o := span{
+			Kind:       s.Kind.String(),
+			Type:       s.Type,
+			Message:    s.Message,
+			Task:       s.Task,
+			Goroutine:  s.Goroutine,
+			Start:      orNil(s.Start),
+			End:        orNil(s.End),
+			StartStack: frames(s.StartStack),
+			EndStack:   frames(s.EndStack),
+		}
+		if d, ok := s.Duration(); ok {
+			o.Duration = &d
+		}
+		out = append(out, o)
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "\t")
+	if err := enc.Encode(out); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,263 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.21
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"golang.org/x/exp/trace"
+	"golang.org/x/exp/trace/internal/testtrace"
+)
+
+func init() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] [trace]\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "Accepts a trace at stdin, or in the named file, and validates it like\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "gotracevalidate. Rather than stop at the first problem, it continues\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "past problems where it can, skipping generations that can't be read,\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "and lists them all.\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "\n")
+		flag.PrintDefaults()
+	}
+	log.SetFlags(0)
+}
+
+var (
+	logEvents = flag.Bool("log-events", false, "whether to log events")
+	jsonOut   = flag.Bool("json", false, "print the report as JSON")
+)
+
+func main() {
+	flag.Parse()
+
+	var (
+		in   io.ReaderAt
+		size int64
+	)
+	switch flag.NArg() {
+	case 0:
+		// Resuming after a problem needs random access to the trace.
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			log.Fatal(err)
+		}
+		in, size = bytes.NewReader(data), int64(len(data))
+	case 1:
+		f, err := os.Open(flag.Arg(0))
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		fi, err := f.Stat()
+		if err != nil {
+			log.Fatal(err)
+		}
+		in, size = f, fi.Size()
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	rep, err := validateAll(in, size)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *jsonOut {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "\t")
+		err = enc.Encode(rep)
+	} else {
+		err = rep.writeText(os.Stdout)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(rep.Problems) != 0 {
+		os.Exit(1)
+	}
+}
+
+// Report is the result of validating a whole trace.
+type Report struct {
+	Events      int       `json:"events"`      // events read successfully
+	Generations int       `json:"generations"` // generations found in the trace
+	Skipped     []uint64  `json:"skipped"`     // generations that couldn't be read in full
+	Problems    []Problem `json:"problems"`
+}
+
+// Problem is a single problem found in a trace.
+type Problem struct {
+	// Source is "reader" for problems that stop the trace being read,
+	// such as events that can't be ordered, and "validator" for
+	// inconsistencies between events that were read.
+	Source string `json:"source"`
+
+	// Gen and Offset give the generation and the offset in the trace of
+	// the batch containing the event, or -1 if the event has no batch or
+	// the problem isn't with a single event.
+	Gen    uint64 `json:"gen"`
+	Offset int64  `json:"offset"`
+
+	// Thread, Proc, and Goroutine give the event's context, where known.
+	Thread    trace.ThreadID `json:"thread"`
+	Proc      trace.ProcID   `json:"proc"`
+	Goroutine trace.GoID     `json:"goroutine"`
+
+	// Time and Event describe the event, for problems found by the
+	// validator.
+	Time  trace.Time `json:"time,omitempty"`
+	Event string     `json:"event,omitempty"`
+
+	// Invariant describes what's wrong.
+	Invariant string `json:"invariant"`
+}
+
+// validateAll reads and validates the whole trace of the given size from
+// in. When reading a generation fails, it reports the problem and continues
+// from the next generation it can find.
+func validateAll(in io.ReaderAt, size int64) (*Report, error) {
+	rep := &Report{Skipped: []uint64{}, Problems: []Problem{}}
+
+	// The index tells us where to resume after a generation that can't be
+	// read. If the trace can't be indexed at all, it's still worth reading
+	// as far as possible, but there's nowhere to resume.
+	var idx *trace.Index
+	ir, idxErr := trace.NewIndexedReader(in, size, nil)
+	if ir != nil {
+		idx = ir.Index()
+		rep.Generations = len(idx.Generations)
+	}
+
+	r, err := trace.NewReaderWithOptions(io.NewSectionReader(in, 0, size), trace.ReaderOptions{})
+	if err != nil {
+		return nil, err
+	}
+	v := testtrace.NewValidator()
+	resumed := -1 // index of the generation we last resumed at
+	for {
+		ev, err := r.ReadEvent()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			pos := r.Position()
+			rep.Problems = append(rep.Problems, Problem{
+				Source:    "reader",
+				Gen:       pos.Gen,
+				Offset:    pos.Offset,
+				Thread:    pos.Thread,
+				Proc:      trace.NoProc,
+				Goroutine: trace.NoGoroutine,
+				Invariant: err.Error(),
+			})
+			if pos.Gen != 0 {
+				rep.Skipped = append(rep.Skipped, pos.Gen)
+			}
+			i := resumeAt(idx, pos, resumed+1)
+			if i < 0 {
+				break
+			}
+			resumed = i
+			if r, err = ir.SeekGenerationWithOptions(i, trace.ReaderOptions{}); err != nil {
+				return nil, err
+			}
+			// State carried over from before the skipped generation
+			// would only produce spurious problems.
+			v = testtrace.NewValidator()
+			continue
+		}
+		rep.Events++
+		if *logEvents {
+			log.Println(ev.String())
+		}
+		if err := v.Event(ev); err != nil {
+			pos := r.Position()
+			event, _, _ := strings.Cut(ev.String(), "\n")
+			for _, err := range unjoin(err) {
+				rep.Problems = append(rep.Problems, Problem{
+					Source:    "validator",
+					Gen:       pos.Gen,
+					Offset:    pos.Offset,
+					Thread:    ev.Thread(),
+					Proc:      ev.Proc(),
+					Goroutine: ev.Goroutine(),
+					Time:      ev.Time(),
+					Event:     event,
+					Invariant: err.Error(),
+				})
+			}
+		}
+	}
+	if idxErr != nil && len(rep.Problems) == 0 {
+		// The reader and the index should agree about damage, but make
+		// sure it isn't lost if they don't.
+		rep.Problems = append(rep.Problems, Problem{
+			Source:    "reader",
+			Offset:    -1,
+			Thread:    trace.NoThread,
+			Proc:      trace.NoProc,
+			Goroutine: trace.NoGoroutine,
+			Invariant: idxErr.Error(),
+		})
+	}
+	return rep, nil
+}
+
+// resumeAt returns the index of the first generation in idx after the
+// problem at pos, starting the search at index min so that reading always
+// makes progress. It returns -1 if there's no such generation.
+func resumeAt(idx *trace.Index, pos trace.Position, min int) int {
+	if idx == nil {
+		return -1
+	}
+	for i := min; i < len(idx.Generations); i++ {
+		g := idx.Generations[i]
+		if pos.Gen != 0 && g.Gen > pos.Gen || pos.Gen == 0 && g.Offset > pos.Offset {
+			return i
+		}
+	}
+	return -1
+}
+
+// unjoin returns the errors joined together in err.
+func unjoin(err error) []error {
+	if j, ok := err.(interface{ Unwrap() []error }); ok {
+		return j.Unwrap()
+	}
+	return []error{err}
+}
+
+func (rep *Report) writeText(w io.Writer) error {
+	var buf bytes.Buffer
+	for _, p := range rep.Problems {
+		fmt.Fprintf(&buf, "%s: gen %d offset %d M=%d P=%d G=%d", p.Source, p.Gen, p.Offset, p.Thread, p.Proc, p.Goroutine)
+		if p.Event != "" {
+			fmt.Fprintf(&buf, " T=%d", p.Time)
+		}
+		// Some problems come with a multi-line dump of the reader's state.
+		inv := strings.TrimRight(p.Invariant, "\n")
+		fmt.Fprintf(&buf, ": %s\n", strings.ReplaceAll(inv, "\n", "\n\t"))
+		if p.Event != "" {
+			fmt.Fprintf(&buf, "\tevent: %s\n", p.Event)
+		}
+	}
+	fmt.Fprintf(&buf, "%d problems, %d events read", len(rep.Problems), rep.Events)
+	if len(rep.Skipped) != 0 {
+		fmt.Fprintf(&buf, ", skipped generations %v of %d", rep.Skipped, rep.Generations)
+	}
+	fmt.Fprintf(&buf, "\n")
+	_, err := w.Write(buf.Bytes())
+	return err
+}
@@ -0,0 +1,63 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.21
+
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/exp/trace"
+)
+
+func init() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags]\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "Accepts a trace at stdin, and writes the part of it selected by\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "the flags to stdout. Times are relative to the start of the trace.\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "\n")
+		flag.PrintDefaults()
+	}
+	log.SetFlags(0)
+}
+
+var (
+	from       = flag.Duration("from", 0, "start of the time window to extract")
+	to         = flag.Duration("to", 0, "end of the time window to extract (default end of trace)")
+	goroutines = flag.String("goroutines", "", "comma-separated list of goroutine IDs to extract (default all)")
+)
+
+func main() {
+	flag.Parse()
+
+	opts := trace.SliceOptions{Start: *from, End: *to}
+	if *goroutines != "" {
+		for _, s := range strings.Split(*goroutines, ",") {
+			id, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+			if err != nil {
+				log.Fatalf("invalid goroutine ID %q: %v", s, err)
+			}
+			opts.Goroutines = append(opts.Goroutines, trace.GoID(id))
+		}
+	}
+	if opts.End != 0 && opts.End < opts.Start {
+		log.Fatal("end of time window is before its start")
+	}
+
+	w := bufio.NewWriter(os.Stdout)
+	if err := trace.Slice(w, bufio.NewReader(os.Stdin), opts); err != nil {
+		log.Fatal(err)
+	}
+	if err := w.Flush(); err != nil {
+		log.Fatal(err)
+	}
+}
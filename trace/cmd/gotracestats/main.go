@@ -0,0 +1,71 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.21
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+)
+
+func init() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] [mode]\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "Accepts a trace at stdin.\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "Supported modes:")
+		fmt.Fprintf(flag.CommandLine.Output(), "\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "* goroutines - dumps goroutine counts and lifetimes by entry function\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "* blocking   - dumps the stacks goroutines spent the most time blocked on\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "* gc         - dumps GC cycle and stop-the-world pause durations\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "* tasks      - dumps task latency histograms by task type\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "* metrics    - dumps the time series of each metric, like the heap goal\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "For the sizes of the events in the trace, see gotraceeventstats.\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "\n")
+		flag.PrintDefaults()
+	}
+	log.SetFlags(0)
+}
+
+var (
+	jsonOutput = flag.Bool("json", false, "write the stats as JSON")
+	top        = flag.Int("n", 10, "number of stacks to show in blocking mode")
+)
+
+func main() {
+	log.SetPrefix("")
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		log.Print("missing mode argument")
+		flag.Usage()
+		os.Exit(1)
+	}
+	var err error
+	switch mode := flag.Arg(0); mode {
+	case "goroutines":
+		err = printGoroutineStats(os.Stdin)
+	case "blocking":
+		err = printBlockingStats(os.Stdin)
+	case "gc":
+		err = printGCStats(os.Stdin)
+	case "tasks":
+		err = printTaskStats(os.Stdin)
+	case "metrics":
+		err = printMetricStats(os.Stdin)
+	default:
+		log.Printf("unknown mode %q", mode)
+		flag.Usage()
+		os.Exit(1)
+	}
+	if err != nil {
+		log.Fatalf("error: %v", err)
+	}
+}
@@ -0,0 +1,364 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.21
+
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"golang.org/x/exp/trace"
+	"golang.org/x/exp/trace/analysis"
+)
+
+// readEvents calls f for every event in the trace read from r.
+func readEvents(r io.Reader, f func(ev *trace.Event)) error {
+	tr, err := trace.NewReader(bufio.NewReader(r))
+	if err != nil {
+		return err
+	}
+	for {
+		ev, err := tr.ReadEvent()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		f(&ev)
+	}
+}
+
+// computeStats computes the analysis.Stats of the trace read from r.
+func computeStats(r io.Reader) (*analysis.Stats, error) {
+	tr, err := trace.NewReader(bufio.NewReader(r))
+	if err != nil {
+		return nil, err
+	}
+	return analysis.ComputeStats(tr)
+}
+
+func writeJSON(v any) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "\t")
+	return enc.Encode(v)
+}
+
+// durationStats summarizes a set of durations. Durations are in
+// nanoseconds in JSON output.
+type durationStats struct {
+	Count int           `json:"count"`
+	Total time.Duration `json:"total"`
+	Min   time.Duration `json:"min"`
+	Mean  time.Duration `json:"mean"`
+	P50   time.Duration `json:"p50"`
+	P90   time.Duration `json:"p90"`
+	P99   time.Duration `json:"p99"`
+	Max   time.Duration `json:"max"`
+}
+
+func computeDurationStats(ds []time.Duration) durationStats {
+	if len(ds) == 0 {
+		return durationStats{}
+	}
+	ds = slices.Clone(ds)
+	slices.Sort(ds)
+	s := durationStats{Count: len(ds), Min: ds[0], Max: ds[len(ds)-1]}
+	for _, d := range ds {
+		s.Total += d
+	}
+	s.Mean = s.Total / time.Duration(len(ds))
+	quantile := func(q float64) time.Duration {
+		return ds[int(q*float64(len(ds)-1))]
+	}
+	s.P50, s.P90, s.P99 = quantile(0.5), quantile(0.9), quantile(0.99)
+	return s
+}
+
+func printGoroutineStats(r io.Reader) error {
+	s := analysis.NewSummarizer()
+	if err := readEvents(r, s.Event); err != nil {
+		return err
+	}
+	sum := s.Finalize()
+
+	type goroutineGroup struct {
+		Name      string        `json:"name"`
+		Count     int           `json:"count"`
+		Created   int           `json:"created"`
+		Exited    int           `json:"exited"`
+		Lifetimes durationStats `json:"lifetimes"`
+		Running   time.Duration `json:"running"`
+		Runnable  time.Duration `json:"runnable"`
+		Syscall   time.Duration `json:"syscall"`
+		Blocked   time.Duration `json:"blocked"`
+
+		lifetimes []time.Duration
+	}
+	groups := make(map[string]*goroutineGroup)
+	for _, g := range sum.Goroutines {
+		name := g.Name
+		if name == "" {
+			name = "(unknown)"
+		}
+		grp, ok := groups[name]
+		if !ok {
+			grp = &goroutineGroup{Name: name}
+			groups[name] = grp
+		}
+		grp.Count++
+		if g.CreationTime != 0 {
+			grp.Created++
+		}
+		if g.EndTime != 0 {
+			grp.Exited++
+			if g.CreationTime != 0 {
+				grp.lifetimes = append(grp.lifetimes, g.EndTime.Sub(g.CreationTime))
+			}
+		}
+		grp.Running += g.Running
+		grp.Runnable += g.Runnable
+		grp.Syscall += g.Syscall
+		grp.Blocked += g.TotalBlocked()
+	}
+	var out []*goroutineGroup
+	for _, grp := range groups {
+		grp.Lifetimes = computeDurationStats(grp.lifetimes)
+		out = append(out, grp)
+	}
+	slices.SortFunc(out, func(a, b *goroutineGroup) int {
+		if c := cmp.Compare(b.Count, a.Count); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.Name, b.Name)
+	})
+	if *jsonOutput {
+		return writeJSON(out)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 3, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "Function\tCount\tCreated\tExited\tMean lifetime\tMax lifetime\tRunning\tRunnable\tSyscall\tBlocked\n")
+	fmt.Fprintf(w, "-\t-\t-\t-\t-\t-\t-\t-\t-\t-\n")
+	for _, grp := range out {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%v\t%v\t%v\t%v\t%v\t%v\n", grp.Name, grp.Count, grp.Created, grp.Exited,
+			grp.Lifetimes.Mean, grp.Lifetimes.Max, grp.Running, grp.Runnable, grp.Syscall, grp.Blocked)
+	}
+	fmt.Fprintf(w, "Total\t%d\n", len(sum.Goroutines))
+	return w.Flush()
+}
+
+func printBlockingStats(r io.Reader) error {
+	stats, err := computeStats(r)
+	if err != nil {
+		return err
+	}
+	type blockingStack struct {
+		Reason string        `json:"reason"`
+		Stack  []string      `json:"stack"`
+		Count  int           `json:"count"`
+		Total  time.Duration `json:"total"`
+	}
+	var out []*blockingStack
+	for key, ds := range stats.Blocking {
+		reason, stack, _ := strings.Cut(key, ": ")
+		s := &blockingStack{Reason: reason, Count: len(ds)}
+		if stack != "" {
+			s.Stack = strings.Split(stack, " < ")
+		}
+		for _, d := range ds {
+			s.Total += d
+		}
+		out = append(out, s)
+	}
+	slices.SortFunc(out, func(a, b *blockingStack) int {
+		if c := cmp.Compare(b.Total, a.Total); c != 0 {
+			return c
+		}
+		if c := cmp.Compare(a.Reason, b.Reason); c != 0 {
+			return c
+		}
+		return slices.Compare(a.Stack, b.Stack)
+	})
+	if len(out) > *top {
+		out = out[:*top]
+	}
+	if *jsonOutput {
+		return writeJSON(out)
+	}
+	for _, s := range out {
+		fmt.Printf("%v blocked in %d waits on %s\n", s.Total, s.Count, s.Reason)
+		for _, f := range s.Stack {
+			fmt.Printf("\t%s\n", f)
+		}
+		if len(s.Stack) == 0 {
+			fmt.Printf("\t(no stack)\n")
+		}
+		fmt.Println()
+	}
+	return nil
+}
+
+func printGCStats(r io.Reader) error {
+	stats, err := computeStats(r)
+	if err != nil {
+		return err
+	}
+	type stwStats struct {
+		Reason string `json:"reason"`
+		durationStats
+	}
+	out := struct {
+		GC   durationStats `json:"gc"`
+		STW  durationStats `json:"stw"`
+		STWs []stwStats    `json:"stw_by_reason"`
+	}{
+		GC:  computeDurationStats(stats.GCs),
+		STW: computeDurationStats(stats.AllPauses()),
+	}
+	for name, ds := range stats.Pauses {
+		out.STWs = append(out.STWs, stwStats{name, computeDurationStats(ds)})
+	}
+	slices.SortFunc(out.STWs, func(a, b stwStats) int {
+		if c := cmp.Compare(b.Total, a.Total); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.Reason, b.Reason)
+	})
+	if *jsonOutput {
+		return writeJSON(out)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 3, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "Phase\tCount\tTotal\tMin\tMean\tP50\tP90\tP99\tMax\n")
+	fmt.Fprintf(w, "-\t-\t-\t-\t-\t-\t-\t-\t-\n")
+	printDurationStats(w, "GC concurrent mark phase", out.GC)
+	printDurationStats(w, "stop-the-world (all)", out.STW)
+	for _, s := range out.STWs {
+		printDurationStats(w, s.Reason, s.durationStats)
+	}
+	return w.Flush()
+}
+
+func printDurationStats(w io.Writer, name string, s durationStats) {
+	fmt.Fprintf(w, "%s\t%d\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n", name, s.Count, s.Total, s.Min, s.Mean, s.P50, s.P90, s.P99, s.Max)
+}
+
+// histogramBuckets are the upper bounds of the buckets of task latency histograms.
+var histogramBuckets = []time.Duration{
+	time.Microsecond,
+	10 * time.Microsecond,
+	100 * time.Microsecond,
+	time.Millisecond,
+	10 * time.Millisecond,
+	100 * time.Millisecond,
+	time.Second,
+	10 * time.Second,
+}
+
+func printTaskStats(r io.Reader) error {
+	stats, err := computeStats(r)
+	if err != nil {
+		return err
+	}
+	type bucket struct {
+		// UpperBound is the bucket's exclusive upper bound, or zero
+		// for the last bucket, which is unbounded.
+		UpperBound time.Duration `json:"upper_bound"`
+		Count      int           `json:"count"`
+	}
+	type taskStats struct {
+		Type      string        `json:"type"`
+		Latencies durationStats `json:"latencies"`
+		Histogram []bucket      `json:"histogram"`
+	}
+	var out []taskStats
+	for typ, ds := range stats.Tasks {
+		s := taskStats{Type: typ, Latencies: computeDurationStats(ds)}
+		for _, ub := range histogramBuckets {
+			s.Histogram = append(s.Histogram, bucket{UpperBound: ub})
+		}
+		s.Histogram = append(s.Histogram, bucket{})
+		for _, d := range ds {
+			i, _ := slices.BinarySearch(histogramBuckets, d+1)
+			s.Histogram[i].Count++
+		}
+		out = append(out, s)
+	}
+	slices.SortFunc(out, func(a, b taskStats) int {
+		return cmp.Compare(a.Type, b.Type)
+	})
+	if *jsonOutput {
+		return writeJSON(out)
+	}
+	for _, s := range out {
+		l := s.Latencies
+		fmt.Printf("%s: count %d, min %v, mean %v, p50 %v, p90 %v, p99 %v, max %v\n",
+			s.Type, l.Count, l.Min, l.Mean, l.P50, l.P90, l.P99, l.Max)
+		w := tabwriter.NewWriter(os.Stdout, 3, 8, 2, ' ', 0)
+		for _, b := range s.Histogram {
+			label := "+Inf"
+			if b.UpperBound != 0 {
+				label = "< " + b.UpperBound.String()
+			}
+			bar := strings.Repeat("*", (b.Count*40+l.Count-1)/l.Count)
+			fmt.Fprintf(w, "\t%s\t%d\t%s\n", label, b.Count, bar)
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		fmt.Println()
+	}
+	return nil
+}
+
+func printMetricStats(r io.Reader) error {
+	type point struct {
+		// Time is the time of the sample, relative to the start of the trace.
+		Time  time.Duration `json:"time"`
+		Value uint64        `json:"value"`
+	}
+	var names []string
+	series := make(map[string][]point)
+	var start trace.Time
+	err := readEvents(r, func(ev *trace.Event) {
+		if start == 0 && ev.Kind() != trace.EventSync {
+			start = ev.Time()
+		}
+		if ev.Kind() != trace.EventMetric {
+			return
+		}
+		m := ev.Metric()
+		if m.Value.Kind() != trace.ValueUint64 {
+			return
+		}
+		if _, ok := series[m.Name]; !ok {
+			names = append(names, m.Name)
+		}
+		series[m.Name] = append(series[m.Name], point{ev.Time().Sub(start), m.Value.Uint64()})
+	})
+	if err != nil {
+		return err
+	}
+	slices.Sort(names)
+	if *jsonOutput {
+		return writeJSON(series)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 3, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "Metric\tTime\tValue\n")
+	fmt.Fprintf(w, "-\t-\t-\n")
+	for _, name := range names {
+		for _, p := range series[name] {
+			fmt.Fprintf(w, "%s\t%v\t%d\n", name, p.Time, p.Value)
+		}
+	}
+	return w.Flush()
+}
@@ -0,0 +1,317 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.21
+
+package trace
+
+import (
+	"bufio"
+	"cmp"
+	"fmt"
+	"io"
+	"slices"
+)
+
+// This file reads and decodes generations for a ReaderWithOptions. Unlike
+// readGeneration, it keeps track of where each batch is in the trace, and
+// splits reading the batches of a generation out of the trace from decoding
+// them, so that generations can be decoded concurrently.
+
+// traceReader is a buffered reader of a trace that keeps track of its offset
+// in the trace.
+type traceReader struct {
+	*bufio.Reader
+	n *countingReader
+}
+
+// newTraceReader returns a traceReader for r, whose first byte is at offset
+// base in the trace.
+func newTraceReader(r io.Reader, base int64) *traceReader {
+	n := &countingReader{r: r, n: base}
+	return &traceReader{Reader: bufio.NewReader(n), n: n}
+}
+
+// offset returns the offset in the trace of the next byte r returns.
+func (r *traceReader) offset() int64 {
+	return r.n.n - int64(r.Buffered())
+}
+
+// countingReader counts the bytes read from r.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// traceError is an error caused by the trace data at pos.
+type traceError struct {
+	pos Position
+	err error
+}
+
+func (e *traceError) Error() string { return e.err.Error() }
+func (e *traceError) Unwrap() error { return e.err }
+
+// rawBatch is a batch read out of a trace, along with its generation and
+// where it is in the trace.
+type rawBatch struct {
+	batch
+	gen          uint64
+	offset, size int64
+}
+
+// generationReader reads the batches of a trace one generation at a time.
+type generationReader struct {
+	r        *traceReader
+	tolerant bool
+	spill    *rawBatch // first batch of the next generation
+	err      error     // error to return once the generation before it is read
+}
+
+// next returns the batches of the next generation in the order they appear
+// in the trace, along with what a tolerant generationReader skipped on the
+// way to them. At the end of the trace, it returns io.EOF.
+func (gr *generationReader) next() ([]rawBatch, []Skipped, error) {
+	if gr.err != nil {
+		return nil, nil, gr.err
+	}
+	var (
+		batches       []rawBatch
+		next          *rawBatch
+		skipped       []Skipped
+		spillErr, err error
+	)
+	if gr.tolerant {
+		batches, next, skipped = recoverGenerationBatches(gr.r, gr.spill)
+		if len(batches) == 0 {
+			// Stay at the end of the trace, rather than try to read
+			// past the damage.
+			err = io.EOF
+		}
+	} else {
+		batches, next, spillErr, err = readGenerationBatches(gr.r, gr.spill)
+	}
+	gr.spill = next
+	switch {
+	case err != nil:
+		gr.err = err
+		return nil, skipped, err
+	case spillErr != nil:
+		gr.err = spillErr
+	case next == nil:
+		gr.err = io.EOF
+	}
+	return batches, skipped, nil
+}
+
+// decodeNext reads and decodes the next generation.
+func (gr *generationReader) decodeNext() decodedGeneration {
+	batches, skipped, err := gr.next()
+	return gr.decode(batches, skipped, err)
+}
+
+// decode decodes the results of next. It depends on no state that next
+// changes, so it may be called concurrently with it.
+func (gr *generationReader) decode(batches []rawBatch, skipped []Skipped, err error) decodedGeneration {
+	if err != nil {
+		return decodedGeneration{skipped: skipped, err: err}
+	}
+	d := decodeGeneration(batches, gr.tolerant)
+	d.skipped = append(skipped, d.skipped...)
+	return d
+}
+
+// readGenerationBatches reads the batches of a trace generation out of r,
+// without decoding them, the way readGeneration does. spill is the first
+// batch of the generation, if it was read along with the last one. Returns
+// the generation's batches in the order they appear in the trace, and the
+// first batch of the next generation, if any.
+//
+// If spillErr is non-nil, it's an error reading the next generation, which
+// must be handled after processing this one. Errors are *traceErrors.
+func readGenerationBatches(r *traceReader, spill *rawBatch) (batches []rawBatch, next *rawBatch, spillErr, err error) {
+	var gen uint64
+	if spill != nil {
+		gen = spill.gen
+		batches = append(batches, *spill)
+	}
+	// Read batches one at a time until we either hit EOF or
+	// the next generation.
+	for {
+		off := r.offset()
+		b, bgen, err := readBatch(r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			err = &traceError{pos: Position{Gen: bgen, Offset: off, Thread: NoThread}, err: err}
+			if gen != 0 {
+				// This is an error reading the first batch of the next
+				// generation, or of a truncated trace. Forge ahead
+				// assuming that what we've got so far is fine.
+				spillErr = err
+				break
+			}
+			return nil, nil, nil, err
+		}
+		if bgen == 0 {
+			// 0 is a sentinel used by the runtime, so we'll never see it.
+			return nil, nil, nil, &traceError{pos: Position{Offset: off, Thread: NoThread}, err: fmt.Errorf("invalid generation number %d", bgen)}
+		}
+		rb := rawBatch{batch: b, gen: bgen, offset: off, size: r.offset() - off}
+		if gen == 0 {
+			gen = bgen
+		}
+		if bgen == gen+1 {
+			next = &rb
+			break
+		}
+		if bgen != gen {
+			// See readGeneration for why this fails as fast as possible.
+			// The batches read so far say where the damage starts.
+			return batches, nil, nil, &traceError{pos: Position{Gen: bgen, Offset: off, Thread: NoThread}, err: fmt.Errorf("generations out of order")}
+		}
+		batches = append(batches, rb)
+	}
+	return batches, next, spillErr, nil
+}
+
+// recoverGenerationBatches is readGenerationBatches for a tolerant
+// generationReader. Rather than fail, it assumes that data it can't read is
+// the end of a truncated trace, and skips the generation it was reading
+// unless the damage starts with the next generation. It returns no batches
+// at the end of the trace.
+func recoverGenerationBatches(r *traceReader, spill *rawBatch) (batches []rawBatch, next *rawBatch, skipped []Skipped) {
+	batches, next, spillErr, err := readGenerationBatches(r, spill)
+	if err == nil {
+		err = spillErr
+	}
+	if err == nil {
+		return batches, next, nil
+	}
+	pos := err.(*traceError).pos
+	var gen uint64
+	if len(batches) != 0 {
+		gen = batches[0].gen
+	}
+	if spillErr != nil && pos.Gen == gen+1 {
+		// Only the next generation is damaged.
+		return batches, nil, []Skipped{{Gen: pos.Gen, Offset: pos.Offset, Length: -1, Err: err}}
+	}
+	off := pos.Offset
+	if len(batches) != 0 {
+		off = batches[0].offset
+	}
+	return nil, nil, []Skipped{{Gen: gen, Offset: off, Length: -1, Err: err}}
+}
+
+// decodedGeneration is the result of decoding a generation. At most one of
+// gen and err is set. A tolerant decode sets neither if it skipped the
+// whole generation.
+type decodedGeneration struct {
+	gen *generation
+
+	// offsets are the offsets in the trace of the generation's event
+	// batches, by thread, in the same order as gen.batches.
+	offsets map[ThreadID][]int64
+
+	skipped []Skipped
+	err     error
+}
+
+// decodeGeneration decodes the structural elements of a generation out of
+// its batches. It depends on no other state, so generations may be decoded
+// concurrently.
+//
+// If tolerant is set, decodeGeneration skips batches that can't be decoded,
+// and if the generation still can't be decoded, skips all of it. Either way,
+// it describes what it skipped and never returns an error.
+func decodeGeneration(batches []rawBatch, tolerant bool) decodedGeneration {
+	d := decodeBatches(batches, tolerant)
+	if d.err != nil && tolerant {
+		first, last := batches[0], batches[len(batches)-1]
+		return decodedGeneration{skipped: []Skipped{{Gen: first.gen, Offset: first.offset, Length: last.offset + last.size - first.offset, Err: d.err}}}
+	}
+	return d
+}
+
+// decodeBatches does the work of decodeGeneration. If tolerant is set, it
+// skips batches that can't be decoded, but fails if the generation as a
+// whole can't be.
+func decodeBatches(batches []rawBatch, tolerant bool) decodedGeneration {
+	var gen uint64
+	if len(batches) != 0 {
+		gen = batches[0].gen
+	}
+	g := &generation{
+		gen: gen,
+		evTable: &evTable{
+			pcs: make(map[uint64]frame),
+		},
+		batches: make(map[ThreadID][]batch),
+	}
+	d := decodedGeneration{offsets: make(map[ThreadID][]int64)}
+	fail := func(pos Position, err error) decodedGeneration {
+		return decodedGeneration{skipped: d.skipped, err: &traceError{pos: pos, err: err}}
+	}
+	for _, b := range batches {
+		n := len(g.batches[b.m])
+		if err := processBatch(g, b.batch); err != nil {
+			if !tolerant {
+				return fail(Position{Gen: gen, Offset: b.offset, Thread: b.m}, err)
+			}
+			d.skipped = append(d.skipped, Skipped{Gen: gen, Offset: b.offset, Length: b.size, Err: err})
+			continue
+		}
+		if len(g.batches[b.m]) == n {
+			// Not an event batch.
+			continue
+		}
+		if tolerant {
+			// A Reader only finds bad events once it gets to them, which
+			// for a tolerant ReaderWithOptions is too late to leave out the batch.
+			if _, _, _, err := batchTimeRange(b.batch); err != nil {
+				g.batches[b.m] = g.batches[b.m][:n]
+				d.skipped = append(d.skipped, Skipped{Gen: gen, Offset: b.offset, Length: b.size, Err: err})
+				continue
+			}
+		}
+		d.offsets[b.m] = append(d.offsets[b.m], b.offset)
+	}
+
+	// The rest is as for readGeneration.
+	noBatch := noPosition(gen)
+
+	// Check some invariants.
+	if g.freq == 0 {
+		return fail(noBatch, fmt.Errorf("no frequency event found"))
+	}
+
+	// Compactify stacks and strings for better lookup performance later.
+	g.stacks.compactify()
+	g.strings.compactify()
+
+	// Validate stacks.
+	if err := validateStackStrings(&g.stacks, &g.strings, g.pcs); err != nil {
+		return fail(noBatch, err)
+	}
+
+	// Fix up the CPU sample timestamps, now that we have freq.
+	for i := range g.cpuSamples {
+		s := &g.cpuSamples[i]
+		s.time = g.freq.mul(timestamp(s.time))
+	}
+	// Sort the CPU samples.
+	slices.SortFunc(g.cpuSamples, func(a, b cpuSample) int {
+		return cmp.Compare(a.time, b.time)
+	})
+	d.gen = g
+	return d
+}
@@ -806,8 +806,7 @@ func (e Event) String() string {
 			})
 		}
 	case EventExperimental:
-		r := e.Experimental()
-		fmt.Fprintf(&sb, " Name=%s ArgNames=%v Args=%v", r.Name, r.ArgNames, r.Args)
+		fmt.Fprintf(&sb, " %s", e.Experimental())
 	}
 	if stk := e.Stack(); stk != NoStack {
 		fmt.Fprintln(&sb)
@@ -0,0 +1,259 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.21
+
+package trace
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	"golang.org/x/exp/trace/internal/event"
+	"golang.org/x/exp/trace/internal/event/go122"
+)
+
+// go122Experiments are the names of the experiments in the Go 1.22 format,
+// by ID.
+var go122Experiments = [...]string{
+	go122.AllocFree: "AllocFree",
+}
+
+func experimentName(exp event.Experiment) string {
+	if int(exp) < len(go122Experiments) && go122Experiments[exp] != "" {
+		return go122Experiments[exp]
+	}
+	return fmt.Sprintf("Experiment(%d)", exp)
+}
+
+// go122EventExperiments maps the names of the experimental events in the
+// Go 1.22 format to the names of their experiments.
+var go122EventExperiments = sync.OnceValue(func() map[string]string {
+	m := make(map[string]string)
+	for _, spec := range go122.Specs() {
+		if spec.Experiment != event.NoExperiment {
+			m[spec.Name] = experimentName(spec.Experiment)
+		}
+	}
+	return m
+})
+
+// Experiment returns the name of the experiment the event is part of, or
+// "" if the event isn't a known experimental event.
+func (e ExperimentalEvent) Experiment() string {
+	return go122EventExperiments()[e.Name]
+}
+
+// String returns the event's name and its decoded value, if a decoder is
+// registered for it, or its raw arguments if not. Event.String describes
+// experimental events the same way.
+func (e ExperimentalEvent) String() string {
+	v, err := e.Decode()
+	switch {
+	case err == nil:
+		return fmt.Sprintf("Name=%s Experiment=%s Value=%+v", e.Name, e.Experiment(), v)
+	case errors.Is(err, ErrNoDecoder):
+		return fmt.Sprintf("Name=%s ArgNames=%v Args=%v", e.Name, e.ArgNames, e.Args)
+	default:
+		return fmt.Sprintf("Name=%s ArgNames=%v Args=%v DecodeError=%q", e.Name, e.ArgNames, e.Args, err)
+	}
+}
+
+// ExperimentalEventDecoder decodes the arguments of an experimental event
+// into a value that describes the event.
+type ExperimentalEventDecoder func(ev ExperimentalEvent) (any, error)
+
+// ExperimentalBatchDecoder decodes the data in one batch of an experiment's
+// ExperimentalData into records.
+type ExperimentalBatchDecoder func(b ExperimentalBatch) ([]any, error)
+
+// ErrNoDecoder is returned when decoding experimental events or data for
+// which no decoder is registered.
+var ErrNoDecoder = errors.New("no decoder registered")
+
+type experimentalEventKey struct {
+	experiment, name string
+}
+
+var experimentalDecoders struct {
+	sync.RWMutex
+	events  map[experimentalEventKey]ExperimentalEventDecoder
+	batches map[string]ExperimentalBatchDecoder
+}
+
+// RegisterExperimentalEvent registers dec to decode the experimental event
+// called name that's part of the named experiment. Once registered, the
+// decoder is used by ExperimentalEvent.Decode, ExperimentalEvent.String,
+// and Event.String.
+//
+// RegisterExperimentalEvent panics if dec is nil or if a decoder is already
+// registered for the event. It's intended to be called from init functions.
+func RegisterExperimentalEvent(experiment, name string, dec ExperimentalEventDecoder) {
+	if dec == nil {
+		panic("trace: RegisterExperimentalEvent decoder is nil")
+	}
+	experimentalDecoders.Lock()
+	defer experimentalDecoders.Unlock()
+
+	key := experimentalEventKey{experiment, name}
+	if _, ok := experimentalDecoders.events[key]; ok {
+		panic(fmt.Sprintf("trace: RegisterExperimentalEvent called twice for %s event %s", experiment, name))
+	}
+	if experimentalDecoders.events == nil {
+		experimentalDecoders.events = make(map[experimentalEventKey]ExperimentalEventDecoder)
+	}
+	experimentalDecoders.events[key] = dec
+}
+
+// RegisterExperimentalBatch registers dec to decode the batches of
+// ExperimentalData that belong to the named experiment. Once registered,
+// the decoder is used by ExperimentalEvent.DecodeData.
+//
+// RegisterExperimentalBatch panics if dec is nil or if a decoder is already
+// registered for the experiment. It's intended to be called from init
+// functions.
+func RegisterExperimentalBatch(experiment string, dec ExperimentalBatchDecoder) {
+	if dec == nil {
+		panic("trace: RegisterExperimentalBatch decoder is nil")
+	}
+	experimentalDecoders.Lock()
+	defer experimentalDecoders.Unlock()
+
+	if _, ok := experimentalDecoders.batches[experiment]; ok {
+		panic(fmt.Sprintf("trace: RegisterExperimentalBatch called twice for %s", experiment))
+	}
+	if experimentalDecoders.batches == nil {
+		experimentalDecoders.batches = make(map[string]ExperimentalBatchDecoder)
+	}
+	experimentalDecoders.batches[experiment] = dec
+}
+
+// Decode decodes the event with the decoder registered for it by
+// RegisterExperimentalEvent. It returns an error wrapping ErrNoDecoder if
+// there isn't one.
+func (e ExperimentalEvent) Decode() (any, error) {
+	experimentalDecoders.RLock()
+	dec := experimentalDecoders.events[experimentalEventKey{e.Experiment(), e.Name}]
+	experimentalDecoders.RUnlock()
+
+	if dec == nil {
+		return nil, fmt.Errorf("%s event %s: %w", e.Experiment(), e.Name, ErrNoDecoder)
+	}
+	return dec(e)
+}
+
+// ExperimentalRecord is a record decoded from a batch of ExperimentalData.
+type ExperimentalRecord struct {
+	// Thread is the ID of the thread that produced the batch the record
+	// came from.
+	Thread ThreadID
+
+	// Value is the record produced by the batch decoder.
+	Value any
+}
+
+// DecodeData decodes the batches of the event's Data with the decoder
+// registered for its experiment by RegisterExperimentalBatch. It returns an
+// error wrapping ErrNoDecoder if there isn't one.
+//
+// Data is shared by all the events of an experiment in a generation, so
+// callers should decode it once per distinct Data.
+func (e ExperimentalEvent) DecodeData() ([]ExperimentalRecord, error) {
+	exp := e.Experiment()
+	experimentalDecoders.RLock()
+	dec := experimentalDecoders.batches[exp]
+	experimentalDecoders.RUnlock()
+
+	if dec == nil {
+		return nil, fmt.Errorf("%s data: %w", exp, ErrNoDecoder)
+	}
+	if e.Data == nil {
+		return nil, nil
+	}
+	var records []ExperimentalRecord
+	for _, b := range e.Data.Batches {
+		values, err := dec(b)
+		if err != nil {
+			return nil, fmt.Errorf("decoding %s batch from thread %d: %w", exp, b.Thread, err)
+		}
+		for _, v := range values {
+			records = append(records, ExperimentalRecord{Thread: b.Thread, Value: v})
+		}
+	}
+	return records, nil
+}
+
+// ArgsDecoder returns an ExperimentalEventDecoder that decodes an event's
+// arguments into the fields of a new value of type T, which must be a struct.
+//
+// Each argument is stored in the exported field whose `trace` struct tag is
+// the argument's name. If no field has that tag, it's stored in the field
+// whose name matches the argument's name, ignoring case and underscores, so
+// that, for example, a field NPagesValue receives the argument npages_value.
+// Fields must have integer types. Arguments without a field are ignored.
+//
+// ArgsDecoder panics if T isn't a struct.
+func ArgsDecoder[T any]() ExperimentalEventDecoder {
+	typ := reflect.TypeOf((*T)(nil)).Elem()
+	if typ.Kind() != reflect.Struct {
+		panic(fmt.Sprintf("trace: ArgsDecoder type %v is not a struct", typ))
+	}
+	return func(ev ExperimentalEvent) (any, error) {
+		var v T
+		rv := reflect.ValueOf(&v).Elem()
+		for i, name := range ev.ArgNames {
+			if i >= len(ev.Args) {
+				break
+			}
+			f, ok := argField(typ, name)
+			if !ok {
+				continue
+			}
+			fv := rv.FieldByIndex(f.Index)
+			switch fv.Kind() {
+			case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+				if fv.OverflowUint(ev.Args[i]) {
+					return nil, fmt.Errorf("argument %s=%d overflows field %s", name, ev.Args[i], f.Name)
+				}
+				fv.SetUint(ev.Args[i])
+			case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+				if int64(ev.Args[i]) < 0 || fv.OverflowInt(int64(ev.Args[i])) {
+					return nil, fmt.Errorf("argument %s=%d overflows field %s", name, ev.Args[i], f.Name)
+				}
+				fv.SetInt(int64(ev.Args[i]))
+			default:
+				return nil, fmt.Errorf("field %s for argument %s has non-integer type %v", f.Name, name, f.Type)
+			}
+		}
+		return v, nil
+	}
+}
+
+// argField returns the field of struct type typ that receives the argument
+// called name.
+func argField(typ reflect.Type, name string) (reflect.StructField, bool) {
+	normalize := func(s string) string {
+		return strings.ToLower(strings.ReplaceAll(s, "_", ""))
+	}
+	var match reflect.StructField
+	found := false
+	for _, f := range reflect.VisibleFields(typ) {
+		if !f.IsExported() || f.Anonymous {
+			continue
+		}
+		if tag, ok := f.Tag.Lookup("trace"); ok {
+			if tag == name {
+				return f, true
+			}
+			continue
+		}
+		if !found && normalize(f.Name) == normalize(name) {
+			match, found = f, true
+		}
+	}
+	return match, found
+}
@@ -0,0 +1,131 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.21
+
+package trace_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/exp/trace"
+	"golang.org/x/exp/trace/internal/event/go122"
+	testgen "golang.org/x/exp/trace/internal/testgen/go122"
+)
+
+type heapObjectAlloc struct {
+	ID   uint64
+	Type uint32
+}
+
+type span struct {
+	ID     uint64
+	NPages uint64 `trace:"npages_value"`
+	Class  uint8  `trace:"kindclass"`
+}
+
+func init() {
+	trace.RegisterExperimentalEvent("AllocFree", "HeapObjectAlloc", trace.ArgsDecoder[heapObjectAlloc]())
+	trace.RegisterExperimentalEvent("AllocFree", "SpanAlloc", trace.ArgsDecoder[span]())
+
+	// Decode batches as sequences of uvarints.
+	trace.RegisterExperimentalBatch("AllocFree", func(b trace.ExperimentalBatch) ([]any, error) {
+		var values []any
+		for data := b.Data; len(data) != 0; {
+			v, n := binary.Uvarint(data)
+			if n <= 0 {
+				return nil, errors.New("bad uvarint")
+			}
+			values = append(values, v)
+			data = data[n:]
+		}
+		return values, nil
+	})
+}
+
+func TestExperimentalDecode(t *testing.T) {
+	tt := testgen.NewTrace()
+	tt.ExpectSuccess()
+	g := tt.Generation(1)
+	b := g.Batch(trace.ThreadID(0), 0)
+	b.Event("ProcStatus", trace.ProcID(0), go122.ProcRunning)
+	b.Event("GoStatus", trace.GoID(1), trace.ThreadID(0), go122.GoRunning)
+	b.RawEvent(go122.EvHeapObjectAlloc, nil, 1, 0x1000, 7)
+	b.RawEvent(go122.EvHeapObjectFree, nil, 1, 0x1000)
+	b.RawEvent(go122.EvSpanAlloc, nil, 1, 0x2000, 8, 300)
+
+	r, err := trace.NewReader(bytes.NewReader(generatedTrace(t, tt)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for {
+		ev, err := r.ReadEvent()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ev.Kind() != trace.EventExperimental {
+			continue
+		}
+		s := ev.Experimental().String()
+		got = append(got, s)
+		// Event.String shows the same form.
+		if !strings.Contains(ev.String(), " "+s) {
+			t.Errorf("Event.String() = %q, want it to contain %q", ev.String(), s)
+		}
+	}
+	want := []string{
+		"Name=HeapObjectAlloc Experiment=AllocFree Value={ID:4096 Type:7}",
+		"Name=HeapObjectFree ArgNames=[id] Args=[4096]",
+		`Name=SpanAlloc ArgNames=[id npages_value kindclass] Args=[8192 8 300] DecodeError="argument kindclass=300 overflows field Class"`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got experimental events:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestExperimentalDecodeData(t *testing.T) {
+	ev := trace.ExperimentalEvent{
+		Name:     "HeapObjectAlloc",
+		ArgNames: []string{"id", "type"},
+		Args:     []uint64{1, 2},
+		Data: &trace.ExperimentalData{Batches: []trace.ExperimentalBatch{
+			{Thread: 1, Data: binary.AppendUvarint([]byte{3}, 300)},
+			{Thread: 2, Data: []byte{4}},
+		}},
+	}
+	records, err := ev.DecodeData()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []trace.ExperimentalRecord{{1, uint64(3)}, {1, uint64(300)}, {2, uint64(4)}}
+	if !reflect.DeepEqual(records, want) {
+		t.Errorf("got records %v, want %v", records, want)
+	}
+
+	ev.Data.Batches[1].Data = []byte{0x80}
+	if _, err := ev.DecodeData(); err == nil || !strings.Contains(err.Error(), "thread 2") {
+		t.Errorf("got error %v for bad batch, want one for thread 2", err)
+	}
+
+	if got := ev.Experiment(); got != "AllocFree" {
+		t.Errorf("got experiment %q, want AllocFree", got)
+	}
+	ev.Name = "Other"
+	if _, err := ev.DecodeData(); !errors.Is(err, trace.ErrNoDecoder) {
+		t.Errorf("got error %v for unknown experiment, want %v", err, trace.ErrNoDecoder)
+	}
+	if _, err := ev.Decode(); !errors.Is(err, trace.ErrNoDecoder) {
+		t.Errorf("got error %v for unknown experiment, want %v", err, trace.ErrNoDecoder)
+	}
+}
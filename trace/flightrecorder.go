@@ -97,6 +97,18 @@ func (r *FlightRecorder) SetSize(bytes int) {
 	r.targetSize = bytes
 }
 
+// Period returns the approximate time duration that the flight recorder's circular
+// buffer represents, as set by SetPeriod.
+func (r *FlightRecorder) Period() time.Duration {
+	return r.targetPeriod
+}
+
+// Size returns the approximate size of the flight recorder's circular buffer,
+// as set by SetSize.
+func (r *FlightRecorder) Size() int {
+	return r.targetSize
+}
+
 // A recorder receives bytes from the runtime tracer, processes it.
 type recorder struct {
 	r *FlightRecorder
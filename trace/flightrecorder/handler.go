@@ -0,0 +1,209 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.22
+
+// Package flightrecorder serves a [trace.FlightRecorder] over HTTP, in the
+// spirit of [net/http/pprof].
+//
+// To use it, register the handler under a path prefix that ends in a slash:
+//
+//	fr := trace.NewFlightRecorder()
+//	http.Handle("/debug/flightrecorder/", flightrecorder.Handler(fr))
+//
+// The handler serves the following endpoints under the prefix:
+//
+//   - trace: GET downloads a snapshot of the flight recorder's window.
+//   - start: POST starts recording.
+//   - stop: POST stops recording.
+//   - config: GET returns whether recording is enabled, the period, and the
+//     size, as JSON. POST sets the period and size from the "period" and
+//     "size" form values, either of which may be omitted. Changes apply the
+//     next time recording starts.
+//
+// Any other path serves an index of the endpoints.
+package flightrecorder
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/exp/trace"
+)
+
+// Handler returns an HTTP handler that serves fr.
+//
+// The handler serializes changes to fr's configuration and to whether it's
+// recording, and doesn't stop recording while a snapshot is being written.
+// Only one snapshot may be written at a time; concurrent requests for a
+// snapshot fail with status 409 Conflict. Other code that starts, stops, or
+// configures fr must not do so while the handler is in use.
+func Handler(fr *trace.FlightRecorder) http.Handler {
+	return &handler{fr: fr}
+}
+
+type handler struct {
+	fr *trace.FlightRecorder
+	mu sync.RWMutex // held for reading while writing snapshots.
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch path.Base(r.URL.Path) {
+	case "trace":
+		if !allowMethod(w, r, http.MethodGet) {
+			return
+		}
+		h.serveTrace(w, r)
+	case "start":
+		if !allowMethod(w, r, http.MethodPost) {
+			return
+		}
+		h.mu.Lock()
+		err := h.fr.Start()
+		h.mu.Unlock()
+		h.serveResult(w, err)
+	case "stop":
+		if !allowMethod(w, r, http.MethodPost) {
+			return
+		}
+		h.mu.Lock()
+		err := h.fr.Stop()
+		h.mu.Unlock()
+		h.serveResult(w, err)
+	case "config":
+		if !allowMethod(w, r, http.MethodGet, http.MethodPost) {
+			return
+		}
+		if r.Method == http.MethodPost {
+			if err := h.setConfig(r); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		h.serveConfig(w)
+	default:
+		h.serveIndex(w)
+	}
+}
+
+// allowMethod reports whether r's method is one of methods, and replies
+// with an error if it isn't.
+func allowMethod(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	for _, m := range methods {
+		if r.Method == m {
+			return true
+		}
+	}
+	for _, m := range methods {
+		w.Header().Add("Allow", m)
+	}
+	http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	return false
+}
+
+func (h *handler) serveTrace(w http.ResponseWriter, r *http.Request) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	if !h.fr.Enabled() {
+		http.Error(w, "flight recorder is not running", http.StatusConflict)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", `attachment; filename="flightrecorder.trace"`)
+	n, err := h.fr.WriteTo(w)
+	if err == nil || n != 0 {
+		// Once the snapshot has started, there's no way to report an error.
+		return
+	}
+	w.Header().Del("Content-Disposition")
+	status := http.StatusInternalServerError
+	if errors.Is(err, trace.ErrSnapshotActive) {
+		status = http.StatusConflict
+	}
+	http.Error(w, err.Error(), status)
+}
+
+func (h *handler) serveResult(w http.ResponseWriter, err error) {
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	h.serveConfig(w)
+}
+
+// config is the JSON representation of the flight recorder's configuration.
+type config struct {
+	Enabled bool   `json:"enabled"`
+	Period  string `json:"period"`
+	Size    int    `json:"size"`
+}
+
+func (h *handler) setConfig(r *http.Request) error {
+	var period time.Duration
+	var size int
+	if s := r.FormValue("period"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return fmt.Errorf("invalid period: %v", err)
+		}
+		if d <= 0 {
+			return fmt.Errorf("invalid period %v: must be positive", d)
+		}
+		period = d
+	}
+	if s := r.FormValue("size"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return fmt.Errorf("invalid size: %v", err)
+		}
+		if n <= 0 {
+			return fmt.Errorf("invalid size %d: must be positive", n)
+		}
+		size = n
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if period != 0 {
+		h.fr.SetPeriod(period)
+	}
+	if size != 0 {
+		h.fr.SetSize(size)
+	}
+	return nil
+}
+
+func (h *handler) serveConfig(w http.ResponseWriter) {
+	h.mu.RLock()
+	c := config{
+		Enabled: h.fr.Enabled(),
+		Period:  h.fr.Period().String(),
+		Size:    h.fr.Size(),
+	}
+	h.mu.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(c)
+}
+
+func (h *handler) serveIndex(w http.ResponseWriter) {
+	h.mu.RLock()
+	enabled := h.fr.Enabled()
+	h.mu.RUnlock()
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintf(w, "Flight recorder (recording: %v)\n\n", enabled)
+	fmt.Fprintf(w, "GET  trace   download a snapshot of the recent execution trace\n")
+	fmt.Fprintf(w, "POST start   start recording\n")
+	fmt.Fprintf(w, "POST stop    stop recording\n")
+	fmt.Fprintf(w, "GET  config  show the configuration\n")
+	fmt.Fprintf(w, "POST config  set the period and size for the next recording, e.g. period=10s&size=10485760\n")
+}
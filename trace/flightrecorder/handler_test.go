@@ -0,0 +1,92 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.22
+
+package flightrecorder_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/exp/trace"
+	"golang.org/x/exp/trace/flightrecorder"
+)
+
+func TestHandlerConfig(t *testing.T) {
+	fr := trace.NewFlightRecorder()
+	srv := httptest.NewServer(flightrecorder.Handler(fr))
+	defer srv.Close()
+
+	resp, err := http.PostForm(srv.URL+"/debug/fr/config", url.Values{"period": {"3s"}, "size": {"1024"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("got status %s, want 200 OK", resp.Status)
+	}
+	var c struct {
+		Enabled bool
+		Period  string
+		Size    int
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&c); err != nil {
+		t.Fatal(err)
+	}
+	if c.Enabled || c.Period != "3s" || c.Size != 1024 {
+		t.Errorf("unexpected config %+v", c)
+	}
+	if fr.Period() != 3*time.Second || fr.Size() != 1024 {
+		t.Errorf("flight recorder has period %v and size %d, want 3s and 1024", fr.Period(), fr.Size())
+	}
+
+	for _, bad := range []url.Values{{"period": {"soon"}}, {"size": {"-1"}}} {
+		resp, err := http.PostForm(srv.URL+"/debug/fr/config", bad)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%v: got status %s, want 400 Bad Request", bad, resp.Status)
+		}
+	}
+}
+
+func TestHandlerErrors(t *testing.T) {
+	h := flightrecorder.Handler(trace.NewFlightRecorder())
+	for _, test := range []struct {
+		method, path string
+		status       int
+	}{
+		{"GET", "/trace", http.StatusConflict},
+		{"POST", "/trace", http.StatusMethodNotAllowed},
+		{"GET", "/start", http.StatusMethodNotAllowed},
+		{"POST", "/stop", http.StatusConflict},
+		{"DELETE", "/config", http.StatusMethodNotAllowed},
+		{"GET", "/", http.StatusOK},
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(test.method, test.path, nil))
+		if w.Code != test.status {
+			t.Errorf("%s %s: got status %d, want %d", test.method, test.path, w.Code, test.status)
+		}
+	}
+}
+
+func TestHandlerIndex(t *testing.T) {
+	h := flightrecorder.Handler(trace.NewFlightRecorder())
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/debug/flightrecorder/", nil))
+	for _, endpoint := range []string{"trace", "start", "stop", "config"} {
+		if !strings.Contains(w.Body.String(), endpoint) {
+			t.Errorf("index doesn't mention %q:\n%s", endpoint, w.Body.String())
+		}
+	}
+}
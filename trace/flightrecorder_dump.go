@@ -0,0 +1,292 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.22
+
+package trace
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// DumpOptions configures a Dumper.
+type DumpOptions struct {
+	// Dir is the directory snapshots are written to. It's created if it
+	// doesn't exist.
+	Dir string
+
+	// Prefix is the prefix of the names of snapshot files, which are
+	// followed by the time of the snapshot. The default is "flightrecorder".
+	Prefix string
+
+	// MinInterval is the minimum time between snapshots. Triggers that fire
+	// sooner than MinInterval after the last snapshot are ignored. If zero,
+	// snapshots are only limited to one at a time.
+	MinInterval time.Duration
+
+	// MaxFiles is the maximum number of snapshots to keep in Dir. Once there
+	// are more, the oldest are removed. Snapshots written by earlier Dumpers
+	// with the same Dir and Prefix count towards the limit. If zero, all
+	// snapshots are kept.
+	MaxFiles int
+
+	// OnDump, if not nil, is called after every snapshot attempt with the
+	// reason for the snapshot, the path it was written to, and the error,
+	// if any. This is the only way to learn about the outcome of snapshots
+	// taken in the background.
+	OnDump func(reason, path string, err error)
+}
+
+// Dumper writes snapshots of a FlightRecorder to files when triggered,
+// for example when a request is slow, the program panics, or it receives
+// a signal.
+//
+// A Dumper takes at most one snapshot at a time, and triggers that fire
+// while a snapshot is being written are ignored.
+type Dumper struct {
+	opts     DumpOptions
+	snapshot func(io.Writer) error
+
+	mu    sync.Mutex
+	busy  bool      // whether a snapshot is being written.
+	last  time.Time // the time of the last snapshot.
+	seq   int       // to disambiguate files written at the same time.
+	files []string  // paths of retained snapshots, oldest first.
+}
+
+// ErrDumpSkipped is returned by Dumper.Dump if the snapshot was skipped,
+// because it was too soon after the last one or another one was in progress.
+var ErrDumpSkipped = errors.New("flight recorder snapshot skipped")
+
+// NewDumper creates a Dumper that writes snapshots of r according to opts.
+func NewDumper(r *FlightRecorder, opts DumpOptions) (*Dumper, error) {
+	return newDumper(func(w io.Writer) error {
+		_, err := r.WriteTo(w)
+		return err
+	}, opts)
+}
+
+func newDumper(snapshot func(io.Writer) error, opts DumpOptions) (*Dumper, error) {
+	if opts.Dir == "" {
+		return nil, errors.New("no snapshot directory")
+	}
+	if opts.Prefix == "" {
+		opts.Prefix = "flightrecorder"
+	}
+	if err := os.MkdirAll(opts.Dir, 0o777); err != nil {
+		return nil, err
+	}
+	// Retain snapshots from previous runs, so that the limit still applies.
+	files, err := filepath.Glob(filepath.Join(opts.Dir, opts.Prefix+"-*.trace"))
+	if err != nil {
+		return nil, err
+	}
+	slices.Sort(files) // File names sort by time.
+	return &Dumper{opts: opts, snapshot: snapshot, files: files}, nil
+}
+
+// Dump takes a snapshot now, unless it's too soon after the last one
+// or another is in progress, in which case it returns ErrDumpSkipped.
+// It returns the path of the new snapshot.
+func (d *Dumper) Dump(reason string) (string, error) {
+	if !d.acquire() {
+		return "", ErrDumpSkipped
+	}
+	defer d.release()
+	return d.dump(reason)
+}
+
+// dumpAsync takes a snapshot in the background, if one may be taken now.
+func (d *Dumper) dumpAsync(reason string) {
+	if !d.acquire() {
+		return
+	}
+	go func() {
+		defer d.release()
+		d.dump(reason)
+	}()
+}
+
+func (d *Dumper) acquire() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := time.Now()
+	if d.busy || (!d.last.IsZero() && now.Sub(d.last) < d.opts.MinInterval) {
+		return false
+	}
+	d.busy = true
+	d.last = now
+	return true
+}
+
+func (d *Dumper) release() {
+	d.mu.Lock()
+	d.busy = false
+	d.mu.Unlock()
+}
+
+// dump writes a snapshot and enforces the retention limit. The caller
+// must have acquired the Dumper.
+func (d *Dumper) dump(reason string) (path string, err error) {
+	defer func() {
+		if d.opts.OnDump != nil {
+			d.opts.OnDump(reason, path, err)
+		}
+	}()
+
+	d.seq++
+	name := fmt.Sprintf("%s-%s-%d.trace", d.opts.Prefix, d.last.UTC().Format("20060102T150405.000000000"), d.seq)
+	path = filepath.Join(d.opts.Dir, name)
+
+	// Write to a temporary file first, so that other processes never see
+	// a partial snapshot.
+	f, err := os.CreateTemp(d.opts.Dir, ".tmp-"+name)
+	if err != nil {
+		return "", err
+	}
+	err = d.snapshot(f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+
+	d.files = append(d.files, path)
+	for d.opts.MaxFiles > 0 && len(d.files) > d.opts.MaxFiles {
+		if err := os.Remove(d.files[0]); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return path, err
+		}
+		d.files = d.files[1:]
+	}
+	return path, nil
+}
+
+// Slow returns a function that takes a snapshot in the background if it's
+// called more than threshold after Slow was. It's meant for detecting
+// slow operations, like so:
+//
+//	defer d.Slow(100 * time.Millisecond)()
+func (d *Dumper) Slow(threshold time.Duration) func() {
+	start := time.Now()
+	return func() {
+		if elapsed := time.Since(start); elapsed > threshold {
+			d.dumpAsync(fmt.Sprintf("slow: took %v, over threshold %v", elapsed, threshold))
+		}
+	}
+}
+
+// RecoverPanic takes a snapshot if the goroutine is panicking, and then
+// continues panicking. It must be called directly by a deferred function:
+//
+//	defer d.RecoverPanic()
+//
+// Unlike other triggers, the snapshot is taken synchronously, since the
+// program is likely to exit soon after.
+func (d *Dumper) RecoverPanic() {
+	if v := recover(); v != nil {
+		d.Dump(fmt.Sprintf("panic: %v", v))
+		panic(v)
+	}
+}
+
+// NotifySignal takes a snapshot whenever the process receives one of the
+// given signals, until stop is called. See [os/signal.Notify] for the
+// effects on how the signals are otherwise handled.
+func (d *Dumper) NotifySignal(sigs ...os.Signal) (stop func()) {
+	c := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	signal.Notify(c, sigs...)
+	go func() {
+		for {
+			select {
+			case sig := <-c:
+				d.Dump(fmt.Sprintf("signal: %v", sig))
+			case <-done:
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			signal.Stop(c)
+			close(done)
+		})
+	}
+}
+
+// Poll calls pred every interval, and takes a snapshot whenever it returns
+// true, until stop is called.
+func (d *Dumper) Poll(interval time.Duration, pred func() bool) (stop func()) {
+	t := time.NewTicker(interval)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-t.C:
+				if pred() {
+					d.Dump("predicate")
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			t.Stop()
+			close(done)
+		})
+	}
+}
+
+// SlogHandler returns a [log/slog.Handler] that passes records to h, and
+// takes a snapshot in the background for every record at or above level.
+func (d *Dumper) SlogHandler(h slog.Handler, level slog.Leveler) slog.Handler {
+	return &dumpHandler{h: h, d: d, level: level}
+}
+
+type dumpHandler struct {
+	h     slog.Handler
+	d     *Dumper
+	level slog.Leveler
+}
+
+func (h *dumpHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.level.Level() || h.h.Enabled(ctx, level)
+}
+
+func (h *dumpHandler) Handle(ctx context.Context, r slog.Record) error {
+	if r.Level >= h.level.Level() {
+		h.d.dumpAsync(fmt.Sprintf("log: %s: %s", r.Level, r.Message))
+	}
+	if !h.h.Enabled(ctx, r.Level) {
+		return nil
+	}
+	return h.h.Handle(ctx, r)
+}
+
+func (h *dumpHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &dumpHandler{h: h.h.WithAttrs(attrs), d: h.d, level: h.level}
+}
+
+func (h *dumpHandler) WithGroup(name string) slog.Handler {
+	return &dumpHandler{h: h.h.WithGroup(name), d: h.d, level: h.level}
+}
@@ -0,0 +1,139 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.22
+
+package trace
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testDumper returns a Dumper whose snapshots are numbered, and a channel
+// that receives the reason for every snapshot.
+func testDumper(t *testing.T, opts DumpOptions) (*Dumper, <-chan string) {
+	t.Helper()
+
+	if opts.Dir == "" {
+		opts.Dir = t.TempDir()
+	}
+	dumped := make(chan string, 10)
+	opts.OnDump = func(reason, path string, err error) {
+		if err != nil {
+			t.Errorf("snapshot for %q failed: %v", reason, err)
+		}
+		dumped <- reason
+	}
+	var n atomic.Int32
+	d, err := newDumper(func(w io.Writer) error {
+		_, err := fmt.Fprintf(w, "snapshot %d", n.Add(1))
+		return err
+	}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d, dumped
+}
+
+func TestDumperRotation(t *testing.T) {
+	dir := t.TempDir()
+	old := filepath.Join(dir, "test-20000101T000000.000000000-1.trace")
+	if err := os.WriteFile(old, nil, 0o666); err != nil {
+		t.Fatal(err)
+	}
+	d, _ := testDumper(t, DumpOptions{Dir: dir, Prefix: "test", MaxFiles: 2})
+	var paths []string
+	for i := 0; i < 3; i++ {
+		path, err := d.Dump("test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 || files[0] != paths[1] || files[1] != paths[2] {
+		t.Fatalf("got files %q, want %q", files, paths[1:])
+	}
+	if b, err := os.ReadFile(paths[2]); err != nil || string(b) != "snapshot 3" {
+		t.Errorf("got %q, %v for the last snapshot, want %q", b, err, "snapshot 3")
+	}
+}
+
+func TestDumperRateLimit(t *testing.T) {
+	d, _ := testDumper(t, DumpOptions{MinInterval: time.Hour})
+	if _, err := d.Dump("first"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Dump("second"); err != ErrDumpSkipped {
+		t.Fatalf("got error %v for snapshot within the minimum interval, want %v", err, ErrDumpSkipped)
+	}
+}
+
+func TestDumperSlow(t *testing.T) {
+	d, dumped := testDumper(t, DumpOptions{})
+	d.Slow(time.Hour)()
+	d.Slow(0)()
+	if reason := <-dumped; !strings.HasPrefix(reason, "slow") {
+		t.Errorf("unexpected reason %q", reason)
+	}
+	select {
+	case reason := <-dumped:
+		t.Errorf("unexpected snapshot for %q", reason)
+	default:
+	}
+}
+
+func TestDumperPanic(t *testing.T) {
+	d, dumped := testDumper(t, DumpOptions{})
+	func() {
+		defer func() {
+			if v := recover(); v != "boom" {
+				t.Errorf("got panic value %v, want %q", v, "boom")
+			}
+		}()
+		defer d.RecoverPanic()
+		panic("boom")
+	}()
+	if reason := <-dumped; reason != "panic: boom" {
+		t.Errorf("unexpected reason %q", reason)
+	}
+}
+
+func TestDumperSlog(t *testing.T) {
+	d, dumped := testDumper(t, DumpOptions{})
+	var buf bytes.Buffer
+	logger := slog.New(d.SlogHandler(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelWarn}), slog.LevelError))
+	logger.Info("ignored")
+	logger.Warn("logged")
+	logger.Error("failed", "err", "oops")
+	if reason := <-dumped; reason != "log: ERROR: failed" {
+		t.Errorf("unexpected reason %q", reason)
+	}
+	if out := buf.String(); strings.Contains(out, "ignored") || !strings.Contains(out, "logged") || !strings.Contains(out, "err=oops") {
+		t.Errorf("unexpected log output %q", out)
+	}
+}
+
+func TestDumperPoll(t *testing.T) {
+	d, dumped := testDumper(t, DumpOptions{})
+	var fire atomic.Bool
+	stop := d.Poll(time.Millisecond, func() bool { return fire.Swap(false) })
+	defer stop()
+	fire.Store(true)
+	if reason := <-dumped; reason != "predicate" {
+		t.Errorf("unexpected reason %q", reason)
+	}
+}
@@ -89,6 +89,10 @@ find $DST -name '*.go' | xargs -- sed -i'.tmp' -e '/LICENSE file./a \
 \
 //go:build go1.21'
 
+# Print experimental events in Event.String in the form decoded by any
+# decoder registered for them. See ExperimentalEvent.String.
+perl -0pi -e 's/\t\tr := e\.Experimental\(\)\n\t\tfmt\.Fprintf\(&sb, " Name=%s ArgNames=%v Args=%v", r\.Name, r\.ArgNames, r\.Args\)\n/\t\tfmt.Fprintf(&sb, " %s", e.Experimental())\n/ or die "gen.bash: EventExperimental case not found in Event.String\n"' $DST/event.go
+
 # Format the files.
 find $DST -name '*.go' | xargs -- gofmt -w -s
 
@@ -96,4 +100,11 @@ find $DST -name '*.go' | xargs -- gofmt -w -s
 find $DST -name '*.go.tmp' -delete
 
 # Restore known files.
-git checkout gen.bash flightrecorder.go flightrecorder_test.go
+git checkout gen.bash flightrecorder.go flightrecorder_test.go flightrecorder_dump.go flightrecorder_dump_test.go \
+	reader_options.go reader_options_test.go decode.go pipeline.go pipeline_test.go experimental.go experimental_test.go \
+	writer.go writer_test.go indexed.go indexed_test.go \
+	slice.go slice_test.go cmd/gotraceslice analysis pprof \
+	perfetto cmd/gotrace2perfetto cmd/gotracestats flightrecorder cmd/gotracediff \
+	legacy.go legacy_test.go cmd/gotraceupgrade \
+	reader_position_test.go cmd/gotracereport reader_tolerant_test.go tracetest \
+	query cmd/gotracequery otlp cmd/gotrace2otlp cmd/gotracemetrics
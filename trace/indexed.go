@@ -0,0 +1,340 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.21
+
+package trace
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	"golang.org/x/exp/trace/internal/event"
+	"golang.org/x/exp/trace/internal/version"
+)
+
+// Index records where each generation of a trace begins and the span of
+// time it covers, so that an IndexedReader can start reading a trace from
+// any generation without reading everything before it.
+//
+// An Index can be saved alongside the trace it was built from with WriteTo,
+// and loaded again with ReadIndex.
+type Index struct {
+	// Size is the size in bytes of the trace the index was built from.
+	Size int64
+
+	// Generations are the generations of the trace, in order.
+	Generations []GenerationIndex
+
+	version version.Version
+}
+
+// GenerationIndex describes one generation of a trace.
+type GenerationIndex struct {
+	// Gen is the generation number.
+	Gen uint64
+
+	// Offset is the offset in bytes of the generation's first batch from
+	// the start of the trace, and Length is the number of bytes in the
+	// generation.
+	Offset, Length int64
+
+	// Start and End are the times of the first and last events in the
+	// generation. They may differ by a few nanoseconds from the times of
+	// those events returned by a Reader, which adjusts timestamps to be
+	// strictly increasing.
+	Start, End Time
+}
+
+// BuildIndex builds an Index for the trace of the given size read from r.
+//
+// BuildIndex reads the whole trace, but only decodes the headers of most
+// batches, so it's much faster than reading the trace with a Reader. Traces
+// produced by Go 1.21 and earlier aren't organized into generations and
+// can't be indexed.
+//
+// If the trace is damaged, BuildIndex returns an index of the generations
+// before the damage along with the error, which is enough to read around
+// the damage with an IndexedReader.
+func BuildIndex(r io.ReaderAt, size int64) (*Index, error) {
+	br := newTraceReader(io.NewSectionReader(r, 0, size), 0)
+	v, err := version.ReadHeader(br)
+	if err != nil {
+		return nil, err
+	}
+	if v < version.Go122 {
+		return nil, fmt.Errorf("can't index trace version go 1.%d: only go 1.22 and later traces have generations", v)
+	}
+
+	idx := &Index{Size: size, version: v}
+	var (
+		cur      *GenerationIndex
+		freq     frequency
+		min, max timestamp
+	)
+	finish := func(end int64) error {
+		if freq == 0 {
+			return fmt.Errorf("no frequency event found for generation %d", cur.Gen)
+		}
+		cur.Length = end - cur.Offset
+		cur.Start = freq.mul(min)
+		cur.End = freq.mul(max)
+		idx.Generations = append(idx.Generations, *cur)
+		return nil
+	}
+	for {
+		off := br.offset()
+		b, gen, err := readBatch(br)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return idx, fmt.Errorf("reading batch at offset %d: %w", off, err)
+		}
+		if gen == 0 {
+			return idx, fmt.Errorf("invalid generation number %d at offset %d", gen, off)
+		}
+		if cur == nil || gen != cur.Gen {
+			if cur != nil {
+				if gen != cur.Gen+1 {
+					return idx, fmt.Errorf("generations out of order at offset %d", off)
+				}
+				if err := finish(off); err != nil {
+					return idx, err
+				}
+			}
+			cur = &GenerationIndex{Gen: gen, Offset: off}
+			freq, min, max = 0, 0, 0
+		}
+		switch {
+		case b.isFreqBatch():
+			if freq, err = parseFreq(b); err != nil {
+				return idx, err
+			}
+		case b.isStringsBatch(), b.isStacksBatch(), b.isCPUSamplesBatch(), b.exp != event.NoExperiment:
+			// These batches have no events with timestamps.
+		default:
+			first, last, ok, err := batchTimeRange(b)
+			if err != nil {
+				return idx, fmt.Errorf("reading batch at offset %d: %w", off, err)
+			}
+			if !ok {
+				break
+			}
+			if min == 0 || first < min {
+				min = first
+			}
+			if last > max {
+				max = last
+			}
+		}
+	}
+	if cur != nil {
+		if err := finish(br.offset()); err != nil {
+			return idx, err
+		}
+	}
+	return idx, nil
+}
+
+// batchTimeRange returns the timestamps of the first and last events in an
+// event batch, if it has any.
+func batchTimeRange(b batch) (first, last timestamp, ok bool, err error) {
+	ts := b.time
+	var ev baseEvent
+	for off := 0; off < len(b.data); {
+		n, diff, err := readTimedBaseEvent(b.data[off:], &ev)
+		if err != nil {
+			return 0, 0, false, err
+		}
+		ts += diff
+		if !ok {
+			first, ok = ts, true
+		}
+		off += n
+	}
+	return first, ts, ok, nil
+}
+
+// indexMagic identifies a serialized Index.
+const indexMagic = "go trace index\x00\x01"
+
+// WriteTo writes a serialized form of the index to w, which ReadIndex can
+// read back.
+func (idx *Index) WriteTo(w io.Writer) (int64, error) {
+	buf := []byte(indexMagic)
+	buf = binary.AppendUvarint(buf, uint64(idx.version))
+	buf = binary.AppendUvarint(buf, uint64(idx.Size))
+	buf = binary.AppendUvarint(buf, uint64(len(idx.Generations)))
+	for _, g := range idx.Generations {
+		buf = binary.AppendUvarint(buf, g.Gen)
+		buf = binary.AppendUvarint(buf, uint64(g.Offset))
+		buf = binary.AppendUvarint(buf, uint64(g.Length))
+		buf = binary.AppendVarint(buf, int64(g.Start))
+		buf = binary.AppendVarint(buf, int64(g.End))
+	}
+	n, err := w.Write(buf)
+	return int64(n), err
+}
+
+// ReadIndex reads an index written by WriteTo.
+func ReadIndex(r io.Reader) (*Index, error) {
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(indexMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || string(magic) != indexMagic {
+		return nil, fmt.Errorf("bad file format: not a trace index")
+	}
+	var err error
+	next := func() uint64 {
+		if err != nil {
+			return 0
+		}
+		var v uint64
+		v, err = binary.ReadUvarint(br)
+		return v
+	}
+	nextInt := func() int64 {
+		if err != nil {
+			return 0
+		}
+		var v int64
+		v, err = binary.ReadVarint(br)
+		return v
+	}
+	idx := &Index{
+		version: version.Version(next()),
+		Size:    int64(next()),
+	}
+	n := next()
+	for i := uint64(0); i < n && err == nil; i++ {
+		idx.Generations = append(idx.Generations, GenerationIndex{
+			Gen:    next(),
+			Offset: int64(next()),
+			Length: int64(next()),
+			Start:  Time(nextInt()),
+			End:    Time(nextInt()),
+		})
+	}
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading trace index: %w", err)
+	}
+	if !idx.version.Valid() || idx.version < version.Go122 {
+		return nil, fmt.Errorf("trace index has unsupported trace version go 1.%d", idx.version)
+	}
+	return idx, nil
+}
+
+// IndexedReader provides random access to a trace by generation, using an
+// Index. It's suited to browsing large traces, for example by mapping them
+// into memory with golang.org/x/exp/mmap.
+type IndexedReader struct {
+	r     io.ReaderAt
+	index *Index
+}
+
+// NewIndexedReader returns an IndexedReader for the trace of the given size
+// read from r. If index is nil, NewIndexedReader builds one with BuildIndex.
+// Otherwise, index must have been built from the same trace.
+//
+// If index is nil and the trace is damaged, NewIndexedReader returns an
+// IndexedReader for the generations before the damage along with the error
+// from BuildIndex, unless none of the trace could be indexed.
+func NewIndexedReader(r io.ReaderAt, size int64, index *Index) (*IndexedReader, error) {
+	if index == nil {
+		index, err := BuildIndex(r, size)
+		if index == nil {
+			return nil, err
+		}
+		return &IndexedReader{r: r, index: index}, err
+	}
+	if index.Size != size {
+		return nil, fmt.Errorf("trace index is for a %d byte trace, but the trace is %d bytes", index.Size, size)
+	}
+	v, err := version.ReadHeader(io.NewSectionReader(r, 0, size))
+	if err != nil {
+		return nil, err
+	}
+	if v != index.version {
+		return nil, fmt.Errorf("trace index is for trace version go 1.%d, but the trace is version go 1.%d", index.version, v)
+	}
+	return &IndexedReader{r: r, index: index}, nil
+}
+
+// Index returns the index used by r.
+func (r *IndexedReader) Index() *Index {
+	return r.index
+}
+
+// ErrNoGeneration is returned by IndexedReader.SeekGeneration for an index
+// out of range.
+var ErrNoGeneration = errors.New("no such generation")
+
+// SeekGeneration returns a Reader that reads the trace from the start of
+// the i'th generation in the index to the end of the trace.
+//
+// Goroutines, procs, and threads start out in the states they were in at
+// the start of the generation, as they do at the start of every generation.
+// But tasks and regions that began in earlier generations will be missing
+// their begin events, and the Reader won't know the types of tasks that
+// end.
+func (r *IndexedReader) SeekGeneration(i int) (*Reader, error) {
+	tr, _, err := r.from(i)
+	if err != nil {
+		return nil, err
+	}
+	return NewReader(tr)
+}
+
+// SeekGenerationWithOptions is like SeekGeneration, but returns a
+// ReaderWithOptions configured by opts. The positions it reports are
+// offsets from the start of the whole trace.
+func (r *IndexedReader) SeekGenerationWithOptions(i int, opts ReaderOptions) (*ReaderWithOptions, error) {
+	tr, base, err := r.from(i)
+	if err != nil {
+		return nil, err
+	}
+	return newReaderWithOptions(tr, opts, base)
+}
+
+// from returns a trace that starts with the i'th generation in the index,
+// and the offset in the original trace of its first byte. The trace's
+// header comes first, so the offset is that of the generation less the
+// header's length.
+func (r *IndexedReader) from(i int) (io.Reader, int64, error) {
+	if i < 0 || i >= len(r.index.Generations) {
+		return nil, 0, ErrNoGeneration
+	}
+	g := &r.index.Generations[i]
+	var hdr bytes.Buffer
+	if _, err := version.WriteHeader(&hdr, r.index.version); err != nil {
+		return nil, 0, err
+	}
+	base := g.Offset - int64(hdr.Len())
+	return io.MultiReader(&hdr, io.NewSectionReader(r.r, g.Offset, r.index.Size-g.Offset)), base, nil
+}
+
+// Seek returns a Reader that reads the trace from the start of the
+// generation that contains t, or, if t falls between generations, the
+// generation before t. If t is before the start of the trace, Seek reads
+// from the start of the first generation.
+//
+// The Reader returns events from before t in the same generation, so
+// callers interested only in later events must skip them. See
+// SeekGeneration for how the Reader treats state from earlier generations.
+func (r *IndexedReader) Seek(t Time) (*Reader, error) {
+	gens := r.index.Generations
+	i := sort.Search(len(gens), func(i int) bool { return gens[i].Start > t }) - 1
+	if i < 0 {
+		i = 0
+	}
+	return r.SeekGeneration(i)
+}
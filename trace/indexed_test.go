@@ -0,0 +1,272 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.21
+
+package trace_test
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"golang.org/x/exp/trace"
+	"golang.org/x/exp/trace/internal/event/go122"
+	testgen "golang.org/x/exp/trace/internal/testgen/go122"
+	"golang.org/x/exp/trace/internal/testtrace"
+)
+
+// generationsTrace returns a trace with n generations, each of which logs
+// its generation number.
+func generationsTrace(t *testing.T, n int) []byte {
+	t.Helper()
+
+	tt := testgen.NewTrace()
+	tt.ExpectSuccess()
+	for i := 1; i <= n; i++ {
+		g := tt.Generation(uint64(i))
+		b := g.Batch(trace.ThreadID(0), testgen.Time(100*i))
+		b.Event("ProcStatus", trace.ProcID(0), go122.ProcRunning)
+		b.Event("GoStatus", trace.GoID(1), trace.ThreadID(0), go122.GoRunning)
+		b.Event("UserLog", trace.TaskID(0), "gen", fmt.Sprint(i), testgen.NoStack)
+	}
+	return generatedTrace(t, tt)
+}
+
+// generatedTrace returns the binary form of tt.
+func generatedTrace(t *testing.T, tt *testgen.Trace) []byte {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "generated.test")
+	if err := os.WriteFile(path, tt.Generate(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tr, _, err := testtrace.ParseFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(tr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// eventReader is a Reader or a ReaderWithOptions.
+type eventReader interface {
+	ReadEvent() (trace.Event, error)
+}
+
+// readLogs returns the values of the log events read by r.
+func readLogs(t *testing.T, r eventReader) []string {
+	t.Helper()
+
+	var logs []string
+	for {
+		ev, err := r.ReadEvent()
+		if err == io.EOF {
+			return logs
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ev.Kind() == trace.EventLog {
+			logs = append(logs, ev.Log().Message)
+		}
+	}
+}
+
+func TestIndexedReader(t *testing.T) {
+	data := generationsTrace(t, 4)
+	ir, err := trace.NewIndexedReader(bytes.NewReader(data), int64(len(data)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx := ir.Index()
+	if len(idx.Generations) != 4 {
+		t.Fatalf("got %d generations, want 4: %+v", len(idx.Generations), idx.Generations)
+	}
+	var prev trace.GenerationIndex
+	for i, g := range idx.Generations {
+		if g.Gen != uint64(i+1) || g.Start > g.End || g.Length <= 0 {
+			t.Errorf("bad generation %d: %+v", i, g)
+		}
+		if i > 0 && (g.Offset != prev.Offset+prev.Length || g.Start <= prev.End) {
+			t.Errorf("generation %d doesn't follow the one before: %+v then %+v", i, prev, g)
+		}
+		prev = g
+	}
+	if end := prev.Offset + prev.Length; end != int64(len(data)) {
+		t.Errorf("generations end at offset %d, want %d", end, len(data))
+	}
+
+	for i, g := range idx.Generations {
+		r, err := ir.SeekGeneration(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var want []string
+		for j := i + 1; j <= len(idx.Generations); j++ {
+			want = append(want, fmt.Sprint(j))
+		}
+		if got := readLogs(t, r); !reflect.DeepEqual(got, want) {
+			t.Errorf("reading from generation %d: got logs %q, want %q", i, got, want)
+		}
+
+		// Seeking to any time in the generation, or between it and the
+		// next, starts at the same generation.
+		for _, ts := range []trace.Time{g.Start, (g.Start + g.End) / 2, g.End + 1} {
+			r, err := ir.Seek(ts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := readLogs(t, r); !reflect.DeepEqual(got, want) {
+				t.Errorf("seeking to %v: got logs %q, want %q", ts, got, want)
+			}
+		}
+	}
+	if r, err := ir.Seek(0); err != nil {
+		t.Fatal(err)
+	} else if got := readLogs(t, r); len(got) != 4 {
+		t.Errorf("seeking to the start: got logs %q, want all 4", got)
+	}
+	if _, err := ir.SeekGeneration(4); err != trace.ErrNoGeneration {
+		t.Errorf("got error %v seeking past the last generation, want %v", err, trace.ErrNoGeneration)
+	}
+}
+
+func TestIndexRoundTrip(t *testing.T) {
+	data := generationsTrace(t, 3)
+	idx, err := trace.BuildIndex(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if _, err := idx.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	saved := buf.Bytes()
+	got, err := trace.ReadIndex(bytes.NewReader(saved))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, idx) {
+		t.Errorf("got index %+v, want %+v", got, idx)
+	}
+	if _, err := trace.NewIndexedReader(bytes.NewReader(data), int64(len(data)), got); err != nil {
+		t.Errorf("loaded index doesn't match its trace: %v", err)
+	}
+	if _, err := trace.NewIndexedReader(bytes.NewReader(data[:len(data)-1]), int64(len(data)-1), got); err == nil {
+		t.Errorf("index matches a different trace")
+	}
+	if _, err := trace.ReadIndex(bytes.NewReader(saved[:len(saved)-1])); err == nil {
+		t.Errorf("read a truncated index")
+	}
+}
+
+func TestIndexGolden(t *testing.T) {
+	// The stress tests are real traces.
+	for _, name := range []string{"go122-gc-stress", "go122-annotations-stress"} {
+		tr, _, err := testtrace.ParseFile(filepath.Join("testdata/tests", name+".test"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		idx, err := trace.BuildIndex(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if len(idx.Generations) != 1 {
+			t.Fatalf("%s: got %d generations, want 1", name, len(idx.Generations))
+		}
+
+		// The index spans all the events in the trace, apart from the
+		// synthetic sync event at the end.
+		r, err := trace.NewReader(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var first, last trace.Time
+		for {
+			ev, err := r.ReadEvent()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ev.Kind() == trace.EventSync {
+				continue
+			}
+			if first == 0 {
+				first = ev.Time()
+			}
+			last = ev.Time()
+		}
+		g := idx.Generations[0]
+		if first < g.Start || last < g.End || first-g.Start > 100 || last-g.End > 100 {
+			t.Errorf("%s: generation spans %v to %v, but events span %v to %v", name, g.Start, g.End, first, last)
+		}
+	}
+}
+
+func TestIndexDamaged(t *testing.T) {
+	data := generationsTrace(t, 3)
+	idx, err := trace.BuildIndex(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Cutting the last generation short leaves the ones before it intact.
+	last := idx.Generations[2]
+	cut := data[:last.Offset+last.Length-1]
+	got, err := trace.BuildIndex(bytes.NewReader(cut), int64(len(cut)))
+	if err == nil {
+		t.Fatal("indexed a damaged trace without error")
+	}
+	if got == nil || !reflect.DeepEqual(got.Generations, idx.Generations[:2]) {
+		t.Fatalf("got index %+v for damaged trace, want the first 2 generations of %+v", got, idx)
+	}
+	ir, err := trace.NewIndexedReader(bytes.NewReader(cut), int64(len(cut)), got)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Without an index, NewIndexedReader builds the same partial one.
+	built, err := trace.NewIndexedReader(bytes.NewReader(cut), int64(len(cut)), nil)
+	if err == nil {
+		t.Fatal("opened a damaged trace without error")
+	}
+	if built == nil || !reflect.DeepEqual(built.Index().Generations, got.Generations) {
+		t.Fatalf("got IndexedReader %+v for damaged trace, want one with index %+v", built, got)
+	}
+
+	r, err := ir.SeekGeneration(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var logs []string
+	for {
+		ev, err := r.ReadEvent()
+		if err == io.EOF {
+			t.Fatal("read a damaged trace without error")
+		}
+		if err != nil {
+			break
+		}
+		if ev.Kind() == trace.EventLog {
+			logs = append(logs, ev.Log().Message)
+		}
+	}
+	if !reflect.DeepEqual(logs, []string{"2"}) {
+		t.Errorf("got logs %q before the damage, want [\"2\"]", logs)
+	}
+}
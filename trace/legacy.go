@@ -0,0 +1,84 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.21
+
+package trace
+
+import (
+	"errors"
+	"io"
+
+	"golang.org/x/exp/trace/internal/event/go122"
+	"golang.org/x/exp/trace/internal/oldtrace"
+)
+
+// ErrNotLegacy is returned by ConvertLegacy for traces that are already in
+// the Go 1.22 format or later.
+var ErrNotLegacy = errors.New("trace is not in a format from before Go 1.22")
+
+// legacyThreadBase is the first ID ConvertLegacy gives to threads the old
+// format doesn't identify. Real thread IDs in old traces are much smaller.
+const legacyThreadBase ThreadID = 1 << 40
+
+// ConvertLegacy reads a trace produced by Go 1.11 through Go 1.21 from r and
+// writes it to w in the Go 1.22 format, using a Writer. The events are those
+// a Reader produces for the old trace, so the result reads back the same way,
+// and tools that only understand the new format can open it.
+//
+// The whole trace is written as a single generation. The old format doesn't
+// record the thread of some events, such as those of the garbage collector,
+// network poller, and timers, or of goroutines that were already in a system
+// call when tracing started. ConvertLegacy attributes them to the thread of
+// the goroutine they concern if it's running, and otherwise to synthetic
+// threads with IDs of 1<<40 and up.
+func ConvertLegacy(w io.Writer, r io.Reader) error {
+	tr, err := NewReader(r)
+	if err != nil {
+		return err
+	}
+	if tr.go121Events == nil {
+		return ErrNotLegacy
+	}
+	tw, err := NewWriter(w)
+	if err != nil {
+		return err
+	}
+	next := legacyThreadBase
+	for {
+		ev, err := tr.ReadEvent()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		// The converted events carry the context of the old event, which
+		// for events like GoStart and ProcStart is the context after the
+		// event, and which may be on one of the old format's fake procs.
+		// Place the event on a thread instead, and take the goroutine and
+		// proc from the state the Writer has tracked for that thread.
+		m := ev.ctx.M
+		switch {
+		case ev.base.typ == go122.EvGoStatus && go122.GoStatus(ev.base.args[2]) == go122.GoSyscall && ThreadID(ev.base.args[1]) == NoThread:
+			// Goroutines in a system call are bound to a thread.
+			next++
+			m = next
+			ev.base.args[1] = uint64(m)
+		case m == NoThread || ev.ctx.P >= oldtrace.FakeP:
+			m = legacyThreadBase
+			if gs, ok := tw.gs[ev.ctx.G]; ok && gs.m != NoThread {
+				m = gs.m
+			}
+		}
+		ms := tw.mState(m)
+		ev.ctx = schedCtx{M: m, P: ms.p, G: ms.g}
+
+		if err := tw.WriteEvent(ev); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
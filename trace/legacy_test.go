@@ -0,0 +1,103 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.21
+
+package trace_test
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"golang.org/x/exp/trace"
+	"golang.org/x/exp/trace/internal/testtrace"
+)
+
+// legacyTraces returns the paths of the old-format traces in the Go
+// distribution's testdata, since this repository doesn't check them in.
+func legacyTraces(t *testing.T) []string {
+	for _, dir := range []string{
+		"src/internal/trace/internal/tracev1/testdata",
+		"src/internal/trace/internal/oldtrace/testdata",
+		"src/internal/trace/v2/internal/oldtrace/testdata",
+	} {
+		matches, err := filepath.Glob(filepath.Join(runtime.GOROOT(), dir, "*_good"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(matches) != 0 {
+			return matches
+		}
+	}
+	t.Skip("no old-format traces found in GOROOT")
+	return nil
+}
+
+func TestConvertLegacy(t *testing.T) {
+	for _, path := range legacyTraces(t) {
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var out bytes.Buffer
+			if err := trace.ConvertLegacy(&out, bytes.NewReader(data)); err != nil {
+				t.Fatal(err)
+			}
+
+			// The converted trace must be valid, and have the same events
+			// as the original, apart from the final sync event.
+			old, err := trace.NewReader(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			r, err := trace.NewReader(&out)
+			if err != nil {
+				t.Fatal(err)
+			}
+			v := testtrace.NewValidator()
+			counts := make(map[trace.EventKind]int)
+			for {
+				ev, err := old.ReadEvent()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				counts[ev.Kind()]++
+			}
+			for {
+				ev, err := r.ReadEvent()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := v.Event(ev); err != nil {
+					t.Fatal(err)
+				}
+				counts[ev.Kind()]--
+			}
+			counts[trace.EventSync]++
+			for kind, n := range counts {
+				if n != 0 {
+					t.Errorf("converted trace has %d fewer %v events", n, kind)
+				}
+			}
+		})
+	}
+}
+
+func TestConvertLegacyNew(t *testing.T) {
+	data := generationsTrace(t, 1)
+	if err := trace.ConvertLegacy(io.Discard, bytes.NewReader(data)); err != trace.ErrNotLegacy {
+		t.Errorf("got error %v for a new trace, want %v", err, trace.ErrNotLegacy)
+	}
+}
@@ -0,0 +1,337 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.21
+
+// Package otlp converts the user annotations in an execution trace to
+// OpenTelemetry spans, in the OTLP JSON format that OpenTelemetry collectors
+// can import, for example with the otlpjsonfile receiver.
+//
+// Tasks and regions become spans, and log messages become events on the
+// innermost span that contains them, as described by [query.Span]. A task's
+// parent task becomes its span's parent, and each tree of tasks and regions
+// is a separate trace. Log messages outside any task or region become spans
+// of their own, with no duration.
+//
+// Spans have the following attributes, where they're known:
+//
+//   - go.goroutine: the goroutine the span is on
+//   - go.task.id: the ID of the task the span is or belongs to
+//   - code.function, code.filepath, code.lineno: where the span began
+//   - go.truncated: true if the span began before the trace or ended after
+//     it, in which case it's cut off at the first or last annotation in
+//     the trace
+//
+// Span events for log messages have the category of the log message as their
+// name, or "log" if it's empty, and a "message" attribute.
+package otlp
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"strconv"
+	"time"
+
+	"golang.org/x/exp/trace"
+	"golang.org/x/exp/trace/query"
+)
+
+// Options configures the conversion.
+type Options struct {
+	// ServiceName is the service.name attribute of the spans' resource. The
+	// default is "unknown_service".
+	ServiceName string
+
+	// Start is the wall-clock time of the earliest annotation in the trace.
+	// Execution traces only have monotonic timestamps, so this places them
+	// in real time.
+	Start time.Time
+
+	// End is the wall-clock time of the latest annotation in the trace. It
+	// only applies if Start is zero. If both are zero, trace timestamps are
+	// used as if they were nanoseconds since the Unix epoch.
+	End time.Time
+
+	// TraceIDPrefix is the first 8 bytes of the trace ID of every trace in
+	// the output. The rest is a counter. If it's zero, it's chosen at random,
+	// so that the output of different conversions doesn't collide.
+	TraceIDPrefix [8]byte
+}
+
+// Convert reads the user annotations in the rest of the trace from r and
+// writes them to w as OTLP JSON.
+func Convert(w io.Writer, r *trace.Reader, opts Options) error {
+	spans, err := query.ReadSpans(r)
+	if err != nil {
+		return err
+	}
+	return WriteSpans(w, spans, opts)
+}
+
+// WriteSpans writes spans, as returned by [query.ReadSpans], to w as OTLP
+// JSON. Spans whose parents aren't in spans are treated as roots.
+func WriteSpans(w io.Writer, spans []*query.Span, opts Options) error {
+	c := &converter{
+		opts: opts,
+		ids:  make(map[*query.Span]int),
+	}
+	if c.opts.ServiceName == "" {
+		c.opts.ServiceName = "unknown_service"
+	}
+	if c.opts.TraceIDPrefix == [8]byte{} {
+		if _, err := rand.Read(c.opts.TraceIDPrefix[:]); err != nil {
+			return err
+		}
+	}
+	c.bounds(spans)
+	for i, s := range spans {
+		c.ids[s] = i + 1
+	}
+	for _, s := range spans {
+		if s.Kind == query.KindLog && c.ids[s.Parent] != 0 {
+			// Part of its parent span.
+			continue
+		}
+		c.spans = append(c.spans, c.span(s))
+	}
+
+	data := tracesData{ResourceSpans: []resourceSpans{{
+		Resource: resource{Attributes: []keyValue{stringAttr("service.name", c.opts.ServiceName)}},
+		ScopeSpans: []scopeSpans{{
+			Scope: scope{Name: "golang.org/x/exp/trace/otlp"},
+			Spans: c.spans,
+		}},
+	}}}
+	if data.ResourceSpans[0].ScopeSpans[0].Spans == nil {
+		data.ResourceSpans[0].ScopeSpans[0].Spans = []span{}
+	}
+	bw := bufio.NewWriter(w)
+	if err := json.NewEncoder(bw).Encode(data); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+type converter struct {
+	opts Options
+
+	// ids are the span IDs of each span, as an index starting from 1.
+	ids map[*query.Span]int
+
+	// traces are the trace IDs of root spans, as an index starting from 1.
+	traces map[*query.Span]int
+
+	// first and last are the earliest and latest times in the spans.
+	first, last trace.Time
+
+	spans []span
+}
+
+// bounds finds the earliest and latest times in spans.
+func (c *converter) bounds(spans []*query.Span) {
+	for _, s := range spans {
+		for _, t := range []trace.Time{s.Start, s.End} {
+			if t == 0 {
+				continue
+			}
+			if c.first == 0 || t < c.first {
+				c.first = t
+			}
+			if t > c.last {
+				c.last = t
+			}
+		}
+	}
+}
+
+// wallTime returns the wall-clock time of t as nanoseconds since the Unix
+// epoch.
+func (c *converter) wallTime(t trace.Time) string {
+	var ns int64
+	switch {
+	case !c.opts.Start.IsZero():
+		ns = c.opts.Start.UnixNano() + int64(t-c.first)
+	case !c.opts.End.IsZero():
+		ns = c.opts.End.UnixNano() - int64(c.last-t)
+	default:
+		ns = int64(t)
+	}
+	return strconv.FormatInt(ns, 10)
+}
+
+// root returns the root of the tree of spans s is in.
+func (c *converter) root(s *query.Span) *query.Span {
+	for s.Parent != nil && c.ids[s.Parent] != 0 {
+		s = s.Parent
+	}
+	return s
+}
+
+func (c *converter) traceID(s *query.Span) string {
+	if c.traces == nil {
+		c.traces = make(map[*query.Span]int)
+	}
+	root := c.root(s)
+	n, ok := c.traces[root]
+	if !ok {
+		n = len(c.traces) + 1
+		c.traces[root] = n
+	}
+	var id [16]byte
+	copy(id[:8], c.opts.TraceIDPrefix[:])
+	binary.BigEndian.PutUint64(id[8:], uint64(n))
+	return hex.EncodeToString(id[:])
+}
+
+func (c *converter) spanID(s *query.Span) string {
+	n, ok := c.ids[s]
+	if !ok {
+		return ""
+	}
+	var id [8]byte
+	binary.BigEndian.PutUint64(id[:], uint64(n))
+	return hex.EncodeToString(id[:])
+}
+
+func (c *converter) span(s *query.Span) span {
+	start, end := s.Start, s.End
+	truncated := false
+	if start == 0 {
+		start, truncated = c.first, true
+	}
+	if end == 0 {
+		end, truncated = c.last, true
+	}
+	name := s.Type
+	if name == "" {
+		name = s.Kind.String()
+	}
+	out := span{
+		TraceID:           c.traceID(s),
+		SpanID:            c.spanID(s),
+		Name:              name,
+		Kind:              spanKindInternal,
+		StartTimeUnixNano: c.wallTime(start),
+		EndTimeUnixNano:   c.wallTime(end),
+	}
+	if s.Parent != nil {
+		out.ParentSpanID = c.spanID(s.Parent)
+	}
+	if s.Goroutine != trace.NoGoroutine {
+		out.Attributes = append(out.Attributes, intAttr("go.goroutine", int64(s.Goroutine)))
+	}
+	if s.Task != trace.NoTask {
+		out.Attributes = append(out.Attributes, intAttr("go.task.id", int64(s.Task)))
+	}
+	out.Attributes = append(out.Attributes, codeAttrs(s.StartStack)...)
+	if truncated {
+		out.Attributes = append(out.Attributes, keyValue{Key: "go.truncated", Value: anyValue{BoolValue: &truncated}})
+	}
+	if s.Kind == query.KindLog {
+		out.Events = append(out.Events, c.event(s))
+	}
+	for _, child := range s.Children {
+		if child.Kind == query.KindLog && c.ids[child] != 0 {
+			out.Events = append(out.Events, c.event(child))
+		}
+	}
+	return out
+}
+
+func (c *converter) event(s *query.Span) event {
+	name := s.Type
+	if name == "" {
+		name = "log"
+	}
+	return event{
+		TimeUnixNano: c.wallTime(s.Start),
+		Name:         name,
+		Attributes:   []keyValue{stringAttr("message", s.Message)},
+	}
+}
+
+// codeAttrs returns the code.* attributes for the top frame of stk.
+func codeAttrs(stk trace.Stack) []keyValue {
+	var attrs []keyValue
+	stk.Frames(func(f trace.StackFrame) bool {
+		attrs = []keyValue{
+			stringAttr("code.function", f.Func),
+			stringAttr("code.filepath", f.File),
+			intAttr("code.lineno", int64(f.Line)),
+		}
+		return false
+	})
+	return attrs
+}
+
+// The following types are the parts of the OTLP JSON encoding of
+// ExportTraceServiceRequest that the package uses.
+
+type tracesData struct {
+	ResourceSpans []resourceSpans `json:"resourceSpans"`
+}
+
+type resourceSpans struct {
+	Resource   resource     `json:"resource"`
+	ScopeSpans []scopeSpans `json:"scopeSpans"`
+}
+
+type resource struct {
+	Attributes []keyValue `json:"attributes"`
+}
+
+type scopeSpans struct {
+	Scope scope  `json:"scope"`
+	Spans []span `json:"spans"`
+}
+
+type scope struct {
+	Name string `json:"name"`
+}
+
+const spanKindInternal = 1
+
+type span struct {
+	TraceID           string     `json:"traceId"`
+	SpanID            string     `json:"spanId"`
+	ParentSpanID      string     `json:"parentSpanId,omitempty"`
+	Name              string     `json:"name"`
+	Kind              int        `json:"kind"`
+	StartTimeUnixNano string     `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string     `json:"endTimeUnixNano"`
+	Attributes        []keyValue `json:"attributes,omitempty"`
+	Events            []event    `json:"events,omitempty"`
+}
+
+type event struct {
+	TimeUnixNano string     `json:"timeUnixNano"`
+	Name         string     `json:"name"`
+	Attributes   []keyValue `json:"attributes,omitempty"`
+}
+
+type keyValue struct {
+	Key   string   `json:"key"`
+	Value anyValue `json:"value"`
+}
+
+// anyValue is an attribute value. Exactly one field is set. 64-bit integers
+// are strings in OTLP JSON.
+type anyValue struct {
+	StringValue *string `json:"stringValue,omitempty"`
+	IntValue    *string `json:"intValue,omitempty"`
+	BoolValue   *bool   `json:"boolValue,omitempty"`
+}
+
+func stringAttr(key, value string) keyValue {
+	return keyValue{Key: key, Value: anyValue{StringValue: &value}}
+}
+
+func intAttr(key string, value int64) keyValue {
+	s := strconv.FormatInt(value, 10)
+	return keyValue{Key: key, Value: anyValue{IntValue: &s}}
+}
@@ -0,0 +1,199 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.21
+
+package otlp_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/exp/trace"
+	"golang.org/x/exp/trace/otlp"
+	"golang.org/x/exp/trace/tracetest"
+)
+
+type jsonSpan struct {
+	TraceID           string `json:"traceId"`
+	SpanID            string `json:"spanId"`
+	ParentSpanID      string `json:"parentSpanId"`
+	Name              string `json:"name"`
+	Kind              int    `json:"kind"`
+	StartTimeUnixNano string `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string `json:"endTimeUnixNano"`
+	Attributes        []struct {
+		Key   string         `json:"key"`
+		Value map[string]any `json:"value"`
+	} `json:"attributes"`
+	Events []struct {
+		TimeUnixNano string `json:"timeUnixNano"`
+		Name         string `json:"name"`
+	} `json:"events"`
+}
+
+func (s *jsonSpan) attr(key string) any {
+	for _, a := range s.Attributes {
+		if a.Key == key {
+			for _, v := range a.Value {
+				return v
+			}
+		}
+	}
+	return nil
+}
+
+func TestConvert(t *testing.T) {
+	stk := []trace.StackFrame{{PC: 0x1000, Func: "main.handle", File: "main.go", Line: 10}}
+
+	tt := tracetest.NewTrace()
+	b := tt.Generation().Batch(1)
+	b.ProcStatus(1, 0, trace.ProcRunning)
+	b.GoStatus(1, 1, trace.GoRunning)
+	b.RegionEnd(5, trace.BackgroundTask, "startup", nil)
+	b.TaskBegin(10, 1, trace.BackgroundTask, "request", stk)
+	b.TaskBegin(20, 2, 1, "backend", nil)
+	b.RegionBegin(30, 2, "db.query", nil)
+	b.Log(40, 2, "sql", "SELECT 1", nil)
+	b.RegionEnd(50, 2, "db.query", nil)
+	b.TaskEnd(60, 2, nil)
+	b.TaskEnd(70, 1, nil)
+	b.Log(80, trace.BackgroundTask, "", "done", nil)
+
+	r, err := trace.NewReader(bytes.NewReader(tt.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	opts := otlp.Options{ServiceName: "test", Start: start}
+	if err := otlp.Convert(&buf, r, opts); err != nil {
+		t.Fatal(err)
+	}
+	var out struct {
+		ResourceSpans []struct {
+			Resource struct {
+				Attributes []struct {
+					Key   string `json:"key"`
+					Value struct {
+						StringValue string `json:"stringValue"`
+					} `json:"value"`
+				} `json:"attributes"`
+			} `json:"resource"`
+			ScopeSpans []struct {
+				Spans []jsonSpan `json:"spans"`
+			} `json:"scopeSpans"`
+		} `json:"resourceSpans"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &out); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, buf.Bytes())
+	}
+	if len(out.ResourceSpans) != 1 || len(out.ResourceSpans[0].ScopeSpans) != 1 {
+		t.Fatalf("want one resource and one scope, got:\n%s", buf.Bytes())
+	}
+	if attrs := out.ResourceSpans[0].Resource.Attributes; len(attrs) != 1 || attrs[0].Key != "service.name" || attrs[0].Value.StringValue != "test" {
+		t.Errorf("got resource attributes %+v, want service.name=test", attrs)
+	}
+
+	spans := out.ResourceSpans[0].ScopeSpans[0].Spans
+	var names []string
+	byName := make(map[string]*jsonSpan)
+	for i := range spans {
+		s := &spans[i]
+		names = append(names, s.Name)
+		byName[s.Name] = s
+		if len(s.TraceID) != 32 || len(s.SpanID) != 16 {
+			t.Errorf("span %s has bad IDs %q, %q", s.Name, s.TraceID, s.SpanID)
+		}
+		if s.Kind != 1 {
+			t.Errorf("span %s has kind %d, want internal", s.Name, s.Kind)
+		}
+	}
+	if want := []string{"startup", "request", "backend", "db.query", "log"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("got spans %q, want %q", names, want)
+	}
+
+	req, backend, query := byName["request"], byName["backend"], byName["db.query"]
+	if req.ParentSpanID != "" || backend.ParentSpanID != req.SpanID || query.ParentSpanID != backend.SpanID {
+		t.Errorf("spans aren't nested request > backend > db.query")
+	}
+	if backend.TraceID != req.TraceID || query.TraceID != req.TraceID {
+		t.Errorf("task tree isn't a single trace")
+	}
+	if byName["startup"].TraceID == req.TraceID || byName["log"].TraceID == req.TraceID {
+		t.Errorf("spans outside the task share its trace")
+	}
+	if !strings.HasPrefix(req.TraceID, byName["startup"].TraceID[:16]) {
+		t.Errorf("traces in the same output have different prefixes")
+	}
+
+	// Times are relative to the first annotation, at 5ns.
+	if got, want := query.StartTimeUnixNano, itoa(start.UnixNano()+25); got != want {
+		t.Errorf("got db.query start %s, want %s", got, want)
+	}
+	if got, want := query.EndTimeUnixNano, itoa(start.UnixNano()+45); got != want {
+		t.Errorf("got db.query end %s, want %s", got, want)
+	}
+	if len(query.Events) != 1 || query.Events[0].Name != "sql" || query.Events[0].TimeUnixNano != itoa(start.UnixNano()+35) {
+		t.Errorf("got db.query events %+v, want the sql log message", query.Events)
+	}
+	if len(byName["log"].Events) != 1 {
+		t.Errorf("got %d events on the log span, want 1", len(byName["log"].Events))
+	}
+
+	if got := req.attr("go.goroutine"); got != "1" {
+		t.Errorf("got go.goroutine %v, want 1", got)
+	}
+	if got := backend.attr("go.task.id"); got != "2" {
+		t.Errorf("got go.task.id %v, want 2", got)
+	}
+	if got := req.attr("code.function"); got != "main.handle" {
+		t.Errorf("got code.function %v, want main.handle", got)
+	}
+	if got := byName["startup"].attr("go.truncated"); got != true {
+		t.Errorf("got go.truncated %v for startup, want true", got)
+	}
+	if got := req.attr("go.truncated"); got != nil {
+		t.Errorf("got go.truncated %v for request, want none", got)
+	}
+}
+
+func TestConvertEnd(t *testing.T) {
+	tt := tracetest.NewTrace()
+	b := tt.Generation().Batch(1)
+	b.ProcStatus(1, 0, trace.ProcRunning)
+	b.GoStatus(1, 1, trace.GoRunning)
+	b.RegionBegin(100, trace.BackgroundTask, "work", nil)
+	b.RegionEnd(300, trace.BackgroundTask, "work", nil)
+
+	r, err := trace.NewReader(bytes.NewReader(tt.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	end := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	prefix := [8]byte{1, 2, 3, 4, 5, 6, 7, 8}
+	if err := otlp.Convert(&buf, r, otlp.Options{End: end, TraceIDPrefix: prefix}); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`"stringValue":"unknown_service"`,
+		`"traceId":"01020304050607080000000000000001"`,
+		`"startTimeUnixNano":"` + itoa(end.UnixNano()-200) + `"`,
+		`"endTimeUnixNano":"` + itoa(end.UnixNano()) + `"`,
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("output doesn't contain %s:\n%s", want, buf.Bytes())
+		}
+	}
+}
+
+func itoa(n int64) string {
+	return strconv.FormatInt(n, 10)
+}
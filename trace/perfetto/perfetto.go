@@ -0,0 +1,390 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.21
+
+// Package perfetto converts execution traces to the Chrome Trace Event JSON
+// format, which can be loaded into the Perfetto UI (https://ui.perfetto.dev)
+// or chrome://tracing.
+//
+// The output is organized into the following processes, each with its own
+// tracks:
+//
+//   - Runtime has a track for global activity like GC cycles, and a counter
+//     track for each metric, like the heap size and GOMAXPROCS.
+//   - Procs has a track per proc, showing the goroutines that ran on it as
+//     slices, along with proc-wide activity like sweeping.
+//   - Threads has a track per thread, showing the system calls it made.
+//   - Goroutines has a track per goroutine, showing its user regions as
+//     nested slices, its logs, and activity like mark assists.
+//   - Tasks shows user tasks as async slices.
+//
+// Times are relative to the first event in the trace.
+package perfetto
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+
+	"golang.org/x/exp/trace"
+)
+
+// Process IDs for the groups of tracks in the output.
+const (
+	pidRuntime = iota
+	pidProcs
+	pidThreads
+	pidGoroutines
+	pidTasks
+)
+
+var processNames = [...]string{
+	pidRuntime:    "Runtime",
+	pidProcs:      "Procs",
+	pidThreads:    "Threads",
+	pidGoroutines: "Goroutines",
+	pidTasks:      "Tasks",
+}
+
+// Convert reads the rest of the trace from r and writes it to w in
+// the Chrome Trace Event JSON format.
+func Convert(w io.Writer, r *trace.Reader) error {
+	cw := NewWriter(w)
+	for {
+		ev, err := r.ReadEvent()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if err := cw.WriteEvent(ev); err != nil {
+			return err
+		}
+	}
+	return cw.Close()
+}
+
+// Writer writes trace events to an underlying writer in the Chrome
+// Trace Event JSON format.
+//
+// Slices are written when they end, so the output isn't sorted by time.
+// Slices that are still in progress when the writer is closed end at the
+// time of the last event.
+type Writer struct {
+	w   *bufio.Writer
+	err error
+	n   int // number of JSON events written.
+
+	haveStart  bool
+	start, end trace.Time
+
+	tracks  map[track]bool
+	names   map[trace.GoID]string
+	running map[trace.GoID]slice
+	syscall map[trace.GoID]slice
+	regions map[trace.GoID][]slice
+	ranges  map[rangeKey]slice
+	tasks   map[trace.TaskID]string
+}
+
+// track identifies a track in the output.
+type track struct {
+	pid, tid uint64
+}
+
+// slice is a slice in progress.
+type slice struct {
+	name  string
+	start trace.Time
+	track track
+}
+
+type rangeKey struct {
+	scope trace.ResourceID
+	name  string
+}
+
+// jsonEvent is an event in the Trace Event format.
+type jsonEvent struct {
+	Name  string         `json:"name,omitempty"`
+	Cat   string         `json:"cat,omitempty"`
+	Phase string         `json:"ph"`
+	Time  float64        `json:"ts"`
+	Dur   float64        `json:"dur,omitempty"`
+	PID   uint64         `json:"pid"`
+	TID   uint64         `json:"tid"`
+	ID    uint64         `json:"id,omitempty"`
+	Scope string         `json:"s,omitempty"`
+	Args  map[string]any `json:"args,omitempty"`
+}
+
+// NewWriter creates a new Writer that writes to w.
+func NewWriter(w io.Writer) *Writer {
+	cw := &Writer{
+		w:       bufio.NewWriter(w),
+		tracks:  make(map[track]bool),
+		names:   make(map[trace.GoID]string),
+		running: make(map[trace.GoID]slice),
+		syscall: make(map[trace.GoID]slice),
+		regions: make(map[trace.GoID][]slice),
+		ranges:  make(map[rangeKey]slice),
+		tasks:   make(map[trace.TaskID]string),
+	}
+	_, cw.err = cw.w.WriteString(`{"displayTimeUnit":"ns","traceEvents":[`)
+	for pid, name := range processNames {
+		cw.emit(jsonEvent{Name: "process_name", Phase: "M", PID: uint64(pid), Args: map[string]any{"name": name}})
+		cw.emit(jsonEvent{Name: "process_sort_index", Phase: "M", PID: uint64(pid), Args: map[string]any{"sort_index": pid}})
+	}
+	return cw
+}
+
+// WriteEvent converts an event and writes it out.
+func (w *Writer) WriteEvent(ev trace.Event) error {
+	if w.err != nil {
+		return w.err
+	}
+	t := ev.Time()
+	if ev.Kind() != trace.EventSync {
+		if !w.haveStart {
+			w.haveStart = true
+			w.start = t
+		}
+		w.end = t
+	}
+
+	switch ev.Kind() {
+	case trace.EventStateTransition:
+		st := ev.StateTransition()
+		if st.Resource.Kind != trace.ResourceGoroutine {
+			break
+		}
+		id := st.Resource.Goroutine()
+		if _, ok := w.names[id]; !ok {
+			// The outermost frame of the goroutine's stack on creation, or
+			// of its current stack, is the goroutine's entry function.
+			name := ""
+			st.Stack.Frames(func(f trace.StackFrame) bool {
+				name = f.Func
+				return true
+			})
+			w.names[id] = name
+		}
+		from, to := st.Goroutine()
+		if from == to {
+			break
+		}
+		if s, ok := w.running[id]; ok {
+			delete(w.running, id)
+			w.emitSlice(s, t, map[string]any{"goroutine": uint64(id), "end state": to.String(), "reason": st.Reason})
+		}
+		if s, ok := w.syscall[id]; ok {
+			delete(w.syscall, id)
+			w.emitSlice(s, t, map[string]any{"goroutine": uint64(id)})
+		}
+		switch to {
+		case trace.GoRunning:
+			if p := ev.Proc(); p != trace.NoProc {
+				w.running[id] = slice{name: w.goroutineName(id), start: t, track: w.track(pidProcs, uint64(p), fmt.Sprintf("Proc %d", p))}
+			}
+		case trace.GoSyscall:
+			if m := ev.Thread(); m != trace.NoThread {
+				w.syscall[id] = slice{name: "syscall", start: t, track: w.track(pidThreads, uint64(m), fmt.Sprintf("Thread %d", m))}
+			}
+		case trace.GoNotExist:
+			// Regions end when their goroutine exits.
+			rs := w.regions[id]
+			for i := len(rs) - 1; i >= 0; i-- {
+				w.emitSlice(rs[i], t, nil)
+			}
+			delete(w.regions, id)
+		}
+	case trace.EventRegionBegin:
+		id := ev.Goroutine()
+		w.regions[id] = append(w.regions[id], slice{name: ev.Region().Type, start: t, track: w.goroutineTrack(id)})
+	case trace.EventRegionEnd:
+		id := ev.Goroutine()
+		typ := ev.Region().Type
+		s := slice{name: typ, start: w.start, track: w.goroutineTrack(id)}
+		if n := len(w.regions[id]); n != 0 && w.regions[id][n-1].name == typ {
+			s = w.regions[id][n-1]
+			w.regions[id] = w.regions[id][:n-1]
+		}
+		w.emitSlice(s, t, nil)
+	case trace.EventTaskBegin:
+		task := ev.Task()
+		w.tasks[task.ID] = task.Type
+		var args map[string]any
+		if task.Parent != trace.NoTask {
+			args = map[string]any{"parent": uint64(task.Parent)}
+		}
+		w.emit(jsonEvent{Name: task.Type, Cat: "task", Phase: "b", Time: w.ts(t), PID: pidTasks, ID: uint64(task.ID), Args: args})
+	case trace.EventTaskEnd:
+		task := ev.Task()
+		typ, ok := w.tasks[task.ID]
+		if !ok {
+			// The task began before the trace started.
+			typ = task.Type
+			w.emit(jsonEvent{Name: typ, Cat: "task", Phase: "b", Time: w.ts(w.start), PID: pidTasks, ID: uint64(task.ID)})
+		}
+		delete(w.tasks, task.ID)
+		w.emit(jsonEvent{Name: typ, Cat: "task", Phase: "e", Time: w.ts(t), PID: pidTasks, ID: uint64(task.ID)})
+	case trace.EventRangeBegin:
+		r := ev.Range()
+		w.ranges[rangeKey{r.Scope, r.Name}] = slice{name: r.Name, start: t, track: w.scopeTrack(r.Scope)}
+	case trace.EventRangeActive:
+		// The Reader reports the ranges in progress at the start of every
+		// generation, so only the first report says when the range began.
+		r := ev.Range()
+		k := rangeKey{r.Scope, r.Name}
+		if _, ok := w.ranges[k]; !ok {
+			w.ranges[k] = slice{name: r.Name, start: t, track: w.scopeTrack(r.Scope)}
+		}
+	case trace.EventRangeEnd:
+		r := ev.Range()
+		k := rangeKey{r.Scope, r.Name}
+		s, ok := w.ranges[k]
+		if !ok {
+			s = slice{name: r.Name, start: w.start, track: w.scopeTrack(r.Scope)}
+		}
+		delete(w.ranges, k)
+		w.emitSlice(s, t, nil)
+	case trace.EventMetric:
+		m := ev.Metric()
+		if m.Value.Kind() != trace.ValueUint64 {
+			break
+		}
+		w.emit(jsonEvent{Name: m.Name, Phase: "C", Time: w.ts(t), PID: pidRuntime, Args: map[string]any{"value": m.Value.Uint64()}})
+	case trace.EventLog:
+		l := ev.Log()
+		w.emit(jsonEvent{Name: l.Message, Cat: l.Category, Phase: "i", Scope: "t", Time: w.ts(t), PID: pidGoroutines, TID: w.goroutineTrack(ev.Goroutine()).tid})
+	}
+	return w.err
+}
+
+// Close ends all the slices still in progress and finishes the JSON output.
+// It doesn't close the underlying writer.
+func (w *Writer) Close() error {
+	if w.err != nil {
+		return w.err
+	}
+	var open []slice
+	for _, s := range w.running {
+		open = append(open, s)
+	}
+	for _, s := range w.syscall {
+		open = append(open, s)
+	}
+	for _, rs := range w.regions {
+		open = append(open, rs...)
+	}
+	for _, s := range w.ranges {
+		open = append(open, s)
+	}
+	slices.SortFunc(open, func(a, b slice) int {
+		if c := cmp.Compare(a.start, b.start); c != 0 {
+			return c
+		}
+		if c := cmp.Compare(a.track.pid, b.track.pid); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.track.tid, b.track.tid)
+	})
+	for _, s := range open {
+		w.emitSlice(s, w.end, nil)
+	}
+	var tasks []trace.TaskID
+	for id := range w.tasks {
+		tasks = append(tasks, id)
+	}
+	slices.Sort(tasks)
+	for _, id := range tasks {
+		w.emit(jsonEvent{Name: w.tasks[id], Cat: "task", Phase: "e", Time: w.ts(w.end), PID: pidTasks, ID: uint64(id)})
+	}
+	if w.err == nil {
+		_, w.err = w.w.WriteString("\n]}\n")
+	}
+	if w.err == nil {
+		w.err = w.w.Flush()
+	}
+	if w.err != nil {
+		return w.err
+	}
+	w.err = fmt.Errorf("perfetto: writer is closed")
+	return nil
+}
+
+func (w *Writer) goroutineName(id trace.GoID) string {
+	if name := w.names[id]; name != "" {
+		return fmt.Sprintf("G%d %s", id, name)
+	}
+	return fmt.Sprintf("G%d", id)
+}
+
+func (w *Writer) goroutineTrack(id trace.GoID) track {
+	return w.track(pidGoroutines, uint64(id), w.goroutineName(id))
+}
+
+// scopeTrack returns the track for ranges with the given scope.
+func (w *Writer) scopeTrack(scope trace.ResourceID) track {
+	switch scope.Kind {
+	case trace.ResourceProc:
+		p := scope.Proc()
+		return w.track(pidProcs, uint64(p), fmt.Sprintf("Proc %d", p))
+	case trace.ResourceGoroutine:
+		return w.goroutineTrack(scope.Goroutine())
+	case trace.ResourceThread:
+		m := scope.Thread()
+		return w.track(pidThreads, uint64(m), fmt.Sprintf("Thread %d", m))
+	}
+	return w.track(pidRuntime, 0, "GC")
+}
+
+// track returns the track with the given IDs, naming it if it's new.
+func (w *Writer) track(pid, tid uint64, name string) track {
+	t := track{pid, tid}
+	if !w.tracks[t] {
+		w.tracks[t] = true
+		w.emit(jsonEvent{Name: "thread_name", Phase: "M", PID: pid, TID: tid, Args: map[string]any{"name": name}})
+	}
+	return t
+}
+
+// ts converts a trace timestamp into microseconds since the start of the trace.
+func (w *Writer) ts(t trace.Time) float64 {
+	return float64(t-w.start) / 1e3
+}
+
+func (w *Writer) emitSlice(s slice, end trace.Time, args map[string]any) {
+	w.emit(jsonEvent{
+		Name:  s.name,
+		Phase: "X",
+		Time:  w.ts(s.start),
+		Dur:   float64(end-s.start) / 1e3,
+		PID:   s.track.pid,
+		TID:   s.track.tid,
+		Args:  args,
+	})
+}
+
+func (w *Writer) emit(ev jsonEvent) {
+	if w.err != nil {
+		return
+	}
+	b, err := json.Marshal(ev)
+	if err != nil {
+		w.err = err
+		return
+	}
+	if w.n != 0 {
+		w.w.WriteByte(',')
+	}
+	w.w.WriteByte('\n')
+	_, w.err = w.w.Write(b)
+	w.n++
+}
@@ -0,0 +1,183 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.21
+
+package perfetto_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"golang.org/x/exp/trace"
+	"golang.org/x/exp/trace/internal/testtrace"
+	"golang.org/x/exp/trace/perfetto"
+	"golang.org/x/exp/trace/tracetest"
+)
+
+type jsonEvent struct {
+	Name  string         `json:"name"`
+	Phase string         `json:"ph"`
+	Time  float64        `json:"ts"`
+	Dur   float64        `json:"dur"`
+	PID   uint64         `json:"pid"`
+	TID   uint64         `json:"tid"`
+	ID    uint64         `json:"id"`
+	Args  map[string]any `json:"args"`
+}
+
+func TestConvert(t *testing.T) {
+	matches, err := filepath.Glob("../testdata/tests/*.test")
+	if err != nil {
+		t.Fatalf("failed to glob for tests: %v", err)
+	}
+	for _, testPath := range matches {
+		testPath := testPath
+		t.Run(filepath.Base(testPath), func(t *testing.T) {
+			tr, exp, err := testtrace.ParseFile(testPath)
+			if err != nil {
+				t.Fatalf("failed to parse test file at %s: %v", testPath, err)
+			}
+			if exp.Check(nil) != nil {
+				t.Skip("trace is expected to fail to parse")
+			}
+			r, err := trace.NewReader(tr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var buf bytes.Buffer
+			if err := perfetto.Convert(&buf, r); err != nil {
+				t.Fatal(err)
+			}
+			var out struct {
+				TraceEvents []jsonEvent `json:"traceEvents"`
+			}
+			if err := json.Unmarshal(buf.Bytes(), &out); err != nil {
+				t.Fatalf("invalid JSON: %v", err)
+			}
+			checkEvents(t, out.TraceEvents)
+		})
+	}
+}
+
+func checkEvents(t *testing.T, evs []jsonEvent) {
+	t.Helper()
+
+	type track struct{ pid, tid uint64 }
+	named := make(map[track]bool)
+	running := make(map[track][]jsonEvent)
+	asyncs := make(map[uint64]int)
+	for _, ev := range evs {
+		switch ev.Phase {
+		case "M":
+			if ev.Name == "thread_name" {
+				named[track{ev.PID, ev.TID}] = true
+			}
+		case "X":
+			if ev.Dur < 0 || ev.Time < 0 {
+				t.Errorf("bad slice %+v", ev)
+			}
+			if !named[track{ev.PID, ev.TID}] {
+				t.Errorf("slice %+v on unnamed track", ev)
+			}
+			if _, ok := ev.Args["goroutine"]; ok && ev.Phase == "X" && ev.Name != "syscall" {
+				running[track{ev.PID, ev.TID}] = append(running[track{ev.PID, ev.TID}], ev)
+			}
+		case "b":
+			if p, ok := ev.Args["parent"].(float64); ok && p >= float64(trace.NoTask) {
+				t.Errorf("task %d has parent NoTask", ev.ID)
+			}
+			asyncs[ev.ID]++
+		case "e":
+			if asyncs[ev.ID] == 0 {
+				t.Errorf("async slice %d ended without beginning", ev.ID)
+			}
+			asyncs[ev.ID]--
+		case "C", "i":
+		default:
+			t.Errorf("unexpected phase %q", ev.Phase)
+		}
+	}
+	for id, n := range asyncs {
+		if n != 0 {
+			t.Errorf("async slice %d never ended", id)
+		}
+	}
+	// Only one goroutine at a time runs on each proc.
+	for tr, evs := range running {
+		slices.SortFunc(evs, func(a, b jsonEvent) int {
+			if a.Time < b.Time {
+				return -1
+			} else if a.Time > b.Time {
+				return 1
+			}
+			return 0
+		})
+		for i := 1; i < len(evs); i++ {
+			if prev := evs[i-1]; prev.Time+prev.Dur > evs[i].Time+1e-3 {
+				t.Errorf("overlapping goroutines on track %v: %+v and %+v", tr, prev, evs[i])
+			}
+		}
+	}
+}
+
+func TestConvertGenerations(t *testing.T) {
+	// GC, a sweep, and a mark assist that are all in progress across a
+	// generation boundary.
+	tt := tracetest.NewTrace()
+	b := tt.Generation().Batch(1)
+	b.ProcStatus(1000, 0, trace.ProcRunning)
+	b.GoStatus(1000, 1, trace.GoRunning)
+	b.GCBegin(2000, nil)
+	b.GCSweepBegin(3000, nil)
+	b.GCMarkAssistBegin(4000, nil)
+	b = tt.Generation().Batch(1)
+	b.ProcStatus(10000, 0, trace.ProcRunning)
+	b.GoStatus(10000, 1, trace.GoRunning)
+	b.GCActive(10000)
+	b.GCSweepActive(10000, 0)
+	b.GCMarkAssistActive(10000, 1)
+	b.GCMarkAssistEnd(11000)
+	b.GCSweepEnd(12000, 0, 0)
+	b.GCEnd(13000)
+
+	r, err := trace.NewReader(bytes.NewReader(tt.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := perfetto.Convert(&buf, r); err != nil {
+		t.Fatal(err)
+	}
+	var out struct {
+		TraceEvents []jsonEvent `json:"traceEvents"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &out); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	checkEvents(t, out.TraceEvents)
+
+	// Times are in microseconds since the first event.
+	want := map[string][2]float64{
+		"GC concurrent mark phase": {1, 11},
+		"GC incremental sweep":     {2, 9},
+		"GC mark assist":           {3, 7},
+	}
+	for _, ev := range out.TraceEvents {
+		w, ok := want[ev.Name]
+		if !ok || ev.Phase != "X" {
+			continue
+		}
+		if got := [2]float64{ev.Time, ev.Dur}; got != w {
+			t.Errorf("%s: got start and duration %v, want %v", ev.Name, got, w)
+		}
+		delete(want, ev.Name)
+	}
+	for name := range want {
+		t.Errorf("no slice for %s", name)
+	}
+}
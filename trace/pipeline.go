@@ -0,0 +1,79 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.21
+
+package trace
+
+import (
+	"io"
+	"sync"
+)
+
+// generationPipeline reads generations from a trace and decodes them on
+// separate goroutines, ahead of a ReaderWithOptions' need for them.
+//
+// Reading batches out of the trace is inherently sequential, but it's
+// cheap: a batch's header gives its size. So one goroutine reads the batches
+// of each generation and hands them off to a new goroutine to decode. The
+// results are queued in trace order, and the queue's capacity limits how
+// far ahead of the ReaderWithOptions the pipeline gets.
+type generationPipeline struct {
+	queue    chan chan decodedGeneration
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+func newGenerationPipeline(gens *generationReader, parallelism int) *generationPipeline {
+	p := &generationPipeline{
+		queue: make(chan chan decodedGeneration, parallelism),
+		done:  make(chan struct{}),
+	}
+	go p.run(gens)
+	return p
+}
+
+// run reads generations from gens until it reaches the end of the trace,
+// an error, or the pipeline is stopped.
+func (p *generationPipeline) run(gens *generationReader) {
+	defer close(p.queue)
+	for {
+		batches, skipped, err := gens.next()
+		c := make(chan decodedGeneration, 1)
+		select {
+		case p.queue <- c:
+		case <-p.done:
+			return
+		}
+		go func() {
+			c <- gens.decode(batches, skipped, err)
+		}()
+		if err != nil {
+			// Nothing after an error is meaningful.
+			return
+		}
+	}
+}
+
+// next returns the next generation, waiting for it to be decoded if
+// necessary. At the end of the trace, it returns io.EOF.
+func (p *generationPipeline) next() decodedGeneration {
+	c, ok := <-p.queue
+	if !ok {
+		return decodedGeneration{err: io.EOF}
+	}
+	d := <-c
+	if d.err != nil {
+		p.stop()
+	}
+	return d
+}
+
+// stop stops reading generations. Generations that are already being
+// decoded are discarded once they're done.
+func (p *generationPipeline) stop() {
+	p.stopOnce.Do(func() {
+		close(p.done)
+	})
+}
@@ -0,0 +1,114 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.21
+
+package trace_test
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"golang.org/x/exp/trace"
+	"golang.org/x/exp/trace/internal/testtrace"
+)
+
+// readAll returns the string form of every event read by r, followed by
+// the error that ended reading, if any.
+func readAll(r eventReader) []string {
+	var evs []string
+	for {
+		ev, err := r.ReadEvent()
+		if err == io.EOF {
+			return evs
+		}
+		if err != nil {
+			return append(evs, fmt.Sprintf("error: %v", err))
+		}
+		evs = append(evs, ev.String())
+	}
+}
+
+func TestReaderParallel(t *testing.T) {
+	matches, err := filepath.Glob("./testdata/tests/go122-*.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, testPath := range matches {
+		tr, _, err := testtrace.ParseFile(testPath)
+		if err != nil {
+			t.Fatalf("%s: %v", testPath, err)
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Run(filepath.Base(testPath), func(t *testing.T) {
+			testReaderParallel(t, data)
+		})
+	}
+	t.Run("generations", func(t *testing.T) {
+		testReaderParallel(t, generationsTrace(t, 10))
+	})
+	t.Run("truncated", func(t *testing.T) {
+		data := generationsTrace(t, 10)
+		testReaderParallel(t, data[:len(data)*2/3])
+	})
+}
+
+func testReaderParallel(t *testing.T, data []byte) {
+	r, err := trace.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := readAll(r)
+	for _, parallelism := range []int{0, 1, 4} {
+		r, err := trace.NewReaderWithOptions(bytes.NewReader(data), trace.ReaderOptions{Parallelism: parallelism})
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := readAll(r)
+		if len(got) != len(want) {
+			t.Errorf("parallelism %d: got %d events, want %d", parallelism, len(got), len(want))
+		}
+		for i := 0; i < len(got) && i < len(want); i++ {
+			if got[i] != want[i] {
+				t.Errorf("parallelism %d: event %d differs:\ngot  %s\nwant %s", parallelism, i, got[i], want[i])
+				break
+			}
+		}
+	}
+}
+
+func TestReaderParallelClose(t *testing.T) {
+	data := generationsTrace(t, 10)
+	before := runtime.NumGoroutine()
+	r, err := trace.NewReaderWithOptions(bytes.NewReader(data), trace.ReaderOptions{Parallelism: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.ReadEvent(); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.ReadEvent(); err == nil || err == io.EOF {
+		t.Errorf("ReadEvent after Close returned %v, want an error", err)
+	}
+	// The goroutines decoding ahead exit without waiting for r to be
+	// garbage collected.
+	for deadline := time.Now().Add(5 * time.Second); runtime.NumGoroutine() > before; {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d goroutines still running after Close, want %d", runtime.NumGoroutine(), before)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	runtime.KeepAlive(r)
+}
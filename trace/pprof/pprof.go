@@ -0,0 +1,338 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.21
+
+// Package pprof creates profiles in the format used by the pprof tool from
+// execution traces.
+//
+// Unlike the profiles collected by runtime/pprof, the profiles created from
+// a trace aren't sampled, except for CPU profiles, so they account for every
+// nanosecond spent in each state. They may also be restricted to a window of
+// time, a set of goroutines, or a set of user regions.
+package pprof
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/trace"
+)
+
+// Kind is a kind of profile.
+type Kind int
+
+const (
+	// CPU is a profile of the CPU samples in the trace, which are only
+	// present if CPU profiling was enabled while tracing.
+	CPU Kind = iota
+
+	// IO is a profile of the time goroutines spent waiting on the network.
+	IO
+
+	// Block is a profile of the time goroutines spent blocked on
+	// synchronization primitives, like channels, selects, and mutexes.
+	Block
+
+	// Syscall is a profile of the time goroutines spent in system calls.
+	Syscall
+
+	// Sched is a profile of the time goroutines spent waiting to run,
+	// that is, scheduler latency.
+	Sched
+)
+
+// String returns the name of the kind of profile.
+func (k Kind) String() string {
+	switch k {
+	case CPU:
+		return "cpu"
+	case IO:
+		return "io"
+	case Block:
+		return "block"
+	case Syscall:
+		return "syscall"
+	case Sched:
+		return "sched"
+	}
+	return fmt.Sprintf("Kind(%d)", int(k))
+}
+
+// Options restricts the parts of a trace that are included in a profile.
+type Options struct {
+	// Start and End bound the time window to profile, as offsets from
+	// the first event in the trace. If End is zero, the window extends
+	// to the end of the trace.
+	Start, End time.Duration
+
+	// Goroutines, if not empty, restricts the profile to the listed goroutines.
+	Goroutines []trace.GoID
+
+	// Regions, if not empty, restricts the profile to the time goroutines
+	// spent inside user regions of the listed types.
+	Regions []string
+}
+
+// Profile is a profile created from a trace.
+type Profile struct {
+	Kind Kind
+
+	// Duration is the length of the part of the trace that was profiled.
+	Duration time.Duration
+
+	// Samples are the samples in the profile, one per unique stack.
+	Samples []*Sample
+}
+
+// Sample is a single sample in a profile.
+type Sample struct {
+	// Stack is the stack the sample is attributed to, innermost frame first.
+	Stack []trace.StackFrame
+
+	// Count is the number of CPU samples, or the number of times a goroutine
+	// entered the profiled state, with this stack.
+	Count int64
+
+	// Value is the total time spent in the profiled state with this stack.
+	// It's zero for CPU profiles.
+	Value time.Duration
+}
+
+// Write reads the rest of the trace from r and writes a profile of the
+// given kind to w, as gzip-compressed protocol buffers.
+func Write(w io.Writer, r *trace.Reader, kind Kind, opts Options) error {
+	b := NewBuilder(opts)
+	for {
+		ev, err := r.ReadEvent()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		b.Event(&ev)
+	}
+	return b.Profile(kind).Write(w)
+}
+
+// Builder builds profiles from a stream of events.
+type Builder struct {
+	opts       Options
+	goroutines map[trace.GoID]bool
+	regions    map[string]bool
+
+	haveStart  bool
+	start, end trace.Time // the window.
+	last       trace.Time // the time of the last event.
+	finalized  bool
+
+	gs       map[trace.GoID]*goState
+	profiles [Sched + 1]map[string]*Sample
+}
+
+// goState is the state of a goroutine at the current point in the trace.
+type goState struct {
+	state   trace.GoState
+	reason  string
+	since   trace.Time
+	stack   trace.Stack // the stack the current state is attributed to.
+	counted bool        // whether the current state has been counted yet.
+	regions []string    // types of active regions.
+}
+
+// NewBuilder creates a new Builder for profiles restricted by opts.
+func NewBuilder(opts Options) *Builder {
+	b := &Builder{
+		opts: opts,
+		gs:   make(map[trace.GoID]*goState),
+	}
+	if len(opts.Goroutines) != 0 {
+		b.goroutines = make(map[trace.GoID]bool)
+		for _, g := range opts.Goroutines {
+			b.goroutines[g] = true
+		}
+	}
+	if len(opts.Regions) != 0 {
+		b.regions = make(map[string]bool)
+		for _, r := range opts.Regions {
+			b.regions[r] = true
+		}
+	}
+	for i := range b.profiles {
+		b.profiles[i] = make(map[string]*Sample)
+	}
+	return b
+}
+
+// Event feeds the next event in the trace to the builder.
+func (b *Builder) Event(ev *trace.Event) {
+	t := ev.Time()
+	if ev.Kind() != trace.EventSync {
+		if !b.haveStart {
+			b.haveStart = true
+			b.start = t + trace.Time(b.opts.Start)
+			if b.opts.End != 0 {
+				b.end = t + trace.Time(b.opts.End)
+			}
+		}
+		b.last = t
+	}
+
+	switch ev.Kind() {
+	case trace.EventStackSample:
+		g := ev.Goroutine()
+		if t < b.start || (b.end != 0 && t > b.end) || !b.include(g, b.gs[g]) {
+			break
+		}
+		s := b.sample(CPU, ev.Stack())
+		s.Count++
+	case trace.EventStateTransition:
+		st := ev.StateTransition()
+		if st.Resource.Kind != trace.ResourceGoroutine {
+			break
+		}
+		id := st.Resource.Goroutine()
+		gs := b.goroutine(id, t)
+		b.flush(id, gs, t)
+		_, to := st.Goroutine()
+		gs.state = to
+		gs.reason = st.Reason
+		gs.since = t
+		gs.counted = false
+		if st.Stack != trace.NoStack {
+			// Transitions that don't have a stack, like being unblocked,
+			// leave the goroutine where it was.
+			gs.stack = st.Stack
+		}
+	case trace.EventRegionBegin:
+		id := ev.Goroutine()
+		gs := b.goroutine(id, t)
+		b.flush(id, gs, t)
+		gs.regions = append(gs.regions, ev.Region().Type)
+	case trace.EventRegionEnd:
+		id := ev.Goroutine()
+		gs := b.goroutine(id, t)
+		b.flush(id, gs, t)
+		if n := len(gs.regions); n != 0 && gs.regions[n-1] == ev.Region().Type {
+			gs.regions = gs.regions[:n-1]
+		}
+	}
+}
+
+// Profile returns the profile of the given kind. It must be called after
+// all the events have been passed to Event.
+func (b *Builder) Profile(kind Kind) *Profile {
+	if !b.finalized {
+		b.finalized = true
+		for id, gs := range b.gs {
+			b.flush(id, gs, b.last)
+		}
+	}
+	end := b.last
+	if b.end != 0 && b.end < end {
+		end = b.end
+	}
+	p := &Profile{Kind: kind}
+	if end > b.start {
+		p.Duration = end.Sub(b.start)
+	}
+	keys := make([]string, 0, len(b.profiles[kind]))
+	for k := range b.profiles[kind] {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	for _, k := range keys {
+		p.Samples = append(p.Samples, b.profiles[kind][k])
+	}
+	return p
+}
+
+func (b *Builder) goroutine(id trace.GoID, t trace.Time) *goState {
+	gs, ok := b.gs[id]
+	if !ok {
+		gs = &goState{state: trace.GoUndetermined, since: t}
+		b.gs[id] = gs
+	}
+	return gs
+}
+
+// include returns whether events on goroutine id, which has state gs,
+// should be included in the profile.
+func (b *Builder) include(id trace.GoID, gs *goState) bool {
+	if b.goroutines != nil && !b.goroutines[id] {
+		return false
+	}
+	if b.regions == nil {
+		return true
+	}
+	if gs != nil {
+		for _, r := range gs.regions {
+			if b.regions[r] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// flush accounts for the time goroutine id spent in its current state up
+// until t.
+func (b *Builder) flush(id trace.GoID, gs *goState, t trace.Time) {
+	start, end := gs.since, t
+	gs.since = t
+	if start < b.start {
+		start = b.start
+	}
+	if b.end != 0 && end > b.end {
+		end = b.end
+	}
+	if end <= start || !b.include(id, gs) {
+		return
+	}
+	var kind Kind
+	switch gs.state {
+	case trace.GoRunnable:
+		kind = Sched
+	case trace.GoSyscall:
+		kind = Syscall
+	case trace.GoWaiting:
+		switch gs.reason {
+		case "network":
+			kind = IO
+		case "chan receive", "chan send", "select", "sync", "sync.(*Cond).Wait":
+			kind = Block
+		default:
+			return
+		}
+	default:
+		return
+	}
+	s := b.sample(kind, gs.stack)
+	if !gs.counted {
+		gs.counted = true
+		s.Count++
+	}
+	s.Value += end.Sub(start)
+}
+
+// sample returns the sample for stk in the profile of the given kind.
+func (b *Builder) sample(kind Kind, stk trace.Stack) *Sample {
+	var frames []trace.StackFrame
+	var key strings.Builder
+	stk.Frames(func(f trace.StackFrame) bool {
+		frames = append(frames, f)
+		fmt.Fprintf(&key, "%x %s %s %d\n", f.PC, f.Func, f.File, f.Line)
+		return true
+	})
+	s, ok := b.profiles[kind][key.String()]
+	if !ok {
+		s = &Sample{Stack: frames}
+		b.profiles[kind][key.String()] = s
+	}
+	return s
+}
@@ -0,0 +1,216 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.21
+
+package pprof_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"golang.org/x/exp/trace"
+	"golang.org/x/exp/trace/internal/event/go122"
+	testgen "golang.org/x/exp/trace/internal/testgen/go122"
+	"golang.org/x/exp/trace/internal/testtrace"
+	"golang.org/x/exp/trace/pprof"
+)
+
+// A tick is the time between consecutive events in a generated trace.
+const tick = 64 * time.Nanosecond
+
+func TestBuilder(t *testing.T) {
+	tt := testgen.NewTrace()
+	tt.ExpectSuccess()
+	g1 := tt.Generation(1)
+	b := g1.Batch(trace.ThreadID(0), 0)
+	b.Event("ProcStatus", trace.ProcID(0), go122.ProcRunning)
+	b.Event("GoStatus", trace.GoID(1), trace.ThreadID(0), go122.GoRunning)
+	b.Event("GoCreate", trace.GoID(2), testgen.NoStack, testgen.NoStack)
+	b.Event("GoBlock", "chan receive", testgen.NoStack)
+	b.Event("GoStart", trace.GoID(2), testgen.Seq(1))
+	b.Event("UserRegionBegin", trace.TaskID(0), "work", testgen.NoStack)
+	b.Event("GoUnblock", trace.GoID(1), testgen.Seq(1), testgen.NoStack)
+	b.Event("GoSyscallBegin", testgen.Seq(1), testgen.NoStack)
+	b.Event("GoSyscallEnd")
+	b.Event("UserRegionEnd", trace.TaskID(0), "work", testgen.NoStack)
+	b.Event("GoStop", "preempted", testgen.NoStack)
+	b.Event("GoStart", trace.GoID(1), testgen.Seq(2))
+	b.Event("GoDestroy")
+	samples := g1.Batch(trace.NoThread, 0)
+	samples.RawEvent(go122.EvCPUSamples, nil)
+	samples.RawEvent(go122.EvCPUSample, nil, 7, 0, 0, 2, 0)
+	samples.RawEvent(go122.EvCPUSample, nil, 11, 0, 0, 2, 0)
+
+	path := filepath.Join(t.TempDir(), "generated.test")
+	if err := os.WriteFile(path, tt.Generate(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var evs []trace.Event
+	tr, _, err := testtrace.ParseFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := trace.NewReader(tr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for {
+		ev, err := r.ReadEvent()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		evs = append(evs, ev)
+	}
+
+	type total struct {
+		count int64
+		value time.Duration
+	}
+	for _, test := range []struct {
+		name string
+		opts pprof.Options
+		want map[pprof.Kind]total
+	}{
+		{
+			name: "all",
+			want: map[pprof.Kind]total{
+				pprof.CPU:     {2, 0},
+				pprof.Block:   {1, 3 * tick},
+				pprof.Sched:   {3, 9 * tick},
+				pprof.Syscall: {1, tick},
+			},
+		},
+		{
+			name: "region",
+			opts: pprof.Options{Regions: []string{"work"}},
+			want: map[pprof.Kind]total{
+				pprof.CPU:     {1, 0},
+				pprof.Syscall: {1, tick},
+			},
+		},
+		{
+			name: "goroutine",
+			opts: pprof.Options{Goroutines: []trace.GoID{1}},
+			want: map[pprof.Kind]total{
+				pprof.Block: {1, 3 * tick},
+				pprof.Sched: {1, 5 * tick},
+			},
+		},
+		{
+			name: "window",
+			opts: pprof.Options{Start: 5 * tick, End: 8 * tick},
+			want: map[pprof.Kind]total{
+				pprof.CPU:     {1, 0},
+				pprof.Block:   {1, tick},
+				pprof.Sched:   {1, 2 * tick},
+				pprof.Syscall: {1, tick},
+			},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			b := pprof.NewBuilder(test.opts)
+			for i := range evs {
+				b.Event(&evs[i])
+			}
+			for _, kind := range []pprof.Kind{pprof.CPU, pprof.IO, pprof.Block, pprof.Syscall, pprof.Sched} {
+				var got total
+				for _, s := range b.Profile(kind).Samples {
+					got.count += s.Count
+					got.value += s.Value
+				}
+				if want := test.want[kind]; got != want {
+					t.Errorf("%s profile: got count %d and value %v, want count %d and value %v", kind, got.count, got.value, want.count, want.value)
+				}
+			}
+		})
+	}
+}
+
+func TestWrite(t *testing.T) {
+	tr, _, err := testtrace.ParseFile("../testdata/tests/go122-annotations.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(tr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := trace.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := pprof.Write(&buf, r, pprof.Block, pprof.Options{}); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pb, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	samples, strs := decodeProfile(t, pb)
+	if samples == 0 {
+		t.Error("profile has no samples")
+	}
+	if len(strs) == 0 || strs[0] != "" {
+		t.Errorf("string table must start with the empty string, got %q", strs)
+	}
+	for _, s := range []string{"contentions", "delay", "nanoseconds", "runtime.chanrecv1", "main.main"} {
+		if !slices.Contains(strs, s) {
+			t.Errorf("string table is missing %q", s)
+		}
+	}
+}
+
+// decodeProfile returns the number of samples and the string table in an
+// encoded profile.proto Profile message.
+func decodeProfile(t *testing.T, b []byte) (samples int, strs []string) {
+	t.Helper()
+
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			t.Fatal("bad field key")
+		}
+		b = b[n:]
+		switch key & 7 {
+		case 0:
+			_, n := binary.Uvarint(b)
+			if n <= 0 {
+				t.Fatal("bad varint")
+			}
+			b = b[n:]
+		case 2:
+			l, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < l {
+				t.Fatal("bad length")
+			}
+			v := b[n : n+int(l)]
+			b = b[n+int(l):]
+			switch key >> 3 {
+			case 2:
+				samples++
+			case 6:
+				strs = append(strs, string(v))
+			}
+		default:
+			t.Fatalf("unexpected wire type %d", key&7)
+		}
+	}
+	return samples, strs
+}
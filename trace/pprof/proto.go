@@ -0,0 +1,237 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.21
+
+package pprof
+
+import (
+	"compress/gzip"
+	"io"
+
+	"golang.org/x/exp/trace"
+)
+
+// Field numbers from the pprof profile.proto.
+const (
+	// Profile.
+	tagProfileSampleType    = 1
+	tagProfileSample        = 2
+	tagProfileLocation      = 4
+	tagProfileFunction      = 5
+	tagProfileStringTable   = 6
+	tagProfileDurationNanos = 10
+	tagProfilePeriodType    = 11
+	tagProfilePeriod        = 12
+
+	// ValueType.
+	tagValueTypeType = 1
+	tagValueTypeUnit = 2
+
+	// Sample.
+	tagSampleLocation = 1
+	tagSampleValue    = 2
+
+	// Location.
+	tagLocationID      = 1
+	tagLocationAddress = 3
+	tagLocationLine    = 4
+
+	// Line.
+	tagLineFunctionID = 1
+	tagLineLine       = 2
+
+	// Function.
+	tagFunctionID         = 1
+	tagFunctionName       = 2
+	tagFunctionSystemName = 3
+	tagFunctionFilename   = 4
+)
+
+// Write writes the profile to w in the pprof format, that is, as
+// gzip-compressed protocol buffers.
+func (p *Profile) Write(w io.Writer) error {
+	zw := gzip.NewWriter(w)
+	if _, err := zw.Write(p.encode()); err != nil {
+		zw.Close()
+		return err
+	}
+	return zw.Close()
+}
+
+// encode returns the profile encoded as a profile.proto Profile message.
+func (p *Profile) encode() []byte {
+	e := &encoder{strings: map[string]int64{"": 0}, stringTable: []string{""}}
+	var b protobuf
+
+	// Sample types.
+	var types [][2]string
+	if p.Kind == CPU {
+		types = [][2]string{{"samples", "count"}}
+	} else {
+		types = [][2]string{{"contentions", "count"}, {"delay", "nanoseconds"}}
+	}
+	for _, typ := range types {
+		b.message(tagProfileSampleType, func(b *protobuf) {
+			b.int64(tagValueTypeType, e.string(typ[0]))
+			b.int64(tagValueTypeUnit, e.string(typ[1]))
+		})
+	}
+
+	// Samples.
+	locs := make([]uint64, 0, 64)
+	for _, s := range p.Samples {
+		locs = locs[:0]
+		for _, f := range s.Stack {
+			locs = append(locs, e.location(f))
+		}
+		b.message(tagProfileSample, func(b *protobuf) {
+			b.packed(tagSampleLocation, locs)
+			if p.Kind == CPU {
+				b.packed(tagSampleValue, []uint64{uint64(s.Count)})
+			} else {
+				b.packed(tagSampleValue, []uint64{uint64(s.Count), uint64(s.Value)})
+			}
+		})
+	}
+
+	// Locations and functions.
+	for i, f := range e.locations {
+		b.message(tagProfileLocation, func(b *protobuf) {
+			b.uint64(tagLocationID, uint64(i+1))
+			b.uint64(tagLocationAddress, f.PC)
+			b.message(tagLocationLine, func(b *protobuf) {
+				b.uint64(tagLineFunctionID, e.functions[function{f.Func, f.File}])
+				b.int64(tagLineLine, int64(f.Line))
+			})
+		})
+	}
+	for i, f := range e.functionList {
+		b.message(tagProfileFunction, func(b *protobuf) {
+			b.uint64(tagFunctionID, uint64(i+1))
+			b.int64(tagFunctionName, e.string(f.name))
+			b.int64(tagFunctionSystemName, e.string(f.name))
+			b.int64(tagFunctionFilename, e.string(f.file))
+		})
+	}
+
+	b.int64(tagProfileDurationNanos, int64(p.Duration))
+	b.message(tagProfilePeriodType, func(b *protobuf) {
+		b.int64(tagValueTypeType, e.string(types[0][0]))
+		b.int64(tagValueTypeUnit, e.string(types[0][1]))
+	})
+	b.int64(tagProfilePeriod, 1)
+
+	// The string table goes last, since everything else adds to it.
+	for _, s := range e.stringTable {
+		b.string(tagProfileStringTable, s)
+	}
+	return b.data
+}
+
+// function identifies a function in a profile.
+type function struct {
+	name, file string
+}
+
+// encoder deduplicates the strings, locations, and functions in a profile.
+type encoder struct {
+	strings      map[string]int64
+	stringTable  []string
+	locationIDs  map[trace.StackFrame]uint64
+	locations    []trace.StackFrame
+	functions    map[function]uint64
+	functionList []function
+}
+
+func (e *encoder) string(s string) int64 {
+	id, ok := e.strings[s]
+	if !ok {
+		id = int64(len(e.stringTable))
+		e.strings[s] = id
+		e.stringTable = append(e.stringTable, s)
+	}
+	return id
+}
+
+func (e *encoder) location(f trace.StackFrame) uint64 {
+	if e.locationIDs == nil {
+		e.locationIDs = make(map[trace.StackFrame]uint64)
+		e.functions = make(map[function]uint64)
+	}
+	id, ok := e.locationIDs[f]
+	if !ok {
+		e.locations = append(e.locations, f)
+		id = uint64(len(e.locations))
+		e.locationIDs[f] = id
+		fn := function{f.Func, f.File}
+		if _, ok := e.functions[fn]; !ok {
+			e.functionList = append(e.functionList, fn)
+			e.functions[fn] = uint64(len(e.functionList))
+		}
+	}
+	return id
+}
+
+// protobuf is a minimal protocol buffer encoder.
+type protobuf struct {
+	data []byte
+}
+
+const (
+	wireVarint = 0
+	wireBytes  = 2
+)
+
+func (b *protobuf) varint(x uint64) {
+	for x >= 0x80 {
+		b.data = append(b.data, byte(x)|0x80)
+		x >>= 7
+	}
+	b.data = append(b.data, byte(x))
+}
+
+func (b *protobuf) key(tag, wire int) {
+	b.varint(uint64(tag)<<3 | uint64(wire))
+}
+
+func (b *protobuf) uint64(tag int, x uint64) {
+	if x == 0 {
+		return
+	}
+	b.key(tag, wireVarint)
+	b.varint(x)
+}
+
+func (b *protobuf) int64(tag int, x int64) {
+	b.uint64(tag, uint64(x))
+}
+
+func (b *protobuf) string(tag int, s string) {
+	// Unlike other fields, empty strings in the string table are significant.
+	b.key(tag, wireBytes)
+	b.varint(uint64(len(s)))
+	b.data = append(b.data, s...)
+}
+
+func (b *protobuf) packed(tag int, xs []uint64) {
+	if len(xs) == 0 {
+		return
+	}
+	var p protobuf
+	for _, x := range xs {
+		p.varint(x)
+	}
+	b.key(tag, wireBytes)
+	b.varint(uint64(len(p.data)))
+	b.data = append(b.data, p.data...)
+}
+
+func (b *protobuf) message(tag int, f func(*protobuf)) {
+	var m protobuf
+	f(&m)
+	b.key(tag, wireBytes)
+	b.varint(uint64(len(m.data)))
+	b.data = append(b.data, m.data...)
+}
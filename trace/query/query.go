@@ -0,0 +1,396 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.21
+
+// Package query selects user annotations in an execution trace, that is,
+// the tasks, regions, and log messages a program creates with the
+// runtime/trace package, with a small query language.
+//
+// A query names the kind of span it selects, followed by conditions, all of
+// which a span must meet. For example, this selects tasks of type "http"
+// with a "db.query" region that took longer than 50ms:
+//
+//	task type=http with (region type=db.query duration>50ms)
+//
+// The kinds are task, region, and log, or their plurals. The conditions are
+//
+//	type OP STRING           the type of a task or region, or the category of a log message
+//	category OP STRING       the same as type
+//	message OP STRING        the message of a log message
+//	label.KEY OP STRING      the span or one of its descendants has a log message
+//	                         in category KEY whose message matches
+//	duration OP DURATION     how long the span took, as for time.ParseDuration
+//	goroutine OP NUMBER      the goroutine the span is on
+//	task OP NUMBER           the ID of a task, or the task a region or log message belongs to
+//	with (QUERY)             one of the span's descendants matches QUERY
+//	in (QUERY)               one of the span's ancestors matches QUERY
+//	not CONDITION            CONDITION doesn't hold
+//
+// String conditions support the operators =, !=, ~ (matches a regular
+// expression), and !~ (doesn't match one). The others support =, !=, <, <=,
+// >, and >=. Strings may be quoted, using Go syntax, if they contain spaces
+// or special characters. Duration conditions only hold for spans whose
+// beginning and end are both in the trace.
+//
+// See [Span] for how spans are related to each other.
+package query
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"golang.org/x/exp/trace"
+)
+
+// Query is a compiled query.
+type Query struct {
+	src   string
+	kind  Kind
+	conds []func(*Span) bool
+}
+
+// Parse parses a query.
+func Parse(query string) (*Query, error) {
+	p := &parser{src: query}
+	if err := p.tokenize(); err != nil {
+		return nil, err
+	}
+	q, err := p.query()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokEOF {
+		return nil, p.errorf(tok, "unexpected %s", tok)
+	}
+	q.src = query
+	return q, nil
+}
+
+// MustParse is like Parse, but panics if the query can't be parsed.
+func MustParse(query string) *Query {
+	q, err := Parse(query)
+	if err != nil {
+		panic(err)
+	}
+	return q
+}
+
+// String returns the source of the query.
+func (q *Query) String() string {
+	return q.src
+}
+
+// Match reports whether s matches the query.
+func (q *Query) Match(s *Span) bool {
+	if s.Kind != q.kind {
+		return false
+	}
+	for _, c := range q.conds {
+		if !c(s) {
+			return false
+		}
+	}
+	return true
+}
+
+// Select returns the spans that match the query, in order.
+func (q *Query) Select(spans []*Span) []*Span {
+	var matches []*Span
+	for _, s := range spans {
+		if q.Match(s) {
+			matches = append(matches, s)
+		}
+	}
+	return matches
+}
+
+// anyDescendant reports whether any of s's descendants satisfies f.
+func anyDescendant(s *Span, f func(*Span) bool) bool {
+	for _, c := range s.Children {
+		if f(c) || anyDescendant(c, f) {
+			return true
+		}
+	}
+	return false
+}
+
+type tokenKind uint8
+
+const (
+	tokEOF tokenKind = iota
+	tokWord
+	tokString
+	tokOp
+	tokLParen
+	tokRParen
+)
+
+type token struct {
+	kind tokenKind
+	text string // for strings, the unquoted text
+	pos  int
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokEOF:
+		return "end of query"
+	case tokString:
+		return strconv.Quote(t.text)
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+type parser struct {
+	src  string
+	toks []token
+	next int
+}
+
+func (p *parser) errorf(tok token, format string, args ...any) error {
+	return fmt.Errorf("query:%d: %s", tok.pos+1, fmt.Sprintf(format, args...))
+}
+
+// isWordRune reports whether r may be part of an unquoted word.
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("_.-/:*", r)
+}
+
+func (p *parser) tokenize() error {
+	s := p.src
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+		case c == '(':
+			p.toks = append(p.toks, token{tokLParen, "(", i})
+			i++
+		case c == ')':
+			p.toks = append(p.toks, token{tokRParen, ")", i})
+			i++
+		case strings.ContainsRune("=!~<>", rune(c)):
+			j := i + 1
+			if j < len(s) && (s[j] == '=' || s[j] == '~') && c != '=' && c != '~' {
+				j++
+			}
+			op := s[i:j]
+			if op == "!" {
+				return p.errorf(token{pos: i}, "bad operator %q", op)
+			}
+			p.toks = append(p.toks, token{tokOp, op, i})
+			i = j
+		case c == '"' || c == '`':
+			prefix, err := strconv.QuotedPrefix(s[i:])
+			if err != nil {
+				return p.errorf(token{pos: i}, "bad quoted string")
+			}
+			text, _ := strconv.Unquote(prefix)
+			p.toks = append(p.toks, token{tokString, text, i})
+			i += len(prefix)
+		default:
+			j := i
+			for _, r := range s[i:] {
+				if !isWordRune(r) {
+					break
+				}
+				j += len(string(r))
+			}
+			if j == i {
+				return p.errorf(token{pos: i}, "unexpected character %q", c)
+			}
+			p.toks = append(p.toks, token{tokWord, s[i:j], i})
+			i = j
+		}
+	}
+	p.toks = append(p.toks, token{tokEOF, "", len(s)})
+	return nil
+}
+
+func (p *parser) peek() token {
+	return p.toks[p.next]
+}
+
+func (p *parser) advance() token {
+	tok := p.toks[p.next]
+	if tok.kind != tokEOF {
+		p.next++
+	}
+	return tok
+}
+
+func (p *parser) expect(kind tokenKind, what string) (token, error) {
+	tok := p.advance()
+	if tok.kind != kind {
+		return tok, p.errorf(tok, "expected %s, found %s", what, tok)
+	}
+	return tok, nil
+}
+
+// query parses a kind followed by conditions, up to the end of the query or
+// a closing parenthesis.
+func (p *parser) query() (*Query, error) {
+	tok, err := p.expect(tokWord, "task, region, or log")
+	if err != nil {
+		return nil, err
+	}
+	q := &Query{}
+	switch tok.text {
+	case "task", "tasks":
+		q.kind = KindTask
+	case "region", "regions":
+		q.kind = KindRegion
+	case "log", "logs":
+		q.kind = KindLog
+	default:
+		return nil, p.errorf(tok, "expected task, region, or log, found %s", tok)
+	}
+	for {
+		if k := p.peek().kind; k == tokEOF || k == tokRParen {
+			return q, nil
+		}
+		c, err := p.cond()
+		if err != nil {
+			return nil, err
+		}
+		q.conds = append(q.conds, c)
+	}
+}
+
+func (p *parser) cond() (func(*Span) bool, error) {
+	tok, err := p.expect(tokWord, "condition")
+	if err != nil {
+		return nil, err
+	}
+	switch tok.text {
+	case "not":
+		c, err := p.cond()
+		if err != nil {
+			return nil, err
+		}
+		return func(s *Span) bool { return !c(s) }, nil
+	case "with", "in":
+		if _, err := p.expect(tokLParen, `"("`); err != nil {
+			return nil, err
+		}
+		sub, err := p.query()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(tokRParen, `")"`); err != nil {
+			return nil, err
+		}
+		if tok.text == "with" {
+			return func(s *Span) bool { return anyDescendant(s, sub.Match) }, nil
+		}
+		return func(s *Span) bool {
+			for a := s.Parent; a != nil; a = a.Parent {
+				if sub.Match(a) {
+					return true
+				}
+			}
+			return false
+		}, nil
+	}
+
+	op, err := p.expect(tokOp, "operator")
+	if err != nil {
+		return nil, err
+	}
+	val := p.advance()
+	if val.kind != tokWord && val.kind != tokString {
+		return nil, p.errorf(val, "expected value, found %s", val)
+	}
+	switch field := tok.text; {
+	case field == "type" || field == "category":
+		m, err := p.stringMatcher(op, val)
+		if err != nil {
+			return nil, err
+		}
+		return func(s *Span) bool { return m(s.Type) }, nil
+	case field == "message":
+		m, err := p.stringMatcher(op, val)
+		if err != nil {
+			return nil, err
+		}
+		return func(s *Span) bool { return s.Kind == KindLog && m(s.Message) }, nil
+	case strings.HasPrefix(field, "label."):
+		key := strings.TrimPrefix(field, "label.")
+		m, err := p.stringMatcher(op, val)
+		if err != nil {
+			return nil, err
+		}
+		isLabel := func(s *Span) bool { return s.Kind == KindLog && s.Type == key && m(s.Message) }
+		return func(s *Span) bool { return isLabel(s) || anyDescendant(s, isLabel) }, nil
+	case field == "duration":
+		d, err := time.ParseDuration(val.text)
+		if err != nil {
+			return nil, p.errorf(val, "bad duration %s", val)
+		}
+		cmp, err := p.comparison(op)
+		if err != nil {
+			return nil, err
+		}
+		return func(s *Span) bool {
+			sd, ok := s.Duration()
+			return ok && cmp(int64(sd), int64(d))
+		}, nil
+	case field == "goroutine" || field == "task":
+		n, err := strconv.ParseInt(val.text, 10, 64)
+		if err != nil {
+			return nil, p.errorf(val, "bad %s ID %s", field, val)
+		}
+		cmp, err := p.comparison(op)
+		if err != nil {
+			return nil, err
+		}
+		if field == "goroutine" {
+			return func(s *Span) bool { return s.Goroutine != trace.NoGoroutine && cmp(int64(s.Goroutine), n) }, nil
+		}
+		return func(s *Span) bool { return s.Task != trace.NoTask && cmp(int64(s.Task), n) }, nil
+	}
+	return nil, p.errorf(tok, "unknown field %s", tok)
+}
+
+// stringMatcher returns a function that compares strings to val with op.
+func (p *parser) stringMatcher(op, val token) (func(string) bool, error) {
+	switch op.text {
+	case "=":
+		return func(s string) bool { return s == val.text }, nil
+	case "!=":
+		return func(s string) bool { return s != val.text }, nil
+	case "~", "!~":
+		re, err := regexp.Compile(val.text)
+		if err != nil {
+			return nil, p.errorf(val, "bad regular expression: %v", err)
+		}
+		want := op.text == "~"
+		return func(s string) bool { return re.MatchString(s) == want }, nil
+	}
+	return nil, p.errorf(op, "can't compare strings with %s", op.text)
+}
+
+// comparison returns a function that compares numbers with op.
+func (p *parser) comparison(op token) (func(x, y int64) bool, error) {
+	switch op.text {
+	case "=":
+		return func(x, y int64) bool { return x == y }, nil
+	case "!=":
+		return func(x, y int64) bool { return x != y }, nil
+	case "<":
+		return func(x, y int64) bool { return x < y }, nil
+	case "<=":
+		return func(x, y int64) bool { return x <= y }, nil
+	case ">":
+		return func(x, y int64) bool { return x > y }, nil
+	case ">=":
+		return func(x, y int64) bool { return x >= y }, nil
+	}
+	return nil, p.errorf(op, "can't compare numbers with %s", op.text)
+}
@@ -0,0 +1,236 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.21
+
+package query_test
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/exp/trace"
+	"golang.org/x/exp/trace/query"
+	"golang.org/x/exp/trace/tracetest"
+)
+
+const ms = trace.Time(time.Millisecond)
+
+// testSpans returns the spans in a trace with three requests, each a task
+// with a database query in it, and a region that began before the trace.
+func testSpans(t *testing.T) []*query.Span {
+	t.Helper()
+
+	handler := []trace.StackFrame{{PC: 0x1000, Func: "main.handle", File: "main.go", Line: 10}}
+	db := []trace.StackFrame{{PC: 0x2000, Func: "main.query", File: "db.go", Line: 20}}
+
+	tt := tracetest.NewTrace()
+	b := tt.Generation().Batch(1)
+	b.ProcStatus(1, 0, trace.ProcRunning)
+	b.GoStatus(1, 1, trace.GoRunning)
+	b.RegionEnd(2, trace.BackgroundTask, "startup", nil)
+	for i, req := range []struct {
+		typ  string
+		user string
+		dur  trace.Time
+	}{
+		{"http", "alice", 60 * ms},
+		{"http", "bob", 10 * ms},
+		{"grpc", "alice", 100 * ms},
+	} {
+		id := trace.TaskID(i + 1)
+		start := trace.Time(i+1) * 1000 * ms
+		b.TaskBegin(start, id, trace.BackgroundTask, req.typ, handler)
+		b.RegionBegin(start+1, id, "handle", handler)
+		b.Log(start+2, id, "user", req.user, handler)
+		b.RegionBegin(start+3, id, "db.query", db)
+		b.Log(start+4, id, "sql", "SELECT 1", db)
+		b.RegionEnd(start+3+req.dur, id, "db.query", db)
+		b.RegionEnd(start+4+req.dur, id, "handle", handler)
+		b.TaskEnd(start+5+req.dur, id, handler)
+	}
+
+	r, err := trace.NewReader(bytes.NewReader(tt.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	spans, err := query.ReadSpans(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return spans
+}
+
+// describe describes a span and its ancestry.
+func describe(s *query.Span) string {
+	var parts []string
+	for ; s != nil; s = s.Parent {
+		p := fmt.Sprintf("%v %s", s.Kind, s.Type)
+		if s.Kind == query.KindLog {
+			p += "=" + s.Message
+		}
+		parts = append([]string{p}, parts...)
+	}
+	return strings.Join(parts, " > ")
+}
+
+func TestReadSpans(t *testing.T) {
+	spans := testSpans(t)
+	if len(spans) != 16 {
+		t.Fatalf("got %d spans, want 16", len(spans))
+	}
+
+	startup := spans[0]
+	if startup.Kind != query.KindRegion || startup.Type != "startup" || startup.Start != 0 || startup.End == 0 {
+		t.Errorf("got first span %+v, want the startup region with only an end", startup)
+	}
+	if _, ok := startup.Duration(); ok {
+		t.Errorf("startup region has a duration, but it began before the trace")
+	}
+
+	sql := spans[5]
+	if got, want := describe(sql), "task http > region handle > region db.query > log sql=SELECT 1"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if sql.Goroutine != 1 || sql.Task != 1 {
+		t.Errorf("got log on G%d in task %d, want G1 in task 1", sql.Goroutine, sql.Task)
+	}
+	if f, ok := firstFrame(sql.StartStack); !ok || f.Func != "main.query" {
+		t.Errorf("got log stack %+v, want main.query", f)
+	}
+
+	q := sql.Parent
+	if d, ok := q.Duration(); !ok || d != 60*time.Millisecond {
+		t.Errorf("got db.query duration %v, %v, want 60ms", d, ok)
+	}
+	if f, ok := firstFrame(q.EndStack); !ok || f.Func != "main.query" {
+		t.Errorf("got region end stack %+v, want main.query", f)
+	}
+}
+
+func TestReadSpansEndedParent(t *testing.T) {
+	tt := tracetest.NewTrace()
+	b := tt.Generation().Batch(1)
+	b.ProcStatus(1, 0, trace.ProcRunning)
+	b.GoStatus(1, 1, trace.GoRunning)
+	b.TaskBegin(1*ms, 1, trace.NoTask, "request", nil)
+	b.TaskEnd(2*ms, 1, nil)
+	b.TaskBegin(3*ms, 2, 1, "cleanup", nil)
+	b.Log(4*ms, 1, "late", "after the end", nil)
+	b.TaskEnd(5*ms, 2, nil)
+
+	r, err := trace.NewReader(bytes.NewReader(tt.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	spans, err := query.ReadSpans(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, s := range spans {
+		got = append(got, describe(s))
+	}
+	want := []string{
+		"task request",
+		"task request > task cleanup",
+		"task request > log late=after the end",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got spans %q, want %q", got, want)
+	}
+}
+
+func firstFrame(stk trace.Stack) (trace.StackFrame, bool) {
+	var first trace.StackFrame
+	found := false
+	stk.Frames(func(f trace.StackFrame) bool {
+		first, found = f, true
+		return false
+	})
+	return first, found
+}
+
+func TestSelect(t *testing.T) {
+	spans := testSpans(t)
+	for _, tc := range []struct {
+		query string
+		want  []string
+	}{
+		{
+			query: "task type=http with (region type=db.query duration>50ms)",
+			want:  []string{"task http"},
+		},
+		{
+			query: "tasks",
+			want:  []string{"task http", "task http", "task grpc"},
+		},
+		{
+			query: `region type=db.query duration<=60ms in (task type~"^h")`,
+			want:  []string{"task http > region handle > region db.query", "task http > region handle > region db.query"},
+		},
+		{
+			query: "task label.user=alice",
+			want:  []string{"task http", "task grpc"},
+		},
+		{
+			query: `log category=sql message="SELECT 1" in (task label.user!=alice)`,
+			want:  []string{"task http > region handle > region db.query > log sql=SELECT 1"},
+		},
+		{
+			query: "region not in (task) goroutine=1",
+			want:  []string{"region startup"},
+		},
+		{
+			query: "task task>=2 not with (log category=user message!~`^(alice|bob)$`)",
+			want:  []string{"task http", "task grpc"},
+		},
+		{
+			query: "region duration>1h",
+			want:  nil,
+		},
+	} {
+		q, err := query.Parse(tc.query)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tc.query, err)
+			continue
+		}
+		var got []string
+		for _, s := range q.Select(spans) {
+			got = append(got, describe(s))
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s:\ngot  %q\nwant %q", tc.query, got, tc.want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, tc := range []struct {
+		query string
+		err   string
+	}{
+		{"", "query:1: expected task, region, or log, found end of query"},
+		{"span", `query:1: expected task, region, or log, found "span"`},
+		{"task color=red", `query:6: unknown field "color"`},
+		{"task type", "query:10: expected operator, found end of query"},
+		{"task type>http", "query:10: can't compare strings with >"},
+		{"task duration>soon", `query:15: bad duration "soon"`},
+		{`task type~"("`, "query:11: bad regular expression: error parsing regexp: missing closing ): `(`"},
+		{"task with (region", `query:18: expected ")", found end of query`},
+		{"task with region", `query:11: expected "(", found "region"`},
+		{"task) type=http", `query:5: unexpected ")"`},
+		{"task type=@", `query:11: unexpected character '@'`},
+		{"task goroutine=one", `query:16: bad goroutine ID "one"`},
+	} {
+		_, err := query.Parse(tc.query)
+		if err == nil || err.Error() != tc.err {
+			t.Errorf("Parse(%q): got error %v, want %s", tc.query, err, tc.err)
+		}
+	}
+}
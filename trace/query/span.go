@@ -0,0 +1,235 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.21
+
+package query
+
+import (
+	"io"
+	"time"
+
+	"golang.org/x/exp/trace"
+)
+
+// Kind is the kind of user annotation a Span represents.
+type Kind uint8
+
+const (
+	KindTask   Kind = iota + 1 // a task, from runtime/trace.NewTask
+	KindRegion                 // a region, from runtime/trace.StartRegion or WithRegion
+	KindLog                    // a log message, from runtime/trace.Log
+)
+
+func (k Kind) String() string {
+	switch k {
+	case KindTask:
+		return "task"
+	case KindRegion:
+		return "region"
+	case KindLog:
+		return "log"
+	}
+	return "bad"
+}
+
+// Span is a user annotation in a trace: a task, a region, or a log message.
+type Span struct {
+	Kind Kind
+
+	// Type is the type of a task or region, or the category of a log
+	// message.
+	Type string
+
+	// Message is the message of a log message, and empty otherwise.
+	Message string
+
+	// Task is the ID of a task, or the task a region or log message
+	// belongs to.
+	Task trace.TaskID
+
+	// Goroutine is the goroutine that began a task, or that a region or
+	// log message is on. It's trace.NoGoroutine if a task began before the
+	// trace did.
+	Goroutine trace.GoID
+
+	// Start and End are the times the span began and ended, or zero if
+	// they're outside the trace. A log message begins and ends at the
+	// same time.
+	Start, End trace.Time
+
+	// StartStack and EndStack are the stacks at the beginning and end of
+	// the span, if the trace has them. A log message only has a StartStack.
+	StartStack, EndStack trace.Stack
+
+	// Parent is the span that contains this one, if any. The parent of a
+	// task is its parent task. The parent of a region or log message is
+	// the innermost region on its goroutine that belongs to the same task
+	// and is active at the time, or otherwise its task.
+	Parent *Span
+
+	// Children are the spans that have this one as their parent, in the
+	// order they began.
+	Children []*Span
+}
+
+// Duration returns how long the span lasted, and whether that's known.
+func (s *Span) Duration() (time.Duration, bool) {
+	if s.Start == 0 || s.End == 0 {
+		return 0, false
+	}
+	return s.End.Sub(s.Start), true
+}
+
+// ReadSpans reads the user annotations in the rest of the trace read by r.
+// It returns them in the order they began, or for spans that began before
+// the trace did, in the order they ended.
+func ReadSpans(r *trace.Reader) ([]*Span, error) {
+	b := &spanBuilder{
+		tasks:   make(map[trace.TaskID]*Span),
+		ended:   make(map[trace.TaskID]*Span),
+		regions: make(map[trace.GoID][]*Span),
+	}
+	for {
+		ev, err := r.ReadEvent()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		b.event(&ev)
+	}
+	return b.spans, nil
+}
+
+// spanBuilder builds spans from a stream of events.
+type spanBuilder struct {
+	spans   []*Span
+	tasks   map[trace.TaskID]*Span
+	ended   map[trace.TaskID]*Span // tasks that ended, which may still have children
+	regions map[trace.GoID][]*Span // active regions on each goroutine, innermost last
+}
+
+func (b *spanBuilder) event(ev *trace.Event) {
+	t := ev.Time()
+	switch ev.Kind() {
+	case trace.EventTaskBegin:
+		task := ev.Task()
+		s := &Span{
+			Kind:       KindTask,
+			Type:       task.Type,
+			Task:       task.ID,
+			Goroutine:  ev.Goroutine(),
+			Start:      t,
+			StartStack: ev.Stack(),
+		}
+		b.add(s, b.task(task.Parent))
+		b.tasks[task.ID] = s
+	case trace.EventTaskEnd:
+		task := ev.Task()
+		s, ok := b.tasks[task.ID]
+		if !ok {
+			// The task began before the trace did.
+			s = &Span{
+				Kind:      KindTask,
+				Type:      task.Type,
+				Task:      task.ID,
+				Goroutine: trace.NoGoroutine,
+			}
+			b.add(s, b.task(task.Parent))
+		}
+		s.End = t
+		s.EndStack = ev.Stack()
+		delete(b.tasks, task.ID)
+		b.ended[task.ID] = s
+	case trace.EventRegionBegin:
+		r := ev.Region()
+		g := ev.Goroutine()
+		s := &Span{
+			Kind:       KindRegion,
+			Type:       r.Type,
+			Task:       r.Task,
+			Goroutine:  g,
+			Start:      t,
+			StartStack: ev.Stack(),
+		}
+		b.add(s, b.parent(g, r.Task))
+		b.regions[g] = append(b.regions[g], s)
+	case trace.EventRegionEnd:
+		r := ev.Region()
+		g := ev.Goroutine()
+		stk := b.regions[g]
+		for i := len(stk) - 1; i >= 0; i-- {
+			if s := stk[i]; s.Type == r.Type && s.Task == r.Task {
+				s.End = t
+				s.EndStack = ev.Stack()
+				b.regions[g] = stk[:i]
+				return
+			}
+		}
+		// The region began before the trace did, so it must have been
+		// the outermost region on the goroutine.
+		b.add(&Span{
+			Kind:      KindRegion,
+			Type:      r.Type,
+			Task:      r.Task,
+			Goroutine: g,
+			End:       t,
+			EndStack:  ev.Stack(),
+		}, b.task(r.Task))
+	case trace.EventLog:
+		l := ev.Log()
+		g := ev.Goroutine()
+		b.add(&Span{
+			Kind:       KindLog,
+			Type:       l.Category,
+			Message:    l.Message,
+			Task:       l.Task,
+			Goroutine:  g,
+			Start:      t,
+			End:        t,
+			StartStack: ev.Stack(),
+		}, b.parent(g, l.Task))
+	case trace.EventStateTransition:
+		st := ev.StateTransition()
+		if st.Resource.Kind != trace.ResourceGoroutine {
+			break
+		}
+		if _, to := st.Goroutine(); to == trace.GoNotExist {
+			// Regions that are still active never end.
+			delete(b.regions, st.Resource.Goroutine())
+		}
+	}
+}
+
+// add adds s as a child of parent, if it's not nil.
+func (b *spanBuilder) add(s, parent *Span) {
+	if parent != nil {
+		s.Parent = parent
+		parent.Children = append(parent.Children, s)
+	}
+	b.spans = append(b.spans, s)
+}
+
+// parent returns the parent of a new region or log message in task on
+// goroutine g.
+func (b *spanBuilder) parent(g trace.GoID, task trace.TaskID) *Span {
+	stk := b.regions[g]
+	for i := len(stk) - 1; i >= 0; i-- {
+		if stk[i].Task == task {
+			return stk[i]
+		}
+	}
+	return b.task(task)
+}
+
+// task returns the task with the given ID, whether it's still running or
+// not, or nil if it's unknown.
+func (b *spanBuilder) task(id trace.TaskID) *Span {
+	if s, ok := b.tasks[id]; ok {
+		return s
+	}
+	return b.ended[id]
+}
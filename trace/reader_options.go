@@ -0,0 +1,387 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.21
+
+package trace
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+
+	"golang.org/x/exp/trace/internal/version"
+)
+
+// ReaderOptions configures a ReaderWithOptions.
+type ReaderOptions struct {
+	// Parallelism is the maximum number of generations to decode ahead of
+	// the one the ReaderWithOptions is returning events from, on separate
+	// goroutines. Decoding a generation means parsing its batches, string
+	// table, and stack table, which for large traces takes a significant
+	// fraction of the time to read them. If Parallelism is zero,
+	// generations are decoded one at a time as they're reached.
+	//
+	// A parallel ReaderWithOptions returns exactly the same events as any
+	// other. It may read ahead of the current generation in the underlying
+	// reader, and holds up to Parallelism more generations in memory. Call
+	// Close to stop its goroutines when done with it, in particular when
+	// abandoning it before the end of the trace; otherwise they only exit
+	// once it's garbage collected.
+	Parallelism int
+
+	// Tolerant makes the ReaderWithOptions skip over damage to the trace
+	// instead of returning an error, so that the rest of the trace can
+	// still be read. This is useful for traces from processes that crashed
+	// or were killed, and for flight recorder dumps, which are often
+	// truncated.
+	//
+	// A tolerant ReaderWithOptions skips
+	//   - a batch that can't be decoded, as if it weren't in the trace;
+	//   - a generation that can't be decoded, for example because it's
+	//     missing its frequency batch;
+	//   - a generation at the end of the trace that can't be read in full,
+	//     after which the trace ends;
+	//   - the rest of a generation whose events can't be ordered.
+	// After it skips a generation, or the rest of one, it reads the next
+	// generation as if it were the first in the trace, so the state of
+	// goroutines and procs may become undetermined again.
+	//
+	// Tolerant has no effect on traces produced by Go 1.21 and earlier.
+	Tolerant bool
+
+	// OnSkip, if not nil, is called with each part of the trace that a
+	// tolerant ReaderWithOptions skips, before ReadEvent returns the next
+	// event.
+	OnSkip func(Skipped)
+}
+
+// Skipped describes part of a trace that a tolerant ReaderWithOptions
+// skipped.
+type Skipped struct {
+	// Gen is the generation of the skipped data, or zero if it's unknown.
+	Gen uint64
+
+	// Offset is the offset in bytes from the start of the trace of the
+	// skipped data, and Length is its length in bytes. Length is -1 if the
+	// skipped data runs to the end of the trace. Both are -1 if the data
+	// isn't contiguous, as when the ReaderWithOptions skips the rest of a
+	// generation's events because it can't order them.
+	Offset, Length int64
+
+	// Err is the problem with the skipped data.
+	Err error
+}
+
+// Position identifies where in a trace's wire format an event or error came
+// from, for diagnosing problems with the trace.
+type Position struct {
+	// Gen is the number of the generation, or zero if it's unknown.
+	Gen uint64
+
+	// Offset is the offset in bytes from the start of the trace of the batch
+	// the event came from, or -1 if the event doesn't come from a single
+	// batch. CPU samples and Sync events, for example, don't.
+	Offset int64
+
+	// Thread is the thread that wrote the batch, or NoThread if there's no
+	// batch.
+	Thread ThreadID
+}
+
+// noPosition is the position of events that don't come from a batch.
+func noPosition(gen uint64) Position {
+	return Position{Gen: gen, Offset: -1, Thread: NoThread}
+}
+
+// ReaderWithOptions is a Reader configured by ReaderOptions. It also
+// reports the Position in the trace of each event it reads.
+//
+// Apart from the parts of a trace that a tolerant ReaderWithOptions skips,
+// it returns the same events as a Reader.
+type ReaderWithOptions struct {
+	// r orders the events of the current generation. The generations of
+	// traces produced by Go 1.22 and later are read and decoded outside
+	// of r, which is given them one at a time, and reports io.EOF when
+	// it's done with each. Older traces are read by r itself.
+	r *Reader
+
+	gens     *generationReader   // nil for traces produced by Go 1.21 and earlier
+	pipeline *generationPipeline // decodes generations from gens ahead of time, if parallel
+	tolerant bool
+	onSkip   func(Skipped)
+	needGen  bool // r has no generation, or is done with it
+	closed   bool
+
+	// offsets are the offsets of the current generation's event batches.
+	// See decodedGeneration.
+	offsets map[ThreadID][]int64
+
+	// pos is the position of the last event returned, or of the last error.
+	// positions are the positions of the events in r's ordering queue.
+	pos       Position
+	positions queue[Position]
+	cursors   []cursorState
+}
+
+// cursorState is where a batchCursor was before reading an event.
+type cursorState struct {
+	bc           *batchCursor
+	idx, dataOff int
+}
+
+// NewReaderWithOptions creates a new trace reader configured by opts.
+// Options that don't apply to the trace's version are ignored.
+func NewReaderWithOptions(r io.Reader, opts ReaderOptions) (*ReaderWithOptions, error) {
+	return newReaderWithOptions(r, opts, 0)
+}
+
+// newReaderWithOptions is NewReaderWithOptions for a trace whose first byte
+// is at offset base in the original trace.
+func newReaderWithOptions(r io.Reader, opts ReaderOptions, base int64) (*ReaderWithOptions, error) {
+	if opts.Parallelism < 0 {
+		return nil, fmt.Errorf("invalid parallelism %d: must be non-negative", opts.Parallelism)
+	}
+	br := newTraceReader(r, base)
+	v, err := version.ReadHeader(br)
+	if err != nil {
+		return nil, err
+	}
+	switch v {
+	case version.Go122, version.Go123:
+		tr := &ReaderWithOptions{
+			r:        &Reader{order: newOrdering()},
+			gens:     &generationReader{r: br, tolerant: opts.Tolerant},
+			tolerant: opts.Tolerant,
+			onSkip:   opts.OnSkip,
+			needGen:  true,
+			pos:      noPosition(0),
+		}
+		if opts.Parallelism > 0 {
+			tr.pipeline = newGenerationPipeline(tr.gens, opts.Parallelism)
+			runtime.SetFinalizer(tr, func(tr *ReaderWithOptions) { tr.pipeline.stop() })
+		}
+		return tr, nil
+	default:
+		// Let a Reader convert older traces, or reject the version.
+		var hdr bytes.Buffer
+		if _, err := version.WriteHeader(&hdr, v); err != nil {
+			return nil, err
+		}
+		tr, err := NewReader(io.MultiReader(&hdr, br))
+		if err != nil {
+			return nil, err
+		}
+		return &ReaderWithOptions{r: tr, pos: noPosition(0)}, nil
+	}
+}
+
+// newOrdering returns the ordering state for the start of a trace, as
+// NewReader sets it up.
+func newOrdering() ordering {
+	return ordering{
+		mStates:     make(map[ThreadID]*mState),
+		pStates:     make(map[ProcID]*pState),
+		gStates:     make(map[GoID]*gState),
+		activeTasks: make(map[TaskID]taskState),
+	}
+}
+
+// ReadEvent reads a single event from the stream.
+//
+// If the stream has been exhausted, it returns an invalid
+// event and io.EOF.
+func (r *ReaderWithOptions) ReadEvent() (Event, error) {
+	if r.closed {
+		return Event{}, errReaderClosed
+	}
+	if r.gens == nil {
+		return r.r.ReadEvent()
+	}
+	for {
+		if r.needGen {
+			if err := r.nextGeneration(); err != nil {
+				return Event{}, err
+			}
+		}
+		ev, err := r.readEvent()
+		switch {
+		case err == io.EOF:
+			r.needGen = true
+		case err != nil && r.tolerant:
+			// The only errors a tolerant ReaderWithOptions gets from r
+			// are from ordering the current generation's events.
+			r.abandonGeneration(err)
+		default:
+			return ev, err
+		}
+	}
+}
+
+// errReaderClosed is returned by ReadEvent after Close.
+var errReaderClosed = errors.New("trace: read from closed ReaderWithOptions")
+
+// Close stops the goroutines that decode generations ahead for a parallel
+// ReaderWithOptions, and releases the generations they decoded. A goroutine
+// that's blocked reading from the underlying reader exits once the read
+// returns. Close doesn't close the underlying reader. After Close, ReadEvent
+// returns an error.
+func (r *ReaderWithOptions) Close() error {
+	r.closed = true
+	if r.pipeline != nil {
+		r.pipeline.stop()
+		runtime.SetFinalizer(r, nil)
+	}
+	return nil
+}
+
+// Position returns the position in the trace of the last event returned by
+// ReadEvent, or, if ReadEvent returned an error, of the data that caused it,
+// as far as it's known.
+//
+// Traces produced by Go 1.21 and earlier are converted as a whole, so their
+// events don't have positions.
+func (r *ReaderWithOptions) Position() Position {
+	return r.pos
+}
+
+// nextGeneration gives r.r the next generation that can be read.
+func (r *ReaderWithOptions) nextGeneration() error {
+	for {
+		var d decodedGeneration
+		if r.pipeline != nil {
+			d = r.pipeline.next()
+		} else {
+			d = r.gens.decodeNext()
+		}
+		r.skip(d.skipped)
+		if d.err != nil {
+			r.pos = noPosition(0)
+			var te *traceError
+			if errors.As(d.err, &te) {
+				r.pos = te.pos
+			}
+			return d.err
+		}
+		if d.gen != nil {
+			return r.install(d)
+		}
+		// A tolerant ReaderWithOptions skipped the whole generation.
+	}
+}
+
+// install sets up r.r to read the events of d, the way Reader.ReadEvent
+// does for each generation it reads.
+func (r *ReaderWithOptions) install(d decodedGeneration) error {
+	in := r.r
+	if in.gen != nil && d.gen.gen != in.gen.gen+1 {
+		// A tolerant ReaderWithOptions skipped a generation, so the
+		// state at the end of the last one doesn't carry over.
+		in.order = newOrdering()
+	}
+	in.gen = d.gen
+	r.offsets = d.offsets
+	r.needGen = false
+
+	// Reset CPU samples cursor.
+	in.cpuSamples = in.gen.cpuSamples
+
+	// Reset frontier.
+	for _, m := range in.gen.batchMs {
+		bc := &batchCursor{m: m}
+		ok, err := bc.nextEvent(in.gen.batches[m], in.gen.freq)
+		if err != nil {
+			r.pos = r.batchPosition(bc.m, bc.idx)
+			return err
+		}
+		if !ok {
+			// Turns out there aren't actually any events in these batches.
+			continue
+		}
+		in.frontier = heapInsert(in.frontier, bc)
+	}
+
+	// Reset emittedSync.
+	in.emittedSync = false
+	return nil
+}
+
+// readEvent reads an event from r.r and works out its position.
+func (r *ReaderWithOptions) readEvent() (Event, error) {
+	in := r.r
+	if in.order.queue.end != in.order.queue.start {
+		// The event was queued along with an earlier one.
+		ev, err := in.ReadEvent()
+		r.pos, _ = r.positions.pop()
+		return ev, err
+	}
+
+	// The event comes from the batch cursor that moves, if any.
+	r.cursors = r.cursors[:0]
+	for _, bc := range in.frontier {
+		r.cursors = append(r.cursors, cursorState{bc, bc.idx, bc.dataOff})
+	}
+	samples := len(in.cpuSamples)
+	ev, err := in.ReadEvent()
+	if len(in.cpuSamples) != samples {
+		r.pos = noPosition(in.gen.gen)
+		return ev, err
+	}
+	for _, c := range r.cursors {
+		if c.bc.idx == c.idx && c.bc.dataOff == c.dataOff {
+			continue
+		}
+		if err != nil {
+			// The cursor failed to read its next event.
+			r.pos = r.batchPosition(c.bc.m, c.bc.idx)
+			return ev, err
+		}
+		r.pos = r.batchPosition(c.bc.m, c.idx)
+		for n := in.order.queue.end - in.order.queue.start; n > 0; n-- {
+			r.positions.push(r.pos)
+		}
+		return ev, err
+	}
+	if err != nil && len(in.frontier) != 0 {
+		// The events at the front of the frontier can't be ordered.
+		r.pos = r.batchPosition(in.frontier[0].m, in.frontier[0].idx)
+	} else {
+		r.pos = noPosition(in.gen.gen)
+	}
+	return ev, err
+}
+
+// batchPosition returns the position of m's i'th batch in the current
+// generation.
+func (r *ReaderWithOptions) batchPosition(m ThreadID, i int) Position {
+	offsets := r.offsets[m]
+	if i >= len(offsets) {
+		return noPosition(r.r.gen.gen)
+	}
+	return Position{Gen: r.r.gen.gen, Offset: offsets[i], Thread: m}
+}
+
+// abandonGeneration skips the rest of the current generation after err,
+// for a tolerant ReaderWithOptions. The next generation is read as if it
+// were the first.
+func (r *ReaderWithOptions) abandonGeneration(err error) {
+	in := r.r
+	r.skip([]Skipped{{Gen: in.gen.gen, Offset: -1, Length: -1, Err: err}})
+	in.frontier = nil
+	in.cpuSamples = nil
+	in.order = newOrdering()
+	r.positions = queue[Position]{}
+}
+
+// skip reports skipped parts of the trace.
+func (r *ReaderWithOptions) skip(skipped []Skipped) {
+	if r.onSkip == nil {
+		return
+	}
+	for _, s := range skipped {
+		r.onSkip(s)
+	}
+}
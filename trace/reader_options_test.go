@@ -0,0 +1,133 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.21
+
+package trace
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"unsafe"
+
+	"golang.org/x/exp/trace/internal/raw"
+	"golang.org/x/tools/txtar"
+)
+
+// readerFieldsReadOutside are the fields of Reader that a ReaderWithOptions
+// doesn't use, because it reads generations outside of its Reader.
+var readerFieldsReadOutside = map[string]bool{"r": true, "spill": true, "spillErr": true}
+
+// TestReaderWithOptionsState checks that a ReaderWithOptions sets up its
+// Reader for each generation exactly as a Reader sets itself up, by
+// comparing their fields after every event of every test trace. It catches
+// a ReaderWithOptions falling out of step with a regenerated Reader.
+func TestReaderWithOptionsState(t *testing.T) {
+	matches, err := filepath.Glob("testdata/tests/*.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range matches {
+		data := readTestTrace(t, path)
+		for _, parallelism := range []int{0, 2} {
+			t.Run(fmt.Sprintf("%s/parallelism=%d", filepath.Base(path), parallelism), func(t *testing.T) {
+				want, err := NewReader(bytes.NewReader(data))
+				if err != nil {
+					t.Fatal(err)
+				}
+				got, err := NewReaderWithOptions(bytes.NewReader(data), ReaderOptions{Parallelism: parallelism})
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer got.Close()
+				var gen *generation
+				for i := 0; ; i++ {
+					wantEv, wantErr := want.ReadEvent()
+					gotEv, gotErr := got.ReadEvent()
+					if fmt.Sprint(gotErr) != fmt.Sprint(wantErr) {
+						t.Fatalf("event %d: got error %v, want %v", i, gotErr, wantErr)
+					}
+					if wantErr != nil {
+						return
+					}
+					if !reflect.DeepEqual(gotEv, wantEv) {
+						t.Fatalf("event %d: got %v, want %v", i, gotEv, wantEv)
+					}
+					// Generations are big, so only compare them when they
+					// change.
+					compareReaders(t, i, got.r, want, want.gen != gen)
+					gen = want.gen
+				}
+			})
+		}
+	}
+}
+
+// compareReaders compares the fields of got and want, apart from those that
+// a ReaderWithOptions doesn't use, and the generations unless withGen is
+// set.
+func compareReaders(t *testing.T, i int, got, want *Reader, withGen bool) {
+	t.Helper()
+
+	gv, wv := reflect.ValueOf(got).Elem(), reflect.ValueOf(want).Elem()
+	for j := 0; j < wv.NumField(); j++ {
+		name := wv.Type().Field(j).Name
+		if readerFieldsReadOutside[name] || name == "gen" && !withGen {
+			continue
+		}
+		if !reflect.DeepEqual(fieldValue(gv.Field(j)), fieldValue(wv.Field(j))) {
+			t.Fatalf("after event %d: Reader.%s differs", i, name)
+		}
+	}
+}
+
+// fieldValue returns the value of the addressable field f, which may be
+// unexported.
+func fieldValue(f reflect.Value) any {
+	return reflect.NewAt(f.Type(), unsafe.Pointer(f.UnsafeAddr())).Elem().Interface()
+}
+
+// readTestTrace returns the trace in the wire format from a test file. It's
+// like testtrace.ParseFile, which can't be used here because testtrace
+// imports this package.
+func readTestTrace(t *testing.T, path string) []byte {
+	t.Helper()
+
+	ar, err := txtar.ParseFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var data []byte
+	for _, f := range ar.Files {
+		if f.Name == "trace" {
+			data = f.Data
+		}
+	}
+	tr, err := raw.NewTextReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("%s: %v", path, err)
+	}
+	var buf bytes.Buffer
+	tw, err := raw.NewWriter(&buf, tr.Version())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for {
+		ev, err := tr.ReadEvent()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		if err := tw.WriteEvent(ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,126 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.21
+
+package trace_test
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+
+	"golang.org/x/exp/trace"
+	"golang.org/x/exp/trace/internal/testtrace"
+)
+
+func TestReaderPosition(t *testing.T) {
+	data := generationsTrace(t, 3)
+	idx, err := trace.BuildIndex(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := trace.NewReaderWithOptions(bytes.NewReader(data), trace.ReaderOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for {
+		ev, err := r.ReadEvent()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		pos := r.Position()
+		if pos.Gen < 1 || pos.Gen > 3 {
+			t.Fatalf("event %v has bad generation in position %+v", ev, pos)
+		}
+		if ev.Kind() == trace.EventSync {
+			if pos.Offset != -1 || pos.Thread != trace.NoThread {
+				t.Errorf("sync event has position %+v", pos)
+			}
+			continue
+		}
+		g := idx.Generations[pos.Gen-1]
+		if pos.Offset < g.Offset || pos.Offset >= g.Offset+g.Length || pos.Thread != ev.Thread() {
+			t.Errorf("event %v has position %+v outside of generation %+v", ev, pos, g)
+		}
+	}
+}
+
+func TestReaderPositionError(t *testing.T) {
+	tr, _, err := testtrace.ParseFile("testdata/tests/go122-fail-first-gen-first.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := trace.NewReaderWithOptions(tr, trace.ReaderOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for {
+		_, err := r.ReadEvent()
+		if err == io.EOF {
+			t.Fatal("expected an error")
+		}
+		if err != nil {
+			break
+		}
+	}
+	// The GoCreate event that fails is in the second batch, after the
+	// 16 byte header and the 10 byte frequency batch.
+	want := trace.Position{Gen: 1, Offset: 26, Thread: 0}
+	if got := r.Position(); got != want {
+		t.Errorf("got position %+v for error, want %+v", got, want)
+	}
+}
+
+func TestReaderPositionSeek(t *testing.T) {
+	data := generationsTrace(t, 3)
+	ir, err := trace.NewIndexedReader(bytes.NewReader(data), int64(len(data)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := trace.NewReaderWithOptions(bytes.NewReader(data), trace.ReaderOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := readPositions(t, r)
+	for i, g := range ir.Index().Generations {
+		r, err := ir.SeekGenerationWithOptions(i, trace.ReaderOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := readPositions(t, r)
+		if len(got) == 0 || got[0].Gen != g.Gen || got[0].Offset < g.Offset || got[0].Offset >= g.Offset+g.Length {
+			t.Fatalf("generation %d: got first position %+v, want one in %+v", i, got, g)
+		}
+		// Reading from the generation finds the same batches as reading
+		// from the start of the trace.
+		if rest := want[len(want)-len(got):]; !reflect.DeepEqual(got, rest) {
+			t.Errorf("generation %d: got positions %+v, want %+v", i, got, rest)
+		}
+	}
+}
+
+// readPositions returns the positions of the events read by r that come
+// from batches.
+func readPositions(t *testing.T, r *trace.ReaderWithOptions) []trace.Position {
+	t.Helper()
+
+	var positions []trace.Position
+	for {
+		_, err := r.ReadEvent()
+		if err == io.EOF {
+			return positions
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pos := r.Position(); pos.Offset >= 0 {
+			positions = append(positions, pos)
+		}
+	}
+}
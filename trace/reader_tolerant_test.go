@@ -0,0 +1,148 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.21
+
+package trace_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"reflect"
+	"testing"
+
+	"golang.org/x/exp/trace"
+	"golang.org/x/exp/trace/internal/testtrace"
+)
+
+// readTolerant reads the logs from data with a tolerant Reader, and returns
+// them along with what the Reader skipped.
+func readTolerant(t *testing.T, data []byte, parallelism int) ([]string, []trace.Skipped) {
+	t.Helper()
+
+	var skipped []trace.Skipped
+	r, err := trace.NewReaderWithOptions(bytes.NewReader(data), trace.ReaderOptions{
+		Parallelism: parallelism,
+		Tolerant:    true,
+		OnSkip:      func(s trace.Skipped) { skipped = append(skipped, s) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return readLogs(t, r), skipped
+}
+
+func TestReaderTolerantTruncated(t *testing.T) {
+	data := generationsTrace(t, 4)
+	idx, err := trace.BuildIndex(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := idx.Generations[3]
+	for _, parallelism := range []int{0, 2} {
+		t.Run(fmt.Sprintf("Parallelism=%d", parallelism), func(t *testing.T) {
+			logs, skipped := readTolerant(t, data[:len(data)-1], parallelism)
+			if want := []string{"1", "2", "3"}; !reflect.DeepEqual(logs, want) {
+				t.Errorf("got logs %q, want %q", logs, want)
+			}
+			if len(skipped) != 1 {
+				t.Fatalf("got skipped %+v, want the last generation", skipped)
+			}
+			if s := skipped[0]; s.Gen != 4 || s.Offset != last.Offset || s.Length != -1 || s.Err == nil {
+				t.Errorf("got skipped %+v, want generation 4 from offset %d to the end", s, last.Offset)
+			}
+		})
+	}
+}
+
+func TestReaderTolerantCorruptBatch(t *testing.T) {
+	data := generationsTrace(t, 4)
+
+	// Find the event batch of the second generation, from the position of
+	// its log event.
+	r, err := trace.NewReaderWithOptions(bytes.NewReader(data), trace.ReaderOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	off := int64(-1)
+	for off < 0 {
+		ev, err := r.ReadEvent()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ev.Kind() == trace.EventLog && ev.Log().Message == "2" {
+			off = r.Position().Offset
+		}
+	}
+
+	// Replace its first event with an invalid event type. The batch header
+	// is a byte followed by the generation, thread, time, and size.
+	bad := bytes.Clone(data)
+	n := 1
+	for i := 0; i < 4; i++ {
+		_, m := binary.Uvarint(bad[off+int64(n):])
+		n += m
+	}
+	bad[off+int64(n)] = 0xff
+
+	for _, parallelism := range []int{0, 2} {
+		t.Run(fmt.Sprintf("Parallelism=%d", parallelism), func(t *testing.T) {
+			logs, skipped := readTolerant(t, bad, parallelism)
+			if want := []string{"1", "3", "4"}; !reflect.DeepEqual(logs, want) {
+				t.Errorf("got logs %q, want %q", logs, want)
+			}
+			if len(skipped) != 1 {
+				t.Fatalf("got skipped %+v, want one batch", skipped)
+			}
+			if s := skipped[0]; s.Gen != 2 || s.Offset != off || s.Length <= int64(n) || s.Err == nil {
+				t.Errorf("got skipped %+v, want the batch at offset %d", s, off)
+			}
+		})
+	}
+
+	// Without Tolerant, the Reader fails at the bad batch.
+	r, err = trace.NewReaderWithOptions(bytes.NewReader(bad), trace.ReaderOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for {
+		_, err := r.ReadEvent()
+		if err == io.EOF {
+			t.Fatal("read a corrupt trace without error")
+		}
+		if err != nil {
+			break
+		}
+	}
+}
+
+func TestReaderTolerantUnordered(t *testing.T) {
+	tr, _, err := testtrace.ParseFile("testdata/tests/go122-fail-first-gen-first.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(tr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, parallelism := range []int{0, 2} {
+		t.Run(fmt.Sprintf("Parallelism=%d", parallelism), func(t *testing.T) {
+			_, skipped := readTolerant(t, data, parallelism)
+			// The second generation is truncated, which is found when
+			// reading the first, and then the rest of the first can't be
+			// ordered.
+			if len(skipped) != 2 {
+				t.Fatalf("got skipped %+v, want two generations", skipped)
+			}
+			if s := skipped[0]; s.Gen != 2 || s.Length != -1 || s.Err == nil {
+				t.Errorf("got skipped %+v, want generation 2 to the end", s)
+			}
+			if s := skipped[1]; s.Gen != 1 || s.Offset != -1 || s.Length != -1 || s.Err == nil {
+				t.Errorf("got skipped %+v, want the rest of generation 1", s)
+			}
+		})
+	}
+}
@@ -0,0 +1,209 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.21
+
+package trace
+
+import (
+	"errors"
+	"io"
+	"time"
+
+	"golang.org/x/exp/trace/internal/event/go122"
+)
+
+// SliceOptions describes the part of a trace to extract with Slice.
+type SliceOptions struct {
+	// Start and End bound the time window to extract, as offsets from
+	// the first event in the trace. If End is zero, the window extends
+	// to the end of the trace.
+	Start, End time.Duration
+
+	// Goroutines, if not empty, restricts the output to events for
+	// the listed goroutines.
+	Goroutines []GoID
+}
+
+// Slice reads a trace from r and writes the part of it selected by opts to w.
+//
+// If there are no events in the selected part of the trace, Slice returns
+// ErrEmptySlice, and the data written to w is not a valid trace.
+//
+// The result is a self-consistent trace that may be read with NewReader.
+// It begins with the state of all goroutines and procs at the start of
+// the time window, as well as any GC cycles, sweeps, and mark assists in
+// progress. Tasks that began before the window are begun again on the first
+// running goroutine inside it. Regions that began before the window end
+// without a corresponding begin event, and tasks and regions that end after
+// the window never end, both of which readers of the trace treat as being
+// clamped to the bounds of the trace. Stop-the-world phases that began before
+// the window are dropped.
+//
+// When slicing by goroutine, events that must happen on a goroutine outside
+// the set are dropped, while events that only need a thread or proc, such as
+// procs starting and stopping, are attributed to no goroutine. If a goroutine
+// outside the set enters a syscall, its proc is shown as running until it's
+// stolen, at which point the proc stops. GC cycles are always dropped, because
+// they usually begin and end on different goroutines.
+func Slice(w io.Writer, r io.Reader, opts SliceOptions) error {
+	tr, err := NewReader(r)
+	if err != nil {
+		return err
+	}
+	tw, err := NewWriter(w)
+	if err != nil {
+		return err
+	}
+	s := newSlicer(tw, opts)
+	for {
+		ev, err := tr.ReadEvent()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		done, err := s.event(ev)
+		if err != nil {
+			return err
+		}
+		if done {
+			break
+		}
+	}
+	if !s.output {
+		return ErrEmptySlice
+	}
+	return tw.Close()
+}
+
+// ErrEmptySlice is returned by Slice if there are no events to write.
+var ErrEmptySlice = errors.New("no events in the selected part of the trace")
+
+// slicer filters a stream of events for Slice.
+type slicer struct {
+	w          *Writer
+	opts       SliceOptions
+	evs        []Event
+	haveStart  bool
+	start, end Time
+	output     bool // whether we've reached the start of the time window.
+
+	// State for slicing by goroutine.
+	goroutines    map[GoID]bool
+	hiddenSyscall map[ProcID]ThreadID // procs held in a syscall by goroutines outside the set.
+	stopped       map[ProcID]bool     // procs we stopped that the trace considers abandoned.
+}
+
+func newSlicer(w *Writer, opts SliceOptions) *slicer {
+	w.skip = true
+	s := &slicer{w: w, opts: opts}
+	if len(opts.Goroutines) != 0 {
+		s.goroutines = make(map[GoID]bool)
+		for _, g := range opts.Goroutines {
+			s.goroutines[g] = true
+		}
+		s.hiddenSyscall = make(map[ProcID]ThreadID)
+		s.stopped = make(map[ProcID]bool)
+	}
+	return s
+}
+
+// event processes the next event in the trace, and returns true once
+// there's nothing more to write.
+func (s *slicer) event(ev Event) (bool, error) {
+	if !s.haveStart {
+		s.haveStart = true
+		s.start = ev.Time() + Time(s.opts.Start)
+		if s.opts.End != 0 {
+			s.end = ev.Time() + Time(s.opts.End)
+		}
+	}
+	if s.end != 0 && ev.Time() > s.end {
+		return true, nil
+	}
+	s.evs = s.project(ev, s.evs[:0])
+	for _, ev := range s.evs {
+		if !s.output && ev.Time() >= s.start && ev.ctx.M != NoThread && ev.base.typ != evSync && ev.base.typ != go122.EvCPUSample {
+			s.w.startOutput(ev)
+			s.output = true
+		}
+		if err := s.w.WriteEvent(ev); err != nil {
+			return false, err
+		}
+	}
+	return false, nil
+}
+
+// project appends the events that ev turns into after removing goroutines
+// outside the set to evs.
+func (s *slicer) project(ev Event, evs []Event) []Event {
+	if s.goroutines == nil {
+		return append(evs, ev)
+	}
+	hidden := ev.ctx.G != NoGoroutine && !s.goroutines[ev.ctx.G]
+	if hidden {
+		ev.ctx.G = NoGoroutine
+	}
+	switch ev.base.typ {
+	case go122.EvProcStatus:
+		pid := ProcID(ev.base.args[0])
+		switch go122.ProcStatus(ev.base.args[1]) {
+		case go122.ProcSyscall:
+			if _, ok := s.hiddenSyscall[pid]; ok {
+				ev.base.args[1] = uint64(go122.ProcRunning)
+			}
+		case go122.ProcSyscallAbandoned:
+			if s.stopped[pid] {
+				ev.base.args[1] = uint64(go122.ProcIdle)
+			}
+		}
+	case go122.EvProcStart:
+		delete(s.stopped, ProcID(ev.base.args[0]))
+	case go122.EvProcSteal:
+		pid := ProcID(ev.base.args[0])
+		mid, ok := s.hiddenSyscall[pid]
+		if !ok {
+			break
+		}
+		// As far as the output is concerned, the proc never entered a syscall,
+		// so it can't be stolen. Stop it on its own thread instead.
+		delete(s.hiddenSyscall, pid)
+		if ev.base.args[1] == 0 {
+			// This is the reader's self-steal for a GoDestroySyscall,
+			// which leaves the proc abandoned.
+			s.stopped[pid] = true
+		}
+		return append(evs, makeEvent(ev.table, schedCtx{M: mid, P: pid, G: NoGoroutine}, go122.EvProcStop, ev.Time()))
+	case go122.EvGoStatus, go122.EvGoStatusStack, go122.EvGCMarkAssistActive,
+		go122.EvGoCreate, go122.EvGoCreateBlocked, go122.EvGoCreateSyscall,
+		go122.EvGoStart, go122.EvGoUnblock, go122.EvGoSwitch, go122.EvGoSwitchDestroy:
+		if !s.goroutines[GoID(ev.base.args[0])] {
+			return evs
+		}
+	case go122.EvGoSyscallBegin:
+		if hidden {
+			s.hiddenSyscall[ev.ctx.P] = ev.ctx.M
+			return evs
+		}
+	case go122.EvGoSyscallEnd:
+		if hidden {
+			delete(s.hiddenSyscall, ev.ctx.P)
+			return evs
+		}
+	case go122.EvGCActive, go122.EvGCBegin, go122.EvGCEnd:
+		return evs
+	case go122.EvGoDestroy, go122.EvGoStop, go122.EvGoBlock, go122.EvGoSyscallEndBlocked, go122.EvGoDestroySyscall,
+		go122.EvProcsChange, go122.EvGoLabel, go122.EvUserLog,
+		go122.EvUserTaskBegin, go122.EvUserTaskEnd, go122.EvUserRegionBegin, go122.EvUserRegionEnd,
+		go122.EvSTWBegin, go122.EvSTWEnd, go122.EvGCMarkAssistBegin, go122.EvGCMarkAssistEnd,
+		go122.EvCPUSample:
+		// These events must have a goroutine, or are about the goroutine.
+		if hidden {
+			return evs
+		}
+	}
+	return append(evs, ev)
+}
@@ -0,0 +1,178 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.21
+
+package trace_test
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"golang.org/x/exp/trace"
+	"golang.org/x/exp/trace/internal/testtrace"
+)
+
+func TestSlice(t *testing.T) {
+	matches, err := filepath.Glob("./testdata/tests/*.test")
+	if err != nil {
+		t.Fatalf("failed to glob for tests: %v", err)
+	}
+	for _, testPath := range matches {
+		testPath := testPath
+		testName, err := filepath.Rel("./testdata", testPath)
+		if err != nil {
+			t.Fatalf("failed to relativize testdata path: %v", err)
+		}
+		t.Run(testName, func(t *testing.T) {
+			tr, exp, err := testtrace.ParseFile(testPath)
+			if err != nil {
+				t.Fatalf("failed to parse test file at %s: %v", testPath, err)
+			}
+			if exp.Check(nil) != nil {
+				t.Skip("trace is expected to fail to parse")
+			}
+			b, err := io.ReadAll(tr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			evs := readAllEvents(t, bytes.NewReader(b))
+			dur := time.Duration(evs[len(evs)-1].Time() - evs[0].Time())
+
+			var goroutines []trace.GoID
+			for _, ev := range evs {
+				if ev.Kind() == trace.EventStateTransition {
+					if st := ev.StateTransition(); st.Resource.Kind == trace.ResourceGoroutine {
+						if g := st.Resource.Goroutine(); !slices.Contains(goroutines, g) {
+							goroutines = append(goroutines, g)
+						}
+					}
+				}
+			}
+			if len(goroutines) > 5 {
+				goroutines = goroutines[:5]
+			}
+
+			const steps = 8
+			for i := 0; i < steps; i++ {
+				opts := trace.SliceOptions{Start: dur * time.Duration(i) / steps, End: dur * time.Duration(i+2) / steps}
+				testSlice(t, b, opts)
+				for _, g := range goroutines {
+					opts.Goroutines = []trace.GoID{g}
+					testSlice(t, b, opts)
+				}
+				opts.Goroutines = goroutines
+				testSlice(t, b, opts)
+			}
+		})
+	}
+}
+
+func testSlice(t *testing.T, tr []byte, opts trace.SliceOptions) {
+	t.Helper()
+
+	name := fmt.Sprintf("%v-%v-%v", opts.Start, opts.End, opts.Goroutines)
+	var buf bytes.Buffer
+	if err := trace.Slice(&buf, bytes.NewReader(tr), opts); err == trace.ErrEmptySlice {
+		return
+	} else if err != nil {
+		t.Errorf("%s: %v", name, err)
+		return
+	}
+	r, err := trace.NewReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Errorf("%s: %v", name, err)
+		return
+	}
+	evs := readAllEvents(t, bytes.NewReader(tr))
+	start := evs[0].Time() + trace.Time(opts.Start)
+	end := evs[0].Time() + trace.Time(opts.End)
+	v := testtrace.NewValidator()
+	for {
+		ev, err := r.ReadEvent()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			return
+		}
+		if err := v.Event(ev); err != nil {
+			t.Errorf("%s: %v", name, err)
+			return
+		}
+		if ev.Kind() == trace.EventSync {
+			continue
+		}
+		// Synthesized events appear just before the first event in the window.
+		if ev.Time() < start-trace.Time(time.Millisecond) || ev.Time() > end+trace.Time(time.Millisecond) {
+			t.Errorf("%s: event outside of time window [%d, %d]: %s", name, start, end, ev.String())
+		}
+		if opts.Goroutines == nil {
+			continue
+		}
+		if g := ev.Goroutine(); g != trace.NoGoroutine && !slices.Contains(opts.Goroutines, g) {
+			t.Errorf("%s: event for goroutine outside the set: %s", name, ev.String())
+		}
+		if ev.Kind() == trace.EventStateTransition {
+			st := ev.StateTransition()
+			if st.Resource.Kind == trace.ResourceGoroutine && !slices.Contains(opts.Goroutines, st.Resource.Goroutine()) {
+				t.Errorf("%s: transition for goroutine outside the set: %s", name, ev.String())
+			}
+		}
+	}
+}
+
+func TestSliceTasks(t *testing.T) {
+	tr, _, err := testtrace.ParseFile("./testdata/tests/go122-annotations.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(tr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Find a task that begins and ends, and cut the trace in the middle of it.
+	evs := readAllEvents(t, bytes.NewReader(b))
+	begins := make(map[trace.TaskID]trace.Event)
+	var begin, end trace.Event
+	for _, ev := range evs {
+		switch ev.Kind() {
+		case trace.EventTaskBegin:
+			begins[ev.Task().ID] = ev
+		case trace.EventTaskEnd:
+			if b, ok := begins[ev.Task().ID]; ok && end.Kind() == trace.EventBad {
+				begin, end = b, ev
+			}
+		}
+	}
+	if end.Kind() == trace.EventBad {
+		t.Fatal("no task found")
+	}
+	var buf bytes.Buffer
+	opts := trace.SliceOptions{Start: time.Duration(begin.Time()-evs[0].Time()) + 1}
+	if err := trace.Slice(&buf, bytes.NewReader(b), opts); err != nil {
+		t.Fatal(err)
+	}
+	var found bool
+	for _, ev := range readAllEvents(t, bytes.NewReader(buf.Bytes())) {
+		if ev.Kind() == trace.EventTaskBegin && ev.Task() == begin.Task() {
+			found = true
+			if ev.Time() <= begin.Time() {
+				t.Errorf("task began at %d, before the start of the slice at %d", ev.Time(), begin.Time()+1)
+			}
+		}
+		if ev.Kind() == trace.EventTaskEnd && ev.Task().ID == begin.Task().ID && !found {
+			t.Errorf("task %d ended without beginning", begin.Task().ID)
+		}
+	}
+	if !found {
+		t.Errorf("task %d not found in slice", begin.Task().ID)
+	}
+}
@@ -0,0 +1,258 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.21
+
+package tracetest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	"golang.org/x/exp/trace"
+	"golang.org/x/exp/trace/internal/event"
+	"golang.org/x/exp/trace/internal/event/go122"
+)
+
+// Batch is a batch of events written by a single thread.
+//
+// Each method adds an event at time t, with the stack stk, if it has one.
+// A nil stack means the event has none.
+type Batch struct {
+	gen    *Generation
+	thread trace.ThreadID
+	events []batchEvent
+}
+
+type batchEvent struct {
+	time trace.Time
+	data []byte // the event's type and arguments, less its timestamp
+}
+
+// ProcStatus adds the status of proc p at the start of the generation. If
+// the proc is running, it's running on the batch's thread.
+func (b *Batch) ProcStatus(t trace.Time, p trace.ProcID, state trace.ProcState) {
+	var status go122.ProcStatus
+	switch state {
+	case trace.ProcRunning:
+		status = go122.ProcRunning
+		b.gen.trace.threadProcs[b.thread] = p
+	case trace.ProcIdle:
+		status = go122.ProcIdle
+	default:
+		panic(fmt.Sprintf("can't add status %v for proc %d", state, p))
+	}
+	b.add(t, go122.EvProcStatus, uint64(p), uint64(status))
+}
+
+// ProcStart adds the start of proc p on the batch's thread.
+func (b *Batch) ProcStart(t trace.Time, p trace.ProcID) {
+	b.gen.trace.threadProcs[b.thread] = p
+	b.add(t, go122.EvProcStart, uint64(p), b.gen.procSeq(p))
+}
+
+// ProcStop adds the stop of the batch's thread's proc.
+func (b *Batch) ProcStop(t trace.Time) {
+	delete(b.gen.trace.threadProcs, b.thread)
+	b.add(t, go122.EvProcStop)
+}
+
+// ProcSteal adds the theft of proc p, which was in a system call on thread
+// m, by the batch's thread. The thread doesn't acquire the proc.
+func (b *Batch) ProcSteal(t trace.Time, p trace.ProcID, m trace.ThreadID) {
+	if q, ok := b.gen.trace.threadProcs[m]; ok && q == p {
+		delete(b.gen.trace.threadProcs, m)
+	}
+	b.add(t, go122.EvProcSteal, uint64(p), b.gen.procSeq(p), uint64(m))
+}
+
+// GoStatus adds the status of goroutine g at the start of the generation.
+// If the goroutine is running or in a system call, it's on the batch's
+// thread.
+func (b *Batch) GoStatus(t trace.Time, g trace.GoID, state trace.GoState) {
+	m := trace.NoThread
+	var status go122.GoStatus
+	switch state {
+	case trace.GoRunnable:
+		status = go122.GoRunnable
+	case trace.GoRunning:
+		status = go122.GoRunning
+		m = b.thread
+	case trace.GoSyscall:
+		status = go122.GoSyscall
+		m = b.thread
+	case trace.GoWaiting:
+		status = go122.GoWaiting
+	default:
+		panic(fmt.Sprintf("can't add status %v for goroutine %d", state, g))
+	}
+	b.add(t, go122.EvGoStatus, uint64(g), uint64(m), uint64(status))
+}
+
+// GoCreate adds the creation of goroutine g by the running goroutine, which
+// will start with the stack start.
+func (b *Batch) GoCreate(t trace.Time, g trace.GoID, start, stk []trace.StackFrame) {
+	b.add(t, go122.EvGoCreate, uint64(g), b.gen.stackID(start), b.gen.stackID(stk))
+}
+
+// GoStart adds the start of goroutine g on the batch's thread.
+func (b *Batch) GoStart(t trace.Time, g trace.GoID) {
+	b.add(t, go122.EvGoStart, uint64(g), b.gen.goSeq(g))
+}
+
+// GoStop adds the running goroutine's stop, for the given reason, such as
+// "preempted". The goroutine remains runnable.
+func (b *Batch) GoStop(t trace.Time, reason string, stk []trace.StackFrame) {
+	b.add(t, go122.EvGoStop, b.gen.stringID(reason), b.gen.stackID(stk))
+}
+
+// GoBlock adds the running goroutine blocking, for the given reason, such
+// as "chan receive".
+func (b *Batch) GoBlock(t trace.Time, reason string, stk []trace.StackFrame) {
+	b.add(t, go122.EvGoBlock, b.gen.stringID(reason), b.gen.stackID(stk))
+}
+
+// GoUnblock adds the unblocking of goroutine g, which becomes runnable.
+func (b *Batch) GoUnblock(t trace.Time, g trace.GoID, stk []trace.StackFrame) {
+	b.add(t, go122.EvGoUnblock, uint64(g), b.gen.goSeq(g), b.gen.stackID(stk))
+}
+
+// GoDestroy adds the exit of the running goroutine.
+func (b *Batch) GoDestroy(t trace.Time) {
+	b.add(t, go122.EvGoDestroy)
+}
+
+// GoSyscallBegin adds the running goroutine entering a system call.
+func (b *Batch) GoSyscallBegin(t trace.Time, stk []trace.StackFrame) {
+	p, ok := b.gen.trace.threadProcs[b.thread]
+	if !ok {
+		panic(fmt.Sprintf("thread %d has no proc for a system call", b.thread))
+	}
+	b.add(t, go122.EvGoSyscallBegin, b.gen.procSeq(p), b.gen.stackID(stk))
+}
+
+// GoSyscallEnd adds the goroutine in a system call on the batch's thread
+// returning from it, and continuing to run on the same proc.
+func (b *Batch) GoSyscallEnd(t trace.Time) {
+	b.add(t, go122.EvGoSyscallEnd)
+}
+
+// GoSyscallEndBlocked adds the goroutine in a system call on the batch's
+// thread returning from it after its proc was taken away, so that it
+// becomes runnable.
+func (b *Batch) GoSyscallEndBlocked(t trace.Time) {
+	delete(b.gen.trace.threadProcs, b.thread)
+	b.add(t, go122.EvGoSyscallEndBlocked)
+}
+
+// TaskBegin adds the beginning of task id, with the given parent and name.
+func (b *Batch) TaskBegin(t trace.Time, id, parent trace.TaskID, name string, stk []trace.StackFrame) {
+	b.add(t, go122.EvUserTaskBegin, uint64(id), uint64(parent), b.gen.stringID(name), b.gen.stackID(stk))
+}
+
+// TaskEnd adds the end of task id.
+func (b *Batch) TaskEnd(t trace.Time, id trace.TaskID, stk []trace.StackFrame) {
+	b.add(t, go122.EvUserTaskEnd, uint64(id), b.gen.stackID(stk))
+}
+
+// RegionBegin adds the beginning of a region of the given type in task,
+// on the running goroutine.
+func (b *Batch) RegionBegin(t trace.Time, task trace.TaskID, typ string, stk []trace.StackFrame) {
+	b.add(t, go122.EvUserRegionBegin, uint64(task), b.gen.stringID(typ), b.gen.stackID(stk))
+}
+
+// RegionEnd adds the end of a region of the given type in task, on the
+// running goroutine.
+func (b *Batch) RegionEnd(t trace.Time, task trace.TaskID, typ string, stk []trace.StackFrame) {
+	b.add(t, go122.EvUserRegionEnd, uint64(task), b.gen.stringID(typ), b.gen.stackID(stk))
+}
+
+// Log adds a log message in task, with the given category.
+func (b *Batch) Log(t trace.Time, task trace.TaskID, category, message string, stk []trace.StackFrame) {
+	b.add(t, go122.EvUserLog, uint64(task), b.gen.stringID(category), b.gen.stringID(message), b.gen.stackID(stk))
+}
+
+// GCBegin adds the beginning of a GC cycle.
+func (b *Batch) GCBegin(t trace.Time, stk []trace.StackFrame) {
+	b.add(t, go122.EvGCBegin, b.gen.trace.gcSeq(), b.gen.stackID(stk))
+}
+
+// GCActive adds the GC cycle in progress at the start of the generation.
+func (b *Batch) GCActive(t trace.Time) {
+	b.add(t, go122.EvGCActive, b.gen.trace.gcSeq())
+}
+
+// GCEnd adds the end of the GC cycle in progress.
+func (b *Batch) GCEnd(t trace.Time) {
+	b.add(t, go122.EvGCEnd, b.gen.trace.gcSeq())
+}
+
+// GCSweepBegin adds the beginning of a sweep by the batch's thread's proc.
+func (b *Batch) GCSweepBegin(t trace.Time, stk []trace.StackFrame) {
+	b.add(t, go122.EvGCSweepBegin, b.gen.stackID(stk))
+}
+
+// GCSweepActive adds the sweep by proc p in progress at the start of the
+// generation.
+func (b *Batch) GCSweepActive(t trace.Time, p trace.ProcID) {
+	b.add(t, go122.EvGCSweepActive, uint64(p))
+}
+
+// GCSweepEnd adds the end of the sweep by the batch's thread's proc, which
+// swept and reclaimed the given numbers of bytes.
+func (b *Batch) GCSweepEnd(t trace.Time, swept, reclaimed uint64) {
+	b.add(t, go122.EvGCSweepEnd, swept, reclaimed)
+}
+
+// GCMarkAssistBegin adds the beginning of a mark assist by the running
+// goroutine.
+func (b *Batch) GCMarkAssistBegin(t trace.Time, stk []trace.StackFrame) {
+	b.add(t, go122.EvGCMarkAssistBegin, b.gen.stackID(stk))
+}
+
+// GCMarkAssistActive adds the mark assist by goroutine g in progress at
+// the start of the generation.
+func (b *Batch) GCMarkAssistActive(t trace.Time, g trace.GoID) {
+	b.add(t, go122.EvGCMarkAssistActive, uint64(g))
+}
+
+// GCMarkAssistEnd adds the end of the running goroutine's mark assist.
+func (b *Batch) GCMarkAssistEnd(t trace.Time) {
+	b.add(t, go122.EvGCMarkAssistEnd)
+}
+
+// add adds an event of type typ at time t with the given arguments, apart
+// from its timestamp.
+func (b *Batch) add(t trace.Time, typ event.Type, args ...uint64) {
+	if n := len(b.events); n > 0 && t < b.events[n-1].time {
+		panic(fmt.Sprintf("%s event at time %d is before the last event in the batch, at %d", go122.EventString(typ), t, b.events[n-1].time))
+	}
+	b.events = append(b.events, batchEvent{time: t, data: appendArgs([]byte{byte(typ)}, args...)})
+}
+
+// writeTo writes the batch to buf, split into as many batches as it takes
+// to keep them within the maximum batch size.
+func (b *Batch) writeTo(buf *bytes.Buffer) {
+	var (
+		data  []byte
+		start trace.Time
+		last  trace.Time
+	)
+	for i, e := range b.events {
+		if i == 0 || len(data)+len(e.data)+binary.MaxVarintLen64 > go122.MaxBatchSize {
+			if i != 0 {
+				writeBatch(buf, b.gen.gen, b.thread, start, data)
+			}
+			data, start, last = nil, e.time, e.time
+		}
+		data = append(data, e.data[0])
+		data = appendArgs(data, uint64(e.time-last))
+		data = append(data, e.data[1:]...)
+		last = e.time
+	}
+	if len(b.events) != 0 {
+		writeBatch(buf, b.gen.gen, b.thread, start, data)
+	}
+}
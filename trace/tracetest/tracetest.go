@@ -0,0 +1,228 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.21
+
+// Package tracetest builds synthetic execution traces, for testing programs
+// that read traces with [trace.Reader] without depending on what the runtime
+// happens to do.
+//
+// A trace is made of generations, which are made of batches of events, each
+// written by a single thread, as in the traces the runtime produces:
+//
+//	tt := tracetest.NewTrace()
+//	g := tt.Generation()
+//	b := g.Batch(1)
+//	b.ProcStatus(10, 0, trace.ProcRunning)
+//	b.GoStatus(11, 1, trace.GoRunning)
+//	b.RegionBegin(20, trace.BackgroundTask, "work", nil)
+//	b.RegionEnd(30, trace.BackgroundTask, "work", nil)
+//	r, err := trace.NewReader(bytes.NewReader(tt.Bytes()))
+//
+// Timestamps are in nanoseconds, and are the times the Reader reports. Within
+// a batch, they must not decrease. The package takes care of the details of
+// the wire format, such as string and stack tables and the sequence numbers
+// that order events on different threads, but it doesn't otherwise check
+// that the trace makes sense. As in real traces, each generation must start
+// with the status of each goroutine and proc that appears in it, other than
+// goroutines created in it, and events on different threads that concern the
+// same goroutine or proc must be added in the order they happen.
+package tracetest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+
+	"golang.org/x/exp/trace"
+	"golang.org/x/exp/trace/internal/event"
+	"golang.org/x/exp/trace/internal/event/go122"
+	"golang.org/x/exp/trace/internal/version"
+)
+
+// Trace is a synthetic trace under construction.
+type Trace struct {
+	gens []*Generation
+
+	// threadProcs is the proc each thread has, as implied by the events
+	// added so far.
+	threadProcs map[trace.ThreadID]trace.ProcID
+
+	// lastGCSeq is the last sequence number used for a GC event. Unlike
+	// the sequence numbers for goroutines and procs, it runs across
+	// generations.
+	lastGCSeq uint64
+}
+
+// NewTrace returns a new empty trace.
+func NewTrace() *Trace {
+	return &Trace{threadProcs: make(map[trace.ThreadID]trace.ProcID)}
+}
+
+// Generation adds a new generation to the end of the trace.
+func (t *Trace) Generation() *Generation {
+	g := &Generation{
+		trace:   t,
+		gen:     uint64(len(t.gens) + 1),
+		strings: make(map[string]uint64),
+		stacks:  make(map[string]uint64),
+		gSeqs:   make(map[trace.GoID]uint64),
+		pSeqs:   make(map[trace.ProcID]uint64),
+	}
+	t.gens = append(t.gens, g)
+	return g
+}
+
+// gcSeq returns the next sequence number for a GC event.
+func (t *Trace) gcSeq() uint64 {
+	t.lastGCSeq++
+	return t.lastGCSeq
+}
+
+// Bytes returns the trace in the wire format.
+func (t *Trace) Bytes() []byte {
+	var buf bytes.Buffer
+	t.WriteTo(&buf)
+	return buf.Bytes()
+}
+
+// WriteTo writes the trace to w in the wire format.
+func (t *Trace) WriteTo(w io.Writer) (int64, error) {
+	var buf bytes.Buffer
+	if _, err := version.WriteHeader(&buf, version.Go122); err != nil {
+		return 0, err
+	}
+	for _, g := range t.gens {
+		g.writeTo(&buf)
+	}
+	return buf.WriteTo(w)
+}
+
+// Generation is a generation of a synthetic trace.
+type Generation struct {
+	trace   *Trace
+	gen     uint64
+	batches []*Batch
+
+	// The string and stack tables, in ID order.
+	strings   map[string]uint64
+	stringTab []string
+	stacks    map[string]uint64
+	stackTab  [][]trace.StackFrame
+
+	// The last sequence numbers used for each goroutine and proc.
+	gSeqs map[trace.GoID]uint64
+	pSeqs map[trace.ProcID]uint64
+}
+
+// Batch adds a new batch of events written by thread to the generation.
+func (g *Generation) Batch(thread trace.ThreadID) *Batch {
+	b := &Batch{gen: g, thread: thread}
+	g.batches = append(g.batches, b)
+	return b
+}
+
+// stringID returns the ID of s in the generation's string table.
+func (g *Generation) stringID(s string) uint64 {
+	if s == "" {
+		return 0
+	}
+	if id, ok := g.strings[s]; ok {
+		return id
+	}
+	g.stringTab = append(g.stringTab, s)
+	id := uint64(len(g.stringTab))
+	g.strings[s] = id
+	return id
+}
+
+// stackID returns the ID of stk in the generation's stack table.
+func (g *Generation) stackID(stk []trace.StackFrame) uint64 {
+	if len(stk) == 0 {
+		return 0
+	}
+	var key []byte
+	for _, f := range stk {
+		key = binary.AppendUvarint(key, f.PC)
+		key = binary.AppendUvarint(key, g.stringID(f.Func))
+		key = binary.AppendUvarint(key, g.stringID(f.File))
+		key = binary.AppendUvarint(key, f.Line)
+	}
+	if id, ok := g.stacks[string(key)]; ok {
+		return id
+	}
+	g.stackTab = append(g.stackTab, stk)
+	id := uint64(len(g.stackTab))
+	g.stacks[string(key)] = id
+	return id
+}
+
+// goSeq returns the next sequence number for goroutine id.
+func (g *Generation) goSeq(id trace.GoID) uint64 {
+	g.gSeqs[id]++
+	return g.gSeqs[id]
+}
+
+// procSeq returns the next sequence number for proc id.
+func (g *Generation) procSeq(id trace.ProcID) uint64 {
+	g.pSeqs[id]++
+	return g.pSeqs[id]
+}
+
+// writeTo writes the generation's batches to buf, followed by the
+// structural batches the Reader needs to decode them.
+func (g *Generation) writeTo(buf *bytes.Buffer) {
+	for _, b := range g.batches {
+		b.writeTo(buf)
+	}
+
+	// Timestamps are in nanoseconds.
+	writeBatch(buf, g.gen, trace.NoThread, 0, appendArgs([]byte{byte(go122.EvFrequency)}, 1e9))
+
+	// Stacks go first, because they may not be in the string table yet.
+	var stacks [][]byte
+	for i, stk := range g.stackTab {
+		e := appendArgs(nil, uint64(i+1), uint64(len(stk)))
+		for _, f := range stk {
+			e = appendArgs(e, f.PC, g.stringID(f.Func), g.stringID(f.File), f.Line)
+		}
+		stacks = append(stacks, append([]byte{byte(go122.EvStack)}, e...))
+	}
+	g.writeStructural(buf, go122.EvStacks, stacks)
+
+	var strings [][]byte
+	for i, s := range g.stringTab {
+		e := appendArgs([]byte{byte(go122.EvString)}, uint64(i+1), uint64(len(s)))
+		strings = append(strings, append(e, s...))
+	}
+	g.writeStructural(buf, go122.EvStrings, strings)
+}
+
+// writeStructural writes a batch of structural events to buf, starting with
+// an event of type typ, and splits it into as many batches as it takes to
+// keep them within the maximum batch size.
+func (g *Generation) writeStructural(buf *bytes.Buffer, typ event.Type, evs [][]byte) {
+	data := []byte{byte(typ)}
+	for _, e := range evs {
+		if len(data)+len(e) > go122.MaxBatchSize/2 && len(data) > 1 {
+			writeBatch(buf, g.gen, trace.NoThread, 0, data)
+			data = []byte{byte(typ)}
+		}
+		data = append(data, e...)
+	}
+	writeBatch(buf, g.gen, trace.NoThread, 0, data)
+}
+
+// writeBatch writes a batch with the given header and data to buf.
+func writeBatch(buf *bytes.Buffer, gen uint64, m trace.ThreadID, time trace.Time, data []byte) {
+	buf.Write(appendArgs([]byte{byte(go122.EvEventBatch)}, gen, uint64(m), uint64(time), uint64(len(data))))
+	buf.Write(data)
+}
+
+func appendArgs(b []byte, args ...uint64) []byte {
+	for _, arg := range args {
+		b = binary.AppendUvarint(b, arg)
+	}
+	return b
+}
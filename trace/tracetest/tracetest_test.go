@@ -0,0 +1,221 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.21
+
+package tracetest_test
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/exp/trace"
+	"golang.org/x/exp/trace/internal/testtrace"
+	"golang.org/x/exp/trace/tracetest"
+)
+
+// summarize reads data and describes its events, apart from sync events,
+// checking that they're valid.
+func summarize(t *testing.T, data []byte) []string {
+	t.Helper()
+
+	r, err := trace.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := testtrace.NewValidator()
+	var got []string
+	for {
+		ev, err := r.ReadEvent()
+		if err == io.EOF {
+			return got
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := v.Event(ev); err != nil {
+			t.Fatal(err)
+		}
+		s := fmt.Sprintf("%d M%d P%d G%d ", ev.Time(), ev.Thread(), ev.Proc(), ev.Goroutine())
+		switch ev.Kind() {
+		case trace.EventSync:
+			continue
+		case trace.EventStateTransition:
+			st := ev.StateTransition()
+			if st.Resource.Kind == trace.ResourceProc {
+				from, to := st.Proc()
+				s += fmt.Sprintf("%v %v->%v", st.Resource, from, to)
+			} else {
+				from, to := st.Goroutine()
+				s += fmt.Sprintf("%v %v->%v", st.Resource, from, to)
+			}
+			if st.Reason != "" {
+				s += fmt.Sprintf(" %q", st.Reason)
+			}
+			st.Stack.Frames(func(f trace.StackFrame) bool {
+				s += fmt.Sprintf(" %s:%d", f.Func, f.Line)
+				return true
+			})
+		case trace.EventTaskBegin, trace.EventTaskEnd:
+			task := ev.Task()
+			parent := fmt.Sprint(task.Parent)
+			if task.Parent == trace.NoTask {
+				parent = "none"
+			}
+			s += fmt.Sprintf("%v %d %s %q", ev.Kind(), task.ID, parent, task.Type)
+		case trace.EventRegionBegin, trace.EventRegionEnd:
+			s += fmt.Sprintf("%v %q", ev.Kind(), ev.Region().Type)
+		case trace.EventRangeBegin, trace.EventRangeActive, trace.EventRangeEnd:
+			r := ev.Range()
+			s += fmt.Sprintf("%v %v %q", ev.Kind(), r.Scope, r.Name)
+		case trace.EventLog:
+			l := ev.Log()
+			s += fmt.Sprintf("Log %d %q %q", l.Task, l.Category, l.Message)
+		default:
+			s += ev.Kind().String()
+		}
+		got = append(got, s)
+	}
+}
+
+func TestTrace(t *testing.T) {
+	main := []trace.StackFrame{{PC: 0x1000, Func: "main.main", File: "main.go", Line: 10}}
+	worker := []trace.StackFrame{{PC: 0x2000, Func: "main.worker", File: "main.go", Line: 20}}
+
+	tt := tracetest.NewTrace()
+	g := tt.Generation()
+	b1 := g.Batch(1)
+	b1.ProcStatus(100, 0, trace.ProcRunning)
+	b1.GoStatus(101, 1, trace.GoRunning)
+	b1.TaskBegin(110, 1, trace.BackgroundTask, "job", main)
+	b1.GoCreate(120, 2, worker, main)
+	b1.GoBlock(130, "chan receive", main)
+	b1.GoStart(140, 2)
+	b1.RegionBegin(150, 1, "work", worker)
+	b1.Log(160, 1, "step", "half", nil)
+	b1.RegionEnd(170, 1, "work", worker)
+	b1.GoUnblock(180, 1, worker)
+	b1.GoSyscallBegin(190, worker)
+	b2 := g.Batch(2)
+	b2.ProcSteal(200, 0, 1)
+	b2.ProcStart(210, 0)
+	b2.GoStart(220, 1)
+	b2.TaskEnd(230, 1, main)
+	b1.GoSyscallEndBlocked(240)
+
+	// In the next generation, goroutine 1 finishes, and goroutine 2 runs
+	// to completion once it gets a proc.
+	g = tt.Generation()
+	b2 = g.Batch(2)
+	b2.ProcStatus(300, 0, trace.ProcRunning)
+	b2.GoStatus(301, 1, trace.GoRunning)
+	b2.GoStatus(302, 2, trace.GoRunnable)
+	b2.GoDestroy(310)
+	b2.GoStart(320, 2)
+	b2.GoStop(330, "preempted", worker)
+	b2.GoStart(340, 2)
+	b2.GoDestroy(350)
+	b2.ProcStop(360)
+
+	want := []string{
+		"100 M1 P-1 G-1 Proc(0) Undetermined->Running",
+		"101 M1 P0 G-1 Goroutine(1) Undetermined->Running",
+		`110 M1 P0 G1 TaskBegin 1 none "job"`,
+		"120 M1 P0 G1 Goroutine(2) NotExist->Runnable main.worker:20",
+		`130 M1 P0 G1 Goroutine(1) Running->Waiting "chan receive" main.main:10`,
+		"140 M1 P0 G-1 Goroutine(2) Runnable->Running",
+		`150 M1 P0 G2 RegionBegin "work"`,
+		`160 M1 P0 G2 Log 1 "step" "half"`,
+		`170 M1 P0 G2 RegionEnd "work"`,
+		"180 M1 P0 G2 Goroutine(1) Waiting->Runnable",
+		"190 M1 P0 G2 Goroutine(2) Running->Syscall main.worker:20",
+		"200 M2 P-1 G-1 Proc(0) Running->Idle",
+		"210 M2 P-1 G-1 Proc(0) Idle->Running",
+		"220 M2 P0 G-1 Goroutine(1) Runnable->Running",
+		`230 M2 P0 G1 TaskEnd 1 none "job"`,
+		"240 M1 P-1 G2 Goroutine(2) Syscall->Runnable",
+		"300 M2 P0 G1 Proc(0) Running->Running",
+		"301 M2 P0 G1 Goroutine(1) Running->Running",
+		"302 M2 P0 G1 Goroutine(2) Runnable->Runnable",
+		"310 M2 P0 G1 Goroutine(1) Running->NotExist",
+		"320 M2 P0 G-1 Goroutine(2) Runnable->Running",
+		`330 M2 P0 G2 Goroutine(2) Running->Runnable "preempted" main.worker:20`,
+		"340 M2 P0 G-1 Goroutine(2) Runnable->Running",
+		"350 M2 P0 G2 Goroutine(2) Running->NotExist",
+		"360 M2 P0 G-1 Proc(0) Running->Idle",
+	}
+	got := summarize(t, tt.Bytes())
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got events:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	// The trace is deterministic.
+	if !bytes.Equal(tt.Bytes(), tt.Bytes()) {
+		t.Errorf("trace bytes differ between calls")
+	}
+}
+
+func TestTraceRanges(t *testing.T) {
+	// A GC cycle, a sweep, and a mark assist that are all in progress
+	// across a generation boundary.
+	tt := tracetest.NewTrace()
+	b := tt.Generation().Batch(1)
+	b.ProcStatus(100, 0, trace.ProcRunning)
+	b.GoStatus(101, 1, trace.GoRunning)
+	b.GCBegin(110, nil)
+	b.GCSweepBegin(120, nil)
+	b.GCMarkAssistBegin(130, nil)
+	b = tt.Generation().Batch(1)
+	b.ProcStatus(200, 0, trace.ProcRunning)
+	b.GoStatus(201, 1, trace.GoRunning)
+	b.GCActive(202)
+	b.GCSweepActive(203, 0)
+	b.GCMarkAssistActive(204, 1)
+	b.GCMarkAssistEnd(210)
+	b.GCSweepEnd(220, 4096, 1024)
+	b.GCEnd(230)
+
+	want := []string{
+		"100 M1 P-1 G-1 Proc(0) Undetermined->Running",
+		"101 M1 P0 G-1 Goroutine(1) Undetermined->Running",
+		`110 M1 P0 G1 RangeBegin None "GC concurrent mark phase"`,
+		`120 M1 P0 G1 RangeBegin Proc(0) "GC incremental sweep"`,
+		`130 M1 P0 G1 RangeBegin Goroutine(1) "GC mark assist"`,
+		"200 M1 P0 G1 Proc(0) Running->Running",
+		"201 M1 P0 G1 Goroutine(1) Running->Running",
+		`202 M1 P0 G1 RangeActive None "GC concurrent mark phase"`,
+		`203 M1 P0 G1 RangeActive Proc(0) "GC incremental sweep"`,
+		`204 M1 P0 G1 RangeActive Goroutine(1) "GC mark assist"`,
+		`210 M1 P0 G1 RangeEnd Goroutine(1) "GC mark assist"`,
+		`220 M1 P0 G1 RangeEnd Proc(0) "GC incremental sweep"`,
+		`230 M1 P0 G1 RangeEnd None "GC concurrent mark phase"`,
+	}
+	got := summarize(t, tt.Bytes())
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got events:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestTraceLarge(t *testing.T) {
+	// Enough events and strings to need several batches of each.
+	tt := tracetest.NewTrace()
+	b := tt.Generation().Batch(1)
+	b.ProcStatus(1, 0, trace.ProcRunning)
+	b.GoStatus(1, 1, trace.GoRunning)
+	const n = 20000
+	for i := 0; i < n; i++ {
+		b.Log(trace.Time(10+i), trace.BackgroundTask, "category", fmt.Sprintf("message %d", i), nil)
+	}
+	got := summarize(t, tt.Bytes())
+	if len(got) != n+2 {
+		t.Fatalf("got %d events, want %d", len(got), n+2)
+	}
+	if want := fmt.Sprintf(`%d M1 P0 G1 Log 0 "category" "message %d"`, 10+n-1, n-1); got[n+1] != want {
+		t.Errorf("got last event %q, want %q", got[n+1], want)
+	}
+}
@@ -0,0 +1,946 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.21
+
+package trace
+
+import (
+	"cmp"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+
+	"golang.org/x/exp/trace/internal/event"
+	"golang.org/x/exp/trace/internal/event/go122"
+	"golang.org/x/exp/trace/internal/version"
+)
+
+// Writer writes a stream of Events to an io.Writer in the trace wire format,
+// such that the result can be read back with NewReader.
+//
+// The events passed to Writer must describe a consistent execution, such as the
+// events produced by a Reader, or a subset of them. Writer does not copy events
+// out of their original trace verbatim. Instead, it rebuilds the string and stack
+// tables, batches, sequence numbers, and per-generation goroutine and proc statuses
+// from the events it is given, so that events may be removed from the stream without
+// invalidating the rest of the trace. For example, EventLabel, EventLog, and
+// EventStackSample events may always be dropped.
+//
+// Each EventSync ends the current generation and starts a new one.
+//
+// Timestamps are preserved, except where they must be adjusted to be strictly
+// increasing, or to make room for goroutine and proc statuses that Writer emits.
+//
+// Writer checks each event against the scheduler state implied by the events
+// written before it, and returns an error if the event is inconsistent with that
+// state. For example, an event's goroutine must be the goroutine that is executing
+// on its thread, and a goroutine must be runnable before it starts running.
+// Once WriteEvent returns an error, the output is unusable and all subsequent calls
+// return the same error.
+type Writer struct {
+	w   io.Writer
+	err error
+
+	// skip is true while the Writer only tracks state from the events
+	// it's given without writing anything. See Slice.
+	skip bool
+
+	lastTs      Time
+	gcSeq       uint64
+	gcRunning   bool
+	gcActiveGen uint64 // generation in which a GCActive was emitted by startOutput.
+	gs          map[GoID]*writerGState
+	ps          map[ProcID]*writerPState
+	ms          map[ThreadID]*writerMState
+	tasks       map[TaskID]Task // active tasks.
+	taskBegins  []Task          // tasks that began before startOutput, which still need a UserTaskBegin.
+
+	// State for the current generation.
+	gen       uint64 // current generation number, or the last one if !inGen
+	inGen     bool
+	genStart  Time
+	batches   map[ThreadID]*writerBatch
+	strings   map[string]uint64
+	stacks    map[Stack]uint64
+	stackEvs  [][]byte
+	stringEvs [][]byte
+	samples   [][]byte
+	tables    []*evTable // tables of the events in this generation, for experimental data.
+
+	buf  []byte
+	args [maxArgs]uint64
+}
+
+// errWriterClosed is returned by WriteEvent after Close is called.
+var errWriterClosed = errors.New("trace writer closed")
+
+// NewWriter creates a new Writer that writes a trace to w.
+//
+// NewWriter writes the trace header to w immediately.
+func NewWriter(w io.Writer) (*Writer, error) {
+	if _, err := version.WriteHeader(w, version.Current); err != nil {
+		return nil, err
+	}
+	return &Writer{
+		w:     w,
+		gs:    make(map[GoID]*writerGState),
+		ps:    make(map[ProcID]*writerPState),
+		ms:    make(map[ThreadID]*writerMState),
+		tasks: make(map[TaskID]Task),
+	}, nil
+}
+
+// WriteEvent writes a single event to the trace.
+//
+// Events are buffered per generation, so data may not reach the underlying
+// io.Writer until the next EventSync is written or Close is called.
+func (w *Writer) WriteEvent(ev Event) error {
+	if w.err != nil {
+		return w.err
+	}
+	if err := w.writeEvent(ev); err != nil {
+		w.err = err
+	}
+	return w.err
+}
+
+// Close flushes the current generation to the underlying io.Writer.
+// It does not close the underlying io.Writer.
+func (w *Writer) Close() error {
+	if w.err != nil {
+		if w.err == errWriterClosed {
+			return nil
+		}
+		return w.err
+	}
+	if err := w.flushGen(); err != nil {
+		w.err = err
+		return err
+	}
+	w.err = errWriterClosed
+	return nil
+}
+
+// writerGState is the state of a goroutine as written to the trace.
+type writerGState struct {
+	status go122.GoStatus
+	m      ThreadID // thread the goroutine is bound to, if it's executing.
+	seq    uint64
+	gen    uint64 // generation in which the status was last emitted.
+
+	// In-flight special ranges.
+	markAssist bool
+	stw        bool
+	stwLost    bool   // whether an STW range began before startOutput.
+	activeGen  uint64 // generation in which a GCMarkAssistActive was emitted by startOutput.
+}
+
+// writerPState is the state of a proc as written to the trace.
+type writerPState struct {
+	status go122.ProcStatus
+	m      ThreadID // thread the proc is bound to, if it's executing.
+	seq    uint64
+	gen    uint64 // generation in which the status was last emitted.
+
+	// In-flight special ranges.
+	sweep     bool
+	activeGen uint64 // generation in which a GCSweepActive was emitted by startOutput.
+}
+
+// writerMState is the state of a thread as written to the trace.
+type writerMState struct {
+	g GoID
+	p ProcID
+}
+
+// writerBatch is a partially-written batch of events for a thread.
+type writerBatch struct {
+	time   Time
+	lastTs Time
+	data   []byte
+}
+
+func (w *Writer) writeEvent(ev Event) error {
+	if ev.table == nil {
+		return fmt.Errorf("invalid event: %v", ev.Kind())
+	}
+	switch ev.base.typ {
+	case evSync:
+		return w.flushGen()
+	case go122.EvCPUSample:
+		if w.skip {
+			return nil
+		}
+		w.startGen(ev)
+		g := uint64(ev.ctx.G)
+		if ev.ctx.G == NoGoroutine {
+			g = 0
+		}
+		stk := w.stackID(ev.Stack())
+		w.samples = append(w.samples, appendEvent(nil, go122.EvCPUSample,
+			uint64(w.tick(ev.Time())), uint64(ev.ctx.M), uint64(ev.ctx.P), g, stk))
+		return nil
+	case go122.EvGoStatus, go122.EvGoStatusStack:
+		w.startGen(ev)
+		return w.writeGoStatus(ev)
+	case go122.EvProcStatus:
+		w.startGen(ev)
+		return w.writeProcStatus(ev)
+	}
+	if int(ev.base.typ) >= len(go122.Specs()) || !go122.Specs()[ev.base.typ].IsTimedEvent {
+		return fmt.Errorf("invalid event type %d", ev.base.typ)
+	}
+	w.startGen(ev)
+
+	// Check the event's scheduling context against what's been written.
+	m := ev.ctx.M
+	if m == NoThread {
+		return fmt.Errorf("event %s has no thread", go122.EventString(ev.base.typ))
+	}
+	ms := w.mState(m)
+	if ev.ctx.G != ms.g || ev.ctx.P != ms.p {
+		return fmt.Errorf("inconsistent context for event %s on thread %d: have G=%d P=%d, but thread has G=%d P=%d",
+			go122.EventString(ev.base.typ), m, ev.ctx.G, ev.ctx.P, ms.g, ms.p)
+	}
+	if ms.p != NoProc {
+		if err := w.ensureProcStatus(ms.p, m, ev.Time()); err != nil {
+			return err
+		}
+	}
+	if ms.g != NoGoroutine {
+		if err := w.ensureGoStatus(ms.g, m, ev.Time()); err != nil {
+			return err
+		}
+	}
+	if len(w.taskBegins) != 0 && ms.g != NoGoroutine && ms.p != NoProc {
+		// This is the first chance we've had to write out tasks that
+		// were already active when startOutput was called.
+		for _, task := range w.taskBegins {
+			parent := uint64(task.Parent)
+			if task.Parent == NoTask {
+				parent = 0
+			}
+			w.emit(m, go122.EvUserTaskBegin, w.tick(ev.Time()-1), uint64(task.ID), parent, w.stringID(task.Type), 0)
+		}
+		w.taskBegins = w.taskBegins[:0]
+	}
+	args := w.remapArgs(ev)
+	typ := ev.base.typ
+
+	// Update the state of the resources the event touches and
+	// fill in the arguments we can't copy from the original.
+	switch typ {
+	case go122.EvProcStart:
+		pid := ProcID(args[0])
+		ps, err := w.procForTransition(pid, m, ev.Time(), go122.ProcIdle)
+		if err != nil {
+			return err
+		}
+		if ms.p != NoProc {
+			return fmt.Errorf("proc %d started on thread %d, which already has proc %d", pid, m, ms.p)
+		}
+		ps.status = go122.ProcRunning
+		ps.m = m
+		ps.seq++
+		args[1] = ps.seq
+		ms.p = pid
+	case go122.EvProcStop:
+		if ms.p == NoProc {
+			return fmt.Errorf("%s on thread %d without a proc", go122.EventString(typ), m)
+		}
+		ps := w.ps[ms.p]
+		ps.status = go122.ProcIdle
+		ps.m = NoThread
+		ms.p = NoProc
+	case go122.EvProcSteal:
+		if ev.base.args[1] == 0 {
+			// This is the self-steal the reader generates for a GoDestroySyscall
+			// on a thread that still has a proc. It'll be generated again when
+			// reading back the GoDestroySyscall, so don't write it.
+			return nil
+		}
+		pid := ProcID(args[0])
+		if err := w.ensureProcStatus(pid, m, ev.Time()); err != nil {
+			return err
+		}
+		ps := w.ps[pid]
+		switch ps.status {
+		case go122.ProcIdle:
+			// The proc already went idle, so there's nothing to steal. This
+			// happens if the proc was taken away from its thread in some other
+			// way, for example by Slice.
+			return nil
+		case go122.ProcSyscall:
+			w.stealProc(ps, args)
+		case go122.ProcSyscallAbandoned:
+			// We don't know which thread had the proc, so just keep
+			// whatever the original event said.
+			ps.status = go122.ProcIdle
+			ps.seq++
+			args[1] = ps.seq
+		default:
+			return fmt.Errorf("%s for proc %d that's %v, not in a syscall", go122.EventString(typ), pid, ps.status)
+		}
+	case go122.EvGoCreate, go122.EvGoCreateBlocked:
+		status := go122.GoRunnable
+		if typ == go122.EvGoCreateBlocked {
+			status = go122.GoWaiting
+		}
+		if err := w.createGoroutine(GoID(args[0]), status, NoThread); err != nil {
+			return err
+		}
+	case go122.EvGoCreateSyscall:
+		gid := GoID(args[0])
+		if err := w.createGoroutine(gid, go122.GoSyscall, m); err != nil {
+			return err
+		}
+		ms.g = gid
+	case go122.EvGoStart:
+		gid := GoID(args[0])
+		gs, err := w.goForTransition(gid, m, ev.Time(), go122.GoRunnable)
+		if err != nil {
+			return err
+		}
+		if ms.g != NoGoroutine {
+			return fmt.Errorf("goroutine %d started on thread %d, which already has goroutine %d", gid, m, ms.g)
+		}
+		gs.status = go122.GoRunning
+		gs.m = m
+		gs.seq++
+		args[1] = gs.seq
+		ms.g = gid
+	case go122.EvGoDestroy, go122.EvGoStop, go122.EvGoBlock:
+		gs, err := w.curGoroutine(ms, typ, go122.GoRunning)
+		if err != nil {
+			return err
+		}
+		switch typ {
+		case go122.EvGoDestroy:
+			delete(w.gs, ms.g)
+		case go122.EvGoStop:
+			gs.status = go122.GoRunnable
+		case go122.EvGoBlock:
+			gs.status = go122.GoWaiting
+		}
+		gs.m = NoThread
+		ms.g = NoGoroutine
+	case go122.EvGoDestroySyscall:
+		if _, err := w.curGoroutine(ms, typ, go122.GoSyscall); err != nil {
+			return err
+		}
+		if ms.p != NoProc {
+			// The thread loses its proc, which is left abandoned in a syscall.
+			ps := w.ps[ms.p]
+			if ps.status != go122.ProcSyscall {
+				return fmt.Errorf("%s on thread %d with proc %d that's not in a syscall", go122.EventString(typ), m, ms.p)
+			}
+			ps.status = go122.ProcSyscallAbandoned
+			ps.m = NoThread
+			ms.p = NoProc
+		}
+		delete(w.gs, ms.g)
+		ms.g = NoGoroutine
+	case go122.EvGoUnblock, go122.EvGoSwitch, go122.EvGoSwitchDestroy:
+		// GoSwitch and GoSwitchDestroy show up in the event stream as an unblock
+		// of the next goroutine, followed by separate events for the rest of the
+		// switch, so write them out as a plain unblock.
+		gid := GoID(args[0])
+		gs, err := w.goForTransition(gid, m, ev.Time(), go122.GoWaiting)
+		if err != nil {
+			return err
+		}
+		gs.status = go122.GoRunnable
+		gs.seq++
+		if typ != go122.EvGoUnblock {
+			typ = go122.EvGoUnblock
+			args = append(args[:2], 0)
+		}
+		args[1] = gs.seq
+	case go122.EvGoSyscallBegin:
+		gs, err := w.curGoroutine(ms, typ, go122.GoRunning)
+		if err != nil {
+			return err
+		}
+		if ms.p == NoProc {
+			return fmt.Errorf("%s on thread %d without a proc", go122.EventString(typ), m)
+		}
+		ps := w.ps[ms.p]
+		if ps.status != go122.ProcRunning {
+			return fmt.Errorf("%s on thread %d with proc %d that's not running", go122.EventString(typ), m, ms.p)
+		}
+		gs.status = go122.GoSyscall
+		ps.status = go122.ProcSyscall
+		ps.seq++
+		args[0] = ps.seq
+	case go122.EvGoSyscallEnd:
+		gs, err := w.curGoroutine(ms, typ, go122.GoSyscall)
+		if err != nil {
+			return err
+		}
+		if ms.p == NoProc || w.ps[ms.p].status != go122.ProcSyscall {
+			return fmt.Errorf("%s on thread %d without a proc in a syscall", go122.EventString(typ), m)
+		}
+		gs.status = go122.GoRunning
+		w.ps[ms.p].status = go122.ProcRunning
+	case go122.EvGoSyscallEndBlocked:
+		gs, err := w.curGoroutine(ms, typ, go122.GoSyscall)
+		if err != nil {
+			return err
+		}
+		if ms.p != NoProc && w.ps[ms.p].status == go122.ProcSyscall {
+			return fmt.Errorf("%s on thread %d before its proc %d was taken away", go122.EventString(typ), m, ms.p)
+		}
+		gs.status = go122.GoRunnable
+		gs.m = NoThread
+		ms.g = NoGoroutine
+	case go122.EvGCActive, go122.EvGCBegin, go122.EvGCEnd:
+		switch {
+		case typ == go122.EvGCActive && w.gcActiveGen == w.gen && w.gcRunning:
+			// startOutput already wrote this.
+			return nil
+		case typ == go122.EvGCBegin && w.gcRunning:
+			return fmt.Errorf("%s while GC is already running", go122.EventString(typ))
+		case typ == go122.EvGCEnd && !w.gcRunning:
+			return fmt.Errorf("%s while GC isn't running", go122.EventString(typ))
+		}
+		w.gcRunning = typ != go122.EvGCEnd
+		w.gcSeq++
+		args[0] = w.gcSeq
+	case go122.EvGCSweepActive:
+		pid := ProcID(args[0])
+		if err := w.ensureProcStatus(pid, m, ev.Time()); err != nil {
+			return err
+		}
+		ps := w.ps[pid]
+		if ps.sweep && ps.activeGen == w.gen {
+			// startOutput already wrote this.
+			return nil
+		}
+		ps.sweep = true
+	case go122.EvGCSweepBegin, go122.EvGCSweepEnd:
+		if ms.p == NoProc {
+			return fmt.Errorf("%s on thread %d without a proc", go122.EventString(typ), m)
+		}
+		ps := w.ps[ms.p]
+		if begin := typ == go122.EvGCSweepBegin; ps.sweep == begin {
+			return fmt.Errorf("%s for proc %d with sweep in progress=%v", go122.EventString(typ), ms.p, ps.sweep)
+		}
+		ps.sweep = !ps.sweep
+	case go122.EvGCMarkAssistActive:
+		gid := GoID(args[0])
+		if err := w.ensureGoStatus(gid, m, ev.Time()); err != nil {
+			return err
+		}
+		gs := w.gs[gid]
+		if gs.markAssist && gs.activeGen == w.gen {
+			// startOutput already wrote this.
+			return nil
+		}
+		gs.markAssist = true
+	case go122.EvGCMarkAssistBegin, go122.EvGCMarkAssistEnd:
+		gs, err := w.curGoroutine(ms, typ, go122.GoRunning)
+		if err != nil {
+			return err
+		}
+		if begin := typ == go122.EvGCMarkAssistBegin; gs.markAssist == begin {
+			return fmt.Errorf("%s for goroutine %d with mark assist in progress=%v", go122.EventString(typ), ms.g, gs.markAssist)
+		}
+		gs.markAssist = !gs.markAssist
+	case go122.EvSTWBegin, go122.EvSTWEnd:
+		gs, err := w.curGoroutine(ms, typ, go122.GoRunning)
+		if err != nil {
+			return err
+		}
+		if typ == go122.EvSTWEnd && !gs.stw && gs.stwLost {
+			// The STW began before startOutput, and there's no way
+			// to express that it's in progress.
+			gs.stwLost = false
+			return nil
+		}
+		if begin := typ == go122.EvSTWBegin; gs.stw == begin {
+			return fmt.Errorf("%s for goroutine %d with STW in progress=%v", go122.EventString(typ), ms.g, gs.stw)
+		}
+		gs.stw = !gs.stw
+	case go122.EvUserTaskBegin:
+		task := ev.Task()
+		w.tasks[task.ID] = task
+		if TaskID(args[1]) == NoTask {
+			// The reader turns the wire format's "no parent" into NoTask.
+			args[1] = 0
+		}
+	case go122.EvUserTaskEnd:
+		delete(w.tasks, TaskID(args[0]))
+	}
+	w.emit(m, typ, w.tick(ev.Time()), args...)
+	return nil
+}
+
+// writeGoStatus handles a GoStatus or GoStatusStack event.
+func (w *Writer) writeGoStatus(ev Event) error {
+	gid := GoID(ev.base.args[0])
+	status := go122.GoStatus(ev.base.args[2])
+	var stk Stack
+	if ev.base.typ == go122.EvGoStatusStack {
+		stk = ev.StateTransition().Stack
+	}
+	gs, ok := w.gs[gid]
+	if !ok {
+		if w.gen > 1 {
+			return fmt.Errorf("found status for new goroutine %d after the first generation", gid)
+		}
+		gs = &writerGState{status: status, m: NoThread}
+		switch status {
+		case go122.GoRunning:
+			gs.m = ev.ctx.M
+		case go122.GoSyscall:
+			gs.m = ThreadID(ev.base.args[1])
+		}
+		if gs.m != NoThread {
+			ms := w.mState(gs.m)
+			if ms.g != NoGoroutine && ms.g != gid {
+				return fmt.Errorf("goroutine %d bound to thread %d, which already has goroutine %d", gid, gs.m, ms.g)
+			}
+			ms.g = gid
+		}
+		w.gs[gid] = gs
+	} else if gs.status != status {
+		return fmt.Errorf("inconsistent status for goroutine %d: have %v, but event has %v", gid, gs.status, status)
+	}
+	w.emitGoStatus(gid, gs, ev.ctx.M, w.tick(ev.Time()), stk)
+	return nil
+}
+
+// writeProcStatus handles a ProcStatus event.
+func (w *Writer) writeProcStatus(ev Event) error {
+	pid := ProcID(ev.base.args[0])
+	status := go122.ProcStatus(ev.base.args[1])
+	ps, ok := w.ps[pid]
+	if !ok {
+		ps = &writerPState{status: status, m: NoThread}
+		if status == go122.ProcRunning || status == go122.ProcSyscall {
+			ps.m = ev.ctx.M
+			ms := w.mState(ps.m)
+			if ms.p != NoProc && ms.p != pid {
+				return fmt.Errorf("proc %d bound to thread %d, which already has proc %d", pid, ps.m, ms.p)
+			}
+			ms.p = pid
+		}
+		w.ps[pid] = ps
+	} else if ps.status != status {
+		return fmt.Errorf("inconsistent status for proc %d: have %v, but event has %v", pid, ps.status, status)
+	}
+	w.emitProcStatus(pid, ps, ev.ctx.M, w.tick(ev.Time()))
+	return nil
+}
+
+// ensureGoStatus writes a status for goroutine gid if one hasn't been written
+// in the current generation yet. The status is written to thread m if the
+// goroutine isn't bound to a thread.
+func (w *Writer) ensureGoStatus(gid GoID, m ThreadID, t Time) error {
+	gs, ok := w.gs[gid]
+	if !ok {
+		return fmt.Errorf("found event for unknown goroutine %d", gid)
+	}
+	if gs.gen != w.gen {
+		w.emitGoStatus(gid, gs, m, w.tick(t-1), NoStack)
+	}
+	return nil
+}
+
+// ensureProcStatus writes a status for proc pid if one hasn't been written
+// in the current generation yet. The status is written to thread m if the
+// proc isn't bound to a thread.
+func (w *Writer) ensureProcStatus(pid ProcID, m ThreadID, t Time) error {
+	ps, ok := w.ps[pid]
+	if !ok {
+		return fmt.Errorf("found event for unknown proc %d", pid)
+	}
+	if ps.gen != w.gen {
+		w.emitProcStatus(pid, ps, m, w.tick(t-1))
+	}
+	return nil
+}
+
+// startOutput stops skipping events, and writes out everything that's needed
+// to describe the state of the execution at ev, which is the first event that
+// will be written. ev must have a thread.
+func (w *Writer) startOutput(ev Event) {
+	w.skip = false
+	w.startGen(ev)
+
+	gids := make([]GoID, 0, len(w.gs))
+	for gid := range w.gs {
+		gids = append(gids, gid)
+	}
+	slices.Sort(gids)
+	pids := make([]ProcID, 0, len(w.ps))
+	for pid := range w.ps {
+		pids = append(pids, pid)
+	}
+	slices.Sort(pids)
+
+	// Leave just enough room before ev for everything we write here.
+	n := len(gids) + len(pids) + 1
+	for _, gid := range gids {
+		if w.gs[gid].markAssist {
+			n++
+		}
+	}
+	for _, pid := range pids {
+		if w.ps[pid].sweep {
+			n++
+		}
+	}
+	if ev.Time() > Time(n) {
+		w.lastTs = ev.Time() - Time(n)
+	}
+
+	m := ev.ctx.M
+	next := func() Time { return w.tick(w.lastTs + 1) }
+	for _, pid := range pids {
+		w.emitProcStatus(pid, w.ps[pid], m, next())
+	}
+	for _, gid := range gids {
+		w.emitGoStatus(gid, w.gs[gid], m, next(), NoStack)
+	}
+	if w.gcRunning {
+		w.gcSeq++
+		w.gcActiveGen = w.gen
+		w.emit(m, go122.EvGCActive, next(), w.gcSeq)
+	}
+	for _, pid := range pids {
+		if ps := w.ps[pid]; ps.sweep {
+			// Like the runtime, write this out on the proc's thread if it has one.
+			// Readers may assume that it's the same as the proc being swept.
+			on := m
+			if ps.m != NoThread {
+				on = ps.m
+			}
+			ps.activeGen = w.gen
+			w.emit(on, go122.EvGCSweepActive, next(), uint64(pid))
+		}
+	}
+	for _, gid := range gids {
+		gs := w.gs[gid]
+		if gs.markAssist {
+			gs.activeGen = w.gen
+			w.emit(m, go122.EvGCMarkAssistActive, next(), uint64(gid))
+		}
+		if gs.stw {
+			gs.stw = false
+			gs.stwLost = true
+		}
+	}
+
+	// Tasks can only begin on a running goroutine, so writeEvent
+	// will emit these once it gets a chance.
+	w.taskBegins = w.taskBegins[:0]
+	for _, task := range w.tasks {
+		w.taskBegins = append(w.taskBegins, task)
+	}
+	slices.SortFunc(w.taskBegins, func(a, b Task) int {
+		return cmp.Compare(a.ID, b.ID)
+	})
+}
+
+func (w *Writer) emitGoStatus(gid GoID, gs *writerGState, m ThreadID, t Time, stk Stack) {
+	gs.gen = w.gen
+	gs.seq = 0
+	mid := NoThread
+	if gs.m != NoThread {
+		// Statuses for executing goroutines bind them to their thread,
+		// so they must appear on that thread.
+		mid = gs.m
+		m = gs.m
+	}
+	if stk != NoStack {
+		w.emit(m, go122.EvGoStatusStack, t, uint64(gid), uint64(mid), uint64(gs.status), w.stackID(stk))
+	} else {
+		w.emit(m, go122.EvGoStatus, t, uint64(gid), uint64(mid), uint64(gs.status))
+	}
+}
+
+func (w *Writer) emitProcStatus(pid ProcID, ps *writerPState, m ThreadID, t Time) {
+	ps.gen = w.gen
+	ps.seq = 0
+	if ps.m != NoThread {
+		// Statuses for executing procs bind them to their thread,
+		// so they must appear on that thread.
+		m = ps.m
+	}
+	w.emit(m, go122.EvProcStatus, t, uint64(pid), uint64(ps.status))
+}
+
+// goForTransition returns the state of goroutine gid, making sure its status has
+// been written and that it's in state want.
+func (w *Writer) goForTransition(gid GoID, m ThreadID, t Time, want go122.GoStatus) (*writerGState, error) {
+	if err := w.ensureGoStatus(gid, m, t); err != nil {
+		return nil, err
+	}
+	gs := w.gs[gid]
+	if gs.status != want {
+		return nil, fmt.Errorf("expected goroutine %d to be %v, but it's %v", gid, want, gs.status)
+	}
+	return gs, nil
+}
+
+// procForTransition returns the state of proc pid, making sure its status has
+// been written and that it's in state want.
+func (w *Writer) procForTransition(pid ProcID, m ThreadID, t Time, want go122.ProcStatus) (*writerPState, error) {
+	if err := w.ensureProcStatus(pid, m, t); err != nil {
+		return nil, err
+	}
+	ps := w.ps[pid]
+	if ps.status != want {
+		return nil, fmt.Errorf("expected proc %d to be %v, but it's %v", pid, want, ps.status)
+	}
+	return ps, nil
+}
+
+// curGoroutine returns the state of the goroutine executing on a thread,
+// making sure it's in state want.
+func (w *Writer) curGoroutine(ms *writerMState, typ event.Type, want go122.GoStatus) (*writerGState, error) {
+	if ms.g == NoGoroutine {
+		return nil, fmt.Errorf("%s on thread without a goroutine", go122.EventString(typ))
+	}
+	gs := w.gs[ms.g]
+	if gs.status != want {
+		return nil, fmt.Errorf("%s for goroutine %d that's %v, not %v", go122.EventString(typ), ms.g, gs.status, want)
+	}
+	return gs, nil
+}
+
+// createGoroutine adds state for a new goroutine.
+func (w *Writer) createGoroutine(gid GoID, status go122.GoStatus, m ThreadID) error {
+	if _, ok := w.gs[gid]; ok {
+		return fmt.Errorf("tried to create goroutine %d that already exists", gid)
+	}
+	w.gs[gid] = &writerGState{status: status, m: m, gen: w.gen}
+	return nil
+}
+
+// stealProc takes a proc in a syscall away from its thread and fills in
+// the arguments for the corresponding ProcSteal event.
+func (w *Writer) stealProc(ps *writerPState, args []uint64) {
+	w.mState(ps.m).p = NoProc
+	ps.seq++
+	args[1] = ps.seq
+	args[2] = uint64(ps.m)
+	ps.status = go122.ProcIdle
+	ps.m = NoThread
+}
+
+func (w *Writer) mState(m ThreadID) *writerMState {
+	ms, ok := w.ms[m]
+	if !ok {
+		ms = &writerMState{g: NoGoroutine, p: NoProc}
+		w.ms[m] = ms
+	}
+	return ms
+}
+
+// remapArgs returns the wire format arguments for ev, excluding the timestamp,
+// with string and stack IDs translated to the current generation's tables.
+func (w *Writer) remapArgs(ev Event) []uint64 {
+	spec := &go122.Specs()[ev.base.typ]
+	args := w.args[:len(spec.Args)-1]
+	copy(args, ev.base.args[:])
+	for _, i := range spec.StringIDs {
+		args[i-1] = w.stringID(ev.table.strings.mustGet(stringID(args[i-1])))
+	}
+	for _, i := range spec.StackIDs {
+		args[i-1] = w.stackID(Stack{table: ev.table, id: stackID(args[i-1])})
+	}
+	return args
+}
+
+// tick returns t, or the smallest timestamp after the last one that was
+// handed out if t isn't after it.
+func (w *Writer) tick(t Time) Time {
+	if w.skip {
+		return t
+	}
+	if t <= w.lastTs {
+		t = w.lastTs + 1
+	}
+	w.lastTs = t
+	return t
+}
+
+// stringID returns the ID of s in the current generation's string table,
+// adding it if necessary.
+func (w *Writer) stringID(s string) uint64 {
+	if s == "" || w.skip {
+		return 0
+	}
+	if id, ok := w.strings[s]; ok {
+		return id
+	}
+	id := uint64(len(w.strings) + 1)
+	w.strings[s] = id
+	ev := appendEvent(nil, go122.EvString, id, uint64(len(s)))
+	w.stringEvs = append(w.stringEvs, append(ev, s...))
+	return id
+}
+
+// stackID returns the ID of stk in the current generation's stack table,
+// adding it if necessary.
+func (w *Writer) stackID(stk Stack) uint64 {
+	if stk == NoStack || stk.id == 0 || w.skip {
+		return 0
+	}
+	if id, ok := w.stacks[stk]; ok {
+		return id
+	}
+	id := uint64(len(w.stacks) + 1)
+	w.stacks[stk] = id
+	var frames []uint64
+	stk.Frames(func(f StackFrame) bool {
+		frames = append(frames, f.PC, w.stringID(f.Func), w.stringID(f.File), f.Line)
+		return true
+	})
+	ev := appendEvent(nil, go122.EvStack, id, uint64(len(frames)/4))
+	w.stackEvs = append(w.stackEvs, appendArgs(ev, frames))
+	return id
+}
+
+// startGen starts a new generation if there isn't one in progress.
+func (w *Writer) startGen(ev Event) {
+	if w.skip {
+		return
+	}
+	if !w.inGen {
+		w.inGen = true
+		w.gen++
+		w.genStart = ev.Time()
+		w.batches = make(map[ThreadID]*writerBatch)
+		w.strings = make(map[string]uint64)
+		w.stacks = make(map[Stack]uint64)
+		w.tables = w.tables[:0]
+	}
+	if ev.table != nil && (len(w.tables) == 0 || w.tables[len(w.tables)-1] != ev.table) {
+		if !slices.Contains(w.tables, ev.table) {
+			w.tables = append(w.tables, ev.table)
+		}
+	}
+}
+
+// emit adds an event to the current batch for thread m.
+func (w *Writer) emit(m ThreadID, typ event.Type, t Time, args ...uint64) {
+	if w.skip {
+		return
+	}
+	b, ok := w.batches[m]
+	if !ok {
+		b = &writerBatch{time: t, lastTs: t}
+		w.batches[m] = b
+	}
+	w.buf = appendEvent(w.buf[:0], typ, uint64(t-b.lastTs))
+	w.buf = appendArgs(w.buf, args)
+	if len(b.data)+len(w.buf) > go122.MaxBatchSize {
+		w.writeBatch(go122.EvEventBatch, event.NoExperiment, m, b.time, b.data)
+		b.data = b.data[:0]
+		b.time = t
+		w.buf = appendEvent(w.buf[:0], typ, 0)
+		w.buf = appendArgs(w.buf, args)
+	}
+	b.data = append(b.data, w.buf...)
+	b.lastTs = t
+}
+
+// flushGen writes out everything in the current generation.
+func (w *Writer) flushGen() error {
+	if !w.inGen {
+		return nil
+	}
+	w.inGen = false
+
+	// Write out the remaining event batches.
+	ms := make([]ThreadID, 0, len(w.batches))
+	for m := range w.batches {
+		ms = append(ms, m)
+	}
+	slices.Sort(ms)
+	for _, m := range ms {
+		if b := w.batches[m]; len(b.data) != 0 {
+			w.writeBatch(go122.EvEventBatch, event.NoExperiment, m, b.time, b.data)
+		}
+	}
+
+	// Write out the structural batches.
+	w.writeBatch(go122.EvEventBatch, event.NoExperiment, NoThread, w.genStart, appendEvent(nil, go122.EvFrequency, 1e9))
+	w.writeStructural(go122.EvStacks, w.stackEvs)
+	w.writeStructural(go122.EvStrings, w.stringEvs)
+	w.writeStructural(go122.EvCPUSamples, w.samples)
+	w.stackEvs, w.stringEvs, w.samples = nil, nil, nil
+
+	// Write out experimental data for the generations we got events from.
+	for _, t := range w.tables {
+		exps := make([]event.Experiment, 0, len(t.expData))
+		for exp := range t.expData {
+			exps = append(exps, exp)
+		}
+		slices.Sort(exps)
+		for _, exp := range exps {
+			for _, b := range t.expData[exp].Batches {
+				w.writeBatch(go122.EvExperimentalBatch, exp, b.Thread, w.genStart, b.Data)
+			}
+		}
+	}
+	w.batches = nil
+	w.strings = nil
+	w.stacks = nil
+	w.tables = w.tables[:0]
+	return w.err
+}
+
+// writeStructural writes out evs in as many batches of type typ as necessary.
+func (w *Writer) writeStructural(typ event.Type, evs [][]byte) {
+	if len(evs) == 0 {
+		return
+	}
+	data := []byte{byte(typ)}
+	for _, ev := range evs {
+		if len(data)+len(ev) > go122.MaxBatchSize {
+			w.writeBatch(go122.EvEventBatch, event.NoExperiment, NoThread, w.genStart, data)
+			data = data[:1]
+		}
+		data = append(data, ev...)
+	}
+	w.writeBatch(go122.EvEventBatch, event.NoExperiment, NoThread, w.genStart, data)
+}
+
+// writeBatch writes a complete batch to the underlying io.Writer.
+func (w *Writer) writeBatch(typ event.Type, exp event.Experiment, m ThreadID, t Time, data []byte) {
+	if w.err != nil {
+		return
+	}
+	hdr := []byte{byte(typ)}
+	if typ == go122.EvExperimentalBatch {
+		hdr = append(hdr, byte(exp))
+	}
+	hdr = appendArgs(hdr, []uint64{w.gen, uint64(m), uint64(t), uint64(len(data))})
+	if _, err := w.w.Write(hdr); err != nil {
+		w.err = err
+		return
+	}
+	if _, err := w.w.Write(data); err != nil {
+		w.err = err
+	}
+}
+
+// appendEvent appends the encoding of an event with the provided arguments to b.
+func appendEvent(b []byte, typ event.Type, args ...uint64) []byte {
+	return appendArgs(append(b, byte(typ)), args)
+}
+
+func appendArgs(b []byte, args []uint64) []byte {
+	for _, arg := range args {
+		b = binary.AppendUvarint(b, arg)
+	}
+	return b
+}
@@ -0,0 +1,157 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.21
+
+package trace_test
+
+import (
+	"bytes"
+	"io"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"golang.org/x/exp/trace"
+	"golang.org/x/exp/trace/internal/testtrace"
+)
+
+func TestWriterRoundTrip(t *testing.T) {
+	matches, err := filepath.Glob("./testdata/tests/*.test")
+	if err != nil {
+		t.Fatalf("failed to glob for tests: %v", err)
+	}
+	for _, testPath := range matches {
+		testPath := testPath
+		testName, err := filepath.Rel("./testdata", testPath)
+		if err != nil {
+			t.Fatalf("failed to relativize testdata path: %v", err)
+		}
+		t.Run(testName, func(t *testing.T) {
+			tr, exp, err := testtrace.ParseFile(testPath)
+			if err != nil {
+				t.Fatalf("failed to parse test file at %s: %v", testPath, err)
+			}
+			if exp.Check(nil) != nil {
+				t.Skip("trace is expected to fail to parse")
+			}
+			want := readAllEvents(t, tr)
+
+			var buf bytes.Buffer
+			w, err := trace.NewWriter(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, ev := range want {
+				if err := w.WriteEvent(ev); err != nil {
+					t.Fatalf("writing %s: %v", ev.String(), err)
+				}
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+			testReader(t, bytes.NewReader(buf.Bytes()), testtrace.ExpectSuccess())
+			got := readAllEvents(t, bytes.NewReader(buf.Bytes()))
+			compareEvents(t, got, want)
+		})
+	}
+}
+
+func TestWriterDropEvents(t *testing.T) {
+	tr, _, err := testtrace.ParseFile("./testdata/tests/go122-annotations.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want []trace.Event
+	var buf bytes.Buffer
+	w, err := trace.NewWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ev := range readAllEvents(t, tr) {
+		switch ev.Kind() {
+		case trace.EventLabel, trace.EventLog:
+			continue
+		}
+		want = append(want, ev)
+		if err := w.WriteEvent(ev); err != nil {
+			t.Fatalf("writing %s: %v", ev.String(), err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	testReader(t, bytes.NewReader(buf.Bytes()), testtrace.ExpectSuccess())
+	compareEvents(t, readAllEvents(t, bytes.NewReader(buf.Bytes())), want)
+}
+
+func TestWriterInconsistent(t *testing.T) {
+	tr, _, err := testtrace.ParseFile("./testdata/tests/go122-annotations.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := trace.NewWriter(io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dropped := false
+	for _, ev := range readAllEvents(t, tr) {
+		if !dropped && ev.Kind() == trace.EventStateTransition && ev.StateTransition().Resource.Kind == trace.ResourceGoroutine {
+			from, to := ev.StateTransition().Goroutine()
+			if from == trace.GoRunnable && to == trace.GoRunning {
+				// Dropping a goroutine starting leaves the trace inconsistent.
+				dropped = true
+				continue
+			}
+		}
+		if err = w.WriteEvent(ev); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		t.Fatal("expected error writing inconsistent events")
+	}
+	if err2 := w.WriteEvent(trace.Event{}); err2 != err {
+		t.Errorf("expected subsequent writes to fail with %v, got %v", err, err2)
+	}
+}
+
+func readAllEvents(t *testing.T, tr io.Reader) []trace.Event {
+	t.Helper()
+
+	r, err := trace.NewReader(tr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var evs []trace.Event
+	for {
+		ev, err := r.ReadEvent()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		evs = append(evs, ev)
+	}
+	return evs
+}
+
+var timeRE = regexp.MustCompile(`Time=\d+`)
+
+// compareEvents checks that two event streams are the same, ignoring timestamps.
+func compareEvents(t *testing.T, got, want []trace.Event) {
+	t.Helper()
+
+	for i := 0; i < len(got) && i < len(want); i++ {
+		g := timeRE.ReplaceAllString(got[i].String(), "")
+		w := timeRE.ReplaceAllString(want[i].String(), "")
+		if g != w {
+			t.Fatalf("event %d differs:\ngot:  %s\nwant: %s", i, got[i].String(), want[i].String())
+		}
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d events, want %d", len(got), len(want))
+	}
+}